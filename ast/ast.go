@@ -103,6 +103,32 @@ func (rs *ReturnStatement) String() string {
 }
 
 
+type ThrowStatement struct {
+    Token token.Token   // token.THROW token
+    Value Expression
+}
+
+func (ts *ThrowStatement) statementNode() {}
+
+func (ts *ThrowStatement) TokenLiteral() string {
+    return ts.Token.Literal
+}
+
+func (ts *ThrowStatement) String() string {
+    var output bytes.Buffer
+
+    output.WriteString(ts.TokenLiteral() + " ")
+
+    if ts.Value != nil {
+        output.WriteString(ts.Value.String())
+    }
+
+    output.WriteString(";")
+
+    return output.String()
+}
+
+
 // for statements like `x + 10;`
 type ExpressionStatement struct {
     Token token.Token
@@ -128,6 +154,7 @@ func (es *ExpressionStatement) String() string {
 type BlockStatement struct {
     Token token.Token       // the { token
     Statements []Statement
+    End token.Token         // the } token
 }
 
 func (bs *BlockStatement) statementNode() {}
@@ -150,9 +177,15 @@ func (bs *BlockStatement) String() string {
 //              EXPRESSIONS
 // ---------------------------------------------------------------
 
+// Identifier is either looked up by name at run time, as globals and builtins
+// are, or, once the resolver has marked it Local, found in slot Slot of the
+// frame of the function Depth levels up from where it appears.
 type Identifier struct {
     Token token.Token   // token.IDENT token
     Value string
+    Local bool
+    Depth int
+    Slot int
 }
 
 func (i *Identifier) expressionNode() {}
@@ -180,6 +213,21 @@ func (il *IntegerLiteral) String() string {
     return il.Token.Literal
 }
 
+type FloatLiteral struct {
+    Token token.Token
+    Value float64
+}
+
+func (fl *FloatLiteral) expressionNode() {}
+
+func (fl *FloatLiteral) TokenLiteral() string {
+    return fl.Token.Literal
+}
+
+func (fl *FloatLiteral) String() string {
+    return fl.Token.Literal
+}
+
 type StringLiteral struct {
     Token token.Token
     Value string
@@ -258,6 +306,20 @@ func (b *Boolean) String() string {
     return b.Token.Literal
 }
 
+type NullLiteral struct {
+    Token token.Token       // the null token
+}
+
+func (nl *NullLiteral) expressionNode() {}
+
+func (nl *NullLiteral) TokenLiteral() string {
+    return nl.Token.Literal
+}
+
+func (nl *NullLiteral) String() string {
+    return nl.Token.Literal
+}
+
 type IfExpression struct {
     Token token.Token       // the IF token
     Condition Expression
@@ -291,6 +353,9 @@ type FunctionLiteral struct {
     Token token.Token       // the Fn token
     Parameters []*Identifier
     Body *BlockStatement
+    Name string             // set when the literal is bound by a let statement
+    Resolved bool           // locals are in slots, see Identifier
+    Slots int               // size of the frame, parameters first
 }
 
 func (fl *FunctionLiteral) expressionNode() {}
@@ -317,10 +382,73 @@ func (fl *FunctionLiteral) String() string {
     return out.String()
 }
 
+type TryExpression struct {
+    Token token.Token       // the try token
+    Block *BlockStatement
+    Catches []*CatchClause  // tried in order
+    Finally *BlockStatement // nil when there is no finally block
+}
+
+func (te *TryExpression) expressionNode() {}
+
+func (te *TryExpression) TokenLiteral() string {
+    return te.Token.Literal
+}
+
+func (te *TryExpression) String() string {
+    var out bytes.Buffer
+
+    out.WriteString("try ")
+    out.WriteString(te.Block.String())
+
+    for _, clause := range te.Catches {
+        out.WriteString(" ")
+        out.WriteString(clause.String())
+    }
+
+    if te.Finally != nil {
+        out.WriteString(" finally ")
+        out.WriteString(te.Finally.String())
+    }
+
+    return out.String()
+}
+
+type CatchClause struct {
+    Token token.Token       // the catch token
+    Kind *Identifier        // only catch errors of this kind, nil catches everything
+    Parameter *Identifier   // nil for `catch { ... }`
+    Body *BlockStatement
+}
+
+func (cc *CatchClause) TokenLiteral() string {
+    return cc.Token.Literal
+}
+
+func (cc *CatchClause) String() string {
+    var out bytes.Buffer
+
+    out.WriteString("catch ")
+
+    if cc.Parameter != nil {
+        out.WriteString("(")
+        if cc.Kind != nil {
+            out.WriteString(cc.Kind.String() + " ")
+        }
+        out.WriteString(cc.Parameter.String())
+        out.WriteString(") ")
+    }
+
+    out.WriteString(cc.Body.String())
+
+    return out.String()
+}
+
 type CallExpression struct {
     Token token.Token       // the '(' token
     Function Expression     // Identifier or FunctionLiteral
     Arguments []Expression
+    Tail bool               // the call is the last thing its function does, see parser.markTailCalls
 }
 
 func (ce *CallExpression) expressionNode() {}
@@ -396,9 +524,14 @@ func (ie *IndexExpression) String() string {
     return out.String()
 }
 
+type HashPair struct {
+    Key Expression
+    Value Expression
+}
+
 type HashLiteral struct {
     Token token.Token       // the { token
-    Pairs map[Expression]Expression
+    Pairs []HashPair        // in source order
 }
 
 func (hl *HashLiteral) expressionNode() {}
@@ -411,8 +544,8 @@ func (hl *HashLiteral) String() string {
     var out bytes.Buffer
 
     pairs := []string{}
-    for key, value := range hl.Pairs {
-        pairs = append(pairs, key.String() + ": " + value.String())
+    for _, pair := range hl.Pairs {
+        pairs = append(pairs, pair.Key.String() + ": " + pair.Value.String())
     }
 
     out.WriteString("{")
@@ -0,0 +1,250 @@
+package ast
+
+import (
+	"fmt"
+)
+
+// Visitor is called by Walk for every node. If it returns a visitor w, Walk
+// visits the children of the node with w, followed by w.Visit(nil).
+type Visitor interface {
+    Visit(node Node) (w Visitor)
+}
+
+// Walk traverses the tree rooted at node depth first: it calls v.Visit(node)
+// and then walks the children of node in source order with the visitor it
+// returned. Children not set, like a missing else block, are skipped.
+// Identifiers that are bound, by let statements, parameters and catch
+// clauses, are visited like any other.
+func Walk(v Visitor, node Node) {
+    if v = v.Visit(node); v == nil {
+        return
+    }
+
+    switch n := node.(type) {
+    case *Program:
+        walkStatements(v, n.Statements)
+    case *LetStatement:
+        Walk(v, n.Name)
+        walkExpression(v, n.Value)
+    case *ReturnStatement:
+        walkExpression(v, n.ReturnValue)
+    case *ThrowStatement:
+        walkExpression(v, n.Value)
+    case *ExpressionStatement:
+        walkExpression(v, n.Expression)
+    case *BlockStatement:
+        walkStatements(v, n.Statements)
+    case *PrefixExpression:
+        walkExpression(v, n.Right)
+    case *InfixExpression:
+        walkExpression(v, n.Left)
+        walkExpression(v, n.Right)
+    case *IfExpression:
+        walkExpression(v, n.Condition)
+        walkBlock(v, n.Consequence)
+        walkBlock(v, n.Alternative)
+    case *FunctionLiteral:
+        for _, param := range n.Parameters {
+            Walk(v, param)
+        }
+        walkBlock(v, n.Body)
+    case *TryExpression:
+        walkBlock(v, n.Block)
+        for _, clause := range n.Catches {
+            Walk(v, clause)
+        }
+        walkBlock(v, n.Finally)
+    case *CatchClause:
+        if n.Kind != nil {
+            Walk(v, n.Kind)
+        }
+        if n.Parameter != nil {
+            Walk(v, n.Parameter)
+        }
+        walkBlock(v, n.Body)
+    case *CallExpression:
+        walkExpression(v, n.Function)
+        for _, arg := range n.Arguments {
+            walkExpression(v, arg)
+        }
+    case *ArrayLiteral:
+        for _, elem := range n.Elements {
+            walkExpression(v, elem)
+        }
+    case *IndexExpression:
+        walkExpression(v, n.Left)
+        walkExpression(v, n.Index)
+    case *HashLiteral:
+        for _, pair := range n.Pairs {
+            walkExpression(v, pair.Key)
+            walkExpression(v, pair.Value)
+        }
+    case *Identifier, *IntegerLiteral, *FloatLiteral, *StringLiteral, *Boolean, *NullLiteral:
+        // no children
+    default:
+        panic(fmt.Sprintf("ast.Walk: unexpected node type %T", n))
+    }
+
+    v.Visit(nil)
+}
+
+// the fields below may hold nil, which is not walked
+
+func walkStatements(v Visitor, statements []Statement) {
+    for _, statement := range statements {
+        if statement != nil {
+            Walk(v, statement)
+        }
+    }
+}
+
+func walkExpression(v Visitor, node Expression) {
+    if node != nil {
+        Walk(v, node)
+    }
+}
+
+func walkBlock(v Visitor, block *BlockStatement) {
+    if block != nil {
+        Walk(v, block)
+    }
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+    if f(node) {
+        return f
+    }
+    return nil
+}
+
+// Inspect traverses the tree rooted at node like Walk, calling f for every
+// node. The children of a node are only inspected if f returns true for it,
+// and are followed by a call f(nil).
+func Inspect(node Node, f func(Node) bool) {
+    Walk(inspector(f), node)
+}
+
+// Modify rewrites the tree rooted at node bottom up: the children of a node
+// are modified first and replaced by what modifier returns for them, then
+// modifier is called for the node itself and its result returned. Returning
+// the node passed in keeps it.
+//
+// A statement replaced by nil is removed from its program or block. Fields
+// holding a particular type of node, like the name of a let statement or the
+// body of a function, have to be replaced by a node of that type, Modify
+// panics otherwise.
+func Modify(node Node, modifier func(Node) Node) Node {
+    switch n := node.(type) {
+    case *Program:
+        n.Statements = modifyStatements(n.Statements, modifier)
+    case *LetStatement:
+        n.Name = modifyIdentifier(n.Name, modifier)
+        n.Value = modifyExpression(n.Value, modifier)
+    case *ReturnStatement:
+        n.ReturnValue = modifyExpression(n.ReturnValue, modifier)
+    case *ThrowStatement:
+        n.Value = modifyExpression(n.Value, modifier)
+    case *ExpressionStatement:
+        n.Expression = modifyExpression(n.Expression, modifier)
+    case *BlockStatement:
+        n.Statements = modifyStatements(n.Statements, modifier)
+    case *PrefixExpression:
+        n.Right = modifyExpression(n.Right, modifier)
+    case *InfixExpression:
+        n.Left = modifyExpression(n.Left, modifier)
+        n.Right = modifyExpression(n.Right, modifier)
+    case *IfExpression:
+        n.Condition = modifyExpression(n.Condition, modifier)
+        n.Consequence = modifyBlock(n.Consequence, modifier)
+        n.Alternative = modifyBlock(n.Alternative, modifier)
+    case *FunctionLiteral:
+        for i, param := range n.Parameters {
+            n.Parameters[i] = modifyIdentifier(param, modifier)
+        }
+        n.Body = modifyBlock(n.Body, modifier)
+    case *TryExpression:
+        n.Block = modifyBlock(n.Block, modifier)
+        for i, clause := range n.Catches {
+            n.Catches[i] = modifyAs[*CatchClause](clause, modifier)
+        }
+        n.Finally = modifyBlock(n.Finally, modifier)
+    case *CatchClause:
+        n.Kind = modifyIdentifier(n.Kind, modifier)
+        n.Parameter = modifyIdentifier(n.Parameter, modifier)
+        n.Body = modifyBlock(n.Body, modifier)
+    case *CallExpression:
+        n.Function = modifyExpression(n.Function, modifier)
+        for i, arg := range n.Arguments {
+            n.Arguments[i] = modifyExpression(arg, modifier)
+        }
+    case *ArrayLiteral:
+        for i, elem := range n.Elements {
+            n.Elements[i] = modifyExpression(elem, modifier)
+        }
+    case *IndexExpression:
+        n.Left = modifyExpression(n.Left, modifier)
+        n.Index = modifyExpression(n.Index, modifier)
+    case *HashLiteral:
+        for i, pair := range n.Pairs {
+            n.Pairs[i] = HashPair{Key: modifyExpression(pair.Key, modifier), Value: modifyExpression(pair.Value, modifier)}
+        }
+    case *Identifier, *IntegerLiteral, *FloatLiteral, *StringLiteral, *Boolean, *NullLiteral:
+        // no children
+    default:
+        panic(fmt.Sprintf("ast.Modify: unexpected node type %T", n))
+    }
+
+    return modifier(node)
+}
+
+func modifyStatements(statements []Statement, modifier func(Node) Node) []Statement {
+    kept := statements[:0]
+    for _, statement := range statements {
+        if statement == nil {
+            continue
+        }
+        if modified := modifyAs[Statement](statement, modifier); modified != nil {
+            kept = append(kept, modified)
+        }
+    }
+    return kept
+}
+
+func modifyExpression(node Expression, modifier func(Node) Node) Expression {
+    if node == nil {
+        return nil
+    }
+    return modifyAs[Expression](node, modifier)
+}
+
+func modifyBlock(block *BlockStatement, modifier func(Node) Node) *BlockStatement {
+    if block == nil {
+        return nil
+    }
+    return modifyAs[*BlockStatement](block, modifier)
+}
+
+func modifyIdentifier(ident *Identifier, modifier func(Node) Node) *Identifier {
+    if ident == nil {
+        return nil
+    }
+    return modifyAs[*Identifier](ident, modifier)
+}
+
+// modifyAs modifies node, which has to stay a T. nil is passed on as the
+// zero value of T.
+func modifyAs[T Node](node T, modifier func(Node) Node) T {
+    modified := Modify(node, modifier)
+    if modified == nil {
+        var zero T
+        return zero
+    }
+
+    result, ok := modified.(T)
+    if !ok {
+        panic(fmt.Sprintf("ast.Modify: %T cannot replace %T", modified, node))
+    }
+    return result
+}
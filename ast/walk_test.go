@@ -0,0 +1,182 @@
+package ast_test
+
+import (
+	"fmt"
+	"monkey/ast"
+	"monkey/lexer"
+	"monkey/parser"
+	"strings"
+	"testing"
+)
+
+// every type of node, in the order Inspect visits them
+const everyNode = `let f = fn(a) { return -a; };
+if (true) { throw null } else { 1.5 };
+try { f(2)[0] } catch (TypeError e) { e } catch { "s" } finally { {"k": [3]} }`
+
+var everyNodeOrder = []string{
+    "Program",
+    "LetStatement", "Identifier", "FunctionLiteral", "Identifier", "BlockStatement",
+    "ReturnStatement", "PrefixExpression", "Identifier",
+    "ExpressionStatement", "IfExpression", "Boolean",
+    "BlockStatement", "ThrowStatement", "NullLiteral",
+    "BlockStatement", "ExpressionStatement", "FloatLiteral",
+    "ExpressionStatement", "TryExpression",
+    "BlockStatement", "ExpressionStatement", "IndexExpression", "CallExpression", "Identifier", "IntegerLiteral", "IntegerLiteral",
+    "CatchClause", "Identifier", "Identifier", "BlockStatement", "ExpressionStatement", "Identifier",
+    "CatchClause", "BlockStatement", "ExpressionStatement", "StringLiteral",
+    "BlockStatement", "ExpressionStatement", "HashLiteral", "StringLiteral", "ArrayLiteral", "IntegerLiteral",
+}
+
+func parse(t *testing.T, input string) *ast.Program {
+    t.Helper()
+
+    p := parser.New(lexer.New(input))
+    program := p.ParseProgram()
+    if len(p.Errors()) != 0 {
+        t.Fatalf("parser errors for %q: %v", input, p.Errors())
+    }
+    return program
+}
+
+func typeName(node ast.Node) string {
+    return strings.TrimPrefix(fmt.Sprintf("%T", node), "*ast.")
+}
+
+func TestInspect(t *testing.T) {
+    var visited []string
+    nils := 0
+
+    ast.Inspect(parse(t, everyNode), func(node ast.Node) bool {
+        if node == nil {
+            nils++
+        } else {
+            visited = append(visited, typeName(node))
+        }
+        return true
+    })
+
+    if strings.Join(visited, " ") != strings.Join(everyNodeOrder, " ") {
+        t.Errorf("wrong nodes visited.\nexpected=%v\ngot=     %v", everyNodeOrder, visited)
+    }
+    if nils != len(visited) {
+        t.Errorf("expected a nil for each of the %d nodes. got=%d", len(visited), nils)
+    }
+}
+
+func TestInspectSkipsChildren(t *testing.T) {
+    var idents []string
+
+    ast.Inspect(parse(t, "let x = fn(y) { z }; x(w)"), func(node ast.Node) bool {
+        if ident, ok := node.(*ast.Identifier); ok {
+            idents = append(idents, ident.Value)
+        }
+        _, isFunction := node.(*ast.FunctionLiteral)
+        return !isFunction
+    })
+
+    if strings.Join(idents, " ") != "x x w" {
+        t.Errorf("wrong identifiers visited. got=%v", idents)
+    }
+}
+
+// depthVisitor records the nesting of the nodes it visits
+type depthVisitor struct {
+    depth int
+    lines *[]string
+}
+
+func (v depthVisitor) Visit(node ast.Node) ast.Visitor {
+    if node == nil {
+        return nil
+    }
+    *v.lines = append(*v.lines, strings.Repeat(" ", v.depth) + typeName(node))
+    return depthVisitor{v.depth + 1, v.lines}
+}
+
+func TestWalk(t *testing.T) {
+    var lines []string
+    ast.Walk(depthVisitor{lines: &lines}, parse(t, "-a + b[1]"))
+
+    expected := []string{
+        "Program",
+        " ExpressionStatement",
+        "  InfixExpression",
+        "   PrefixExpression",
+        "    Identifier",
+        "   IndexExpression",
+        "    Identifier",
+        "    IntegerLiteral",
+    }
+    if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+        t.Errorf("wrong walk.\nexpected=%q\ngot=     %q", expected, lines)
+    }
+}
+
+func TestModify(t *testing.T) {
+    double := func(node ast.Node) ast.Node {
+        if integer, ok := node.(*ast.IntegerLiteral); ok {
+            integer.Value *= 2
+            integer.Token.Literal = fmt.Sprint(integer.Value)
+        }
+        return node
+    }
+
+    tests := []struct {
+        input string
+        expected string
+    }{
+        {"1 + 2", "(2 + 4)"},
+        {"-1; !f(2)", "(-2)(!f(4))"},
+        {"let a = [1, {2: 3}][4]", "let a = ([2, {4: 6}][8]);"},
+        {"return 1; throw 2", "return 2;throw 4;"},
+        {"if (1) { 2 } else { 3 }", "if 2 4else 6"},
+        {"fn(x) { 1 }", "fn(x)2"},
+        {"try { 1 } catch (e) { 2 } finally { 3 }", "try 2 catch (e) 4 finally 6"},
+    }
+
+    for _, tt := range tests {
+        got := ast.Modify(parse(t, tt.input), double).String()
+        if got != tt.expected {
+            t.Errorf("wrong result for %q. expected=%q, got=%q", tt.input, tt.expected, got)
+        }
+    }
+}
+
+func TestModifyReplaces(t *testing.T) {
+    program := parse(t, "let x = a; puts(a); a; fn(a) { a }")
+
+    // rename a to b and drop statements that are just a name
+    modified := ast.Modify(program, func(node ast.Node) ast.Node {
+        switch node := node.(type) {
+        case *ast.Identifier:
+            if node.Value == "a" {
+                return &ast.Identifier{Token: node.Token, Value: "b"}
+            }
+        case *ast.ExpressionStatement:
+            if _, ok := node.Expression.(*ast.Identifier); ok {
+                return nil
+            }
+        }
+        return node
+    })
+
+    if got := modified.String(); got != "let x = b;puts(b)fn(b)" {
+        t.Errorf("wrong result. got=%q", got)
+    }
+}
+
+func TestModifyWrongType(t *testing.T) {
+    defer func() {
+        if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), "cannot replace *ast.Identifier") {
+            t.Errorf("expected a panic for replacing a let's name. got=%v", r)
+        }
+    }()
+
+    ast.Modify(parse(t, "let x = 1"), func(node ast.Node) ast.Node {
+        if _, ok := node.(*ast.Identifier); ok {
+            return &ast.NullLiteral{}
+        }
+        return node
+    })
+}
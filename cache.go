@@ -0,0 +1,86 @@
+package monkey
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"monkey/compiler"
+	"monkey/object"
+	"os"
+	"path/filepath"
+)
+
+// runCached runs src from the bytecode cached for it, compiling and caching
+// it first if there is none
+func (in *Interpreter) runCached(ctx context.Context, src []byte) (object.Object, error) {
+    if err := ctx.Err(); err != nil {
+        return nil, err
+    }
+
+    path := in.cachePath(src)
+    if bytecode, err := readCache(path); err == nil {
+        return in.run(ctx, bytecode)
+    }
+
+    program, err := in.parse(string(src))
+    if err != nil {
+        return nil, err
+    }
+
+    bytecode, err := compiler.Compile(program)
+    if err != nil {
+        return nil, err
+    }
+
+    // before running, so that scripts failing at runtime are cached too
+    writeCache(path, bytecode)
+    return in.run(ctx, bytecode)
+}
+
+// cachePath names the cache file for src. Besides the source, the key covers
+// the format version, so that different versions of monkey do not keep
+// replacing each other's files, whether names were checked and whether the
+// program was optimized.
+func (in *Interpreter) cachePath(src []byte) string {
+    h := sha256.New()
+    fmt.Fprintf(h, "monkey bytecode %d, names checked %t, optimized %t\n", compiler.FormatVersion, in.checkNames, in.optimize)
+    h.Write(src)
+    return filepath.Join(in.cacheDir, hex.EncodeToString(h.Sum(nil)) + ".mkc")
+}
+
+func readCache(path string) (*compiler.Bytecode, error) {
+    f, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+
+    return compiler.Decode(f)
+}
+
+// writeCache stores bytecode at path, ignoring errors since the script runs
+// either way. It writes a temporary file first so that concurrent runs never
+// see half a file.
+func writeCache(path string, bytecode *compiler.Bytecode) {
+    dir := filepath.Dir(path)
+    if err := os.MkdirAll(dir, 0o755); err != nil {
+        return
+    }
+
+    f, err := os.CreateTemp(dir, "*.tmp")
+    if err != nil {
+        return
+    }
+
+    err = bytecode.Encode(f)
+    if closeErr := f.Close(); err == nil {
+        err = closeErr
+    }
+    if err == nil {
+        err = os.Rename(f.Name(), path)
+    }
+    if err != nil {
+        os.Remove(f.Name())
+    }
+}
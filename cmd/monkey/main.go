@@ -0,0 +1,230 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "monkey"
+    "monkey/formatter"
+    "os"
+    "os/user"
+    "path/filepath"
+    "strings"
+    "monkey/repl"
+)
+
+const MONKEY_FACE = `            __,__
+   .--.  .-"     "-.  .--.
+  / .. \/  .-. .-.  \/ .. \
+ | |  '|  /   Y   \  |'  | |
+ | \   \  \ 0 | 0 /  /   / |
+  \ '- ,\.-"""""""-./, -' /
+   ''-' /_   ^ ^   _\ '-''
+       |  \._   _./  |
+       \   \ '~' /   /
+        '._ '-=-' _.'
+           '-----'
+`
+
+var engines = map[string]monkey.Engine{
+    "eval": monkey.EngineEval,
+    "vm": monkey.EngineVM,
+}
+
+func main() {
+    // `monkey fmt [-w] [-check] files...` formats source
+    if len(os.Args) > 1 && os.Args[1] == "fmt" {
+        os.Exit(formatFiles(os.Args[2:]))
+    }
+
+    // `monkey run file.mk` runs a script on the vm, from its cached bytecode
+    // unless it changed
+    if len(os.Args) > 1 && os.Args[1] == "run" {
+        os.Exit(runScript(os.Args[2:], os.Stdout, os.Stderr))
+    }
+
+    options := scriptFlags(flag.CommandLine, "eval")
+    listen := flag.String("listen", "", "serve the REPL on a TCP address, or a Unix socket if it contains a slash")
+    token := flag.String("token", os.Getenv("MONKEY_TOKEN"), "token connections to -listen have to enter first")
+    flag.Parse()
+
+    opts, err := options()
+    if err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        os.Exit(2)
+    }
+
+    // `monkey file.mk` runs a script, no arguments starts the REPL
+    if flag.NArg() > 0 {
+        os.Exit(runFile(flag.Arg(0), opts...))
+    }
+
+    if *listen != "" {
+        os.Exit(serve(*listen, *token))
+    }
+
+    user, err := user.Current()
+
+    if err != nil {
+        panic(err)
+    }
+
+    fmt.Printf(MONKEY_FACE)
+    fmt.Printf("Hello %s. This is the Monkey Programming Language!\n", user.Username)
+    fmt.Printf("Start typing away\n")
+    repl.Start(os.Stdin, os.Stdout)
+}
+
+// scriptFlags defines the flags for running scripts on flags, with engine as
+// the default engine, and returns a function giving the options they select
+// once parsed
+func scriptFlags(flags *flag.FlagSet, engine string) func() ([]monkey.Option, error) {
+    engineName := flags.String("engine", engine, "engine running scripts: eval walks the syntax tree, vm compiles to bytecode")
+    cacheDir := flags.String("cache", defaultCacheDir(), "directory the vm engine caches compiled scripts in, empty to disable")
+    optimize := flags.Bool("optimize", false, "fold constants and prune dead branches before running scripts")
+
+    return func() ([]monkey.Option, error) {
+        engine, ok := engines[*engineName]
+        if !ok {
+            return nil, fmt.Errorf("unknown engine %q, want eval or vm", *engineName)
+        }
+
+        opts := []monkey.Option{monkey.WithNameCheck(), monkey.WithEngine(engine), monkey.WithCache(*cacheDir)}
+        if *optimize {
+            opts = append(opts, monkey.WithOptimizer())
+        }
+        return opts, nil
+    }
+}
+
+// runScript runs the script named in args with the vm engine, so that the
+// cache applies unless -cache "" turns it off
+func runScript(args []string, stdout, stderr io.Writer) int {
+    flags := flag.NewFlagSet("run", flag.ContinueOnError)
+    flags.SetOutput(stderr)
+    options := scriptFlags(flags, "vm")
+    if err := flags.Parse(args); err != nil {
+        return 2
+    }
+
+    opts, err := options()
+    if err == nil && flags.NArg() != 1 {
+        err = errors.New("usage: monkey run [flags] file.mk")
+    }
+    if err != nil {
+        fmt.Fprintln(stderr, err)
+        return 2
+    }
+
+    return runFile(flags.Arg(0), append(opts, monkey.WithStdout(stdout), monkey.WithStderr(stderr))...)
+}
+
+func runFile(path string, opts ...monkey.Option) int {
+    interpreter := monkey.New(opts...)
+
+    if _, err := interpreter.RunFile(context.Background(), path); err != nil {
+        fmt.Fprintln(interpreter.Stderr(), err)
+        return 1
+    }
+
+    return 0
+}
+
+func serve(address, token string) int {
+    network := "tcp"
+    if strings.Contains(address, "/") {
+        network = "unix"
+    }
+
+    // anyone on the host can connect over TCP, even on loopback
+    if network == "tcp" && token == "" {
+        fmt.Fprintln(os.Stderr, "serving the REPL over TCP needs a token, set -token or MONKEY_TOKEN")
+        return 2
+    }
+
+    fmt.Fprintf(os.Stderr, "serving the REPL on %s %s\n", network, address)
+    srv := &repl.Server{Token: token}
+    if err := srv.ListenAndServe(network, address); err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        return 1
+    }
+    return 0
+}
+
+// formatFiles formats the files named in args, or standard input if there are
+// none, and prints the result. -w writes it back to the files instead, -check
+// only lists the files not formatted and fails if there are any.
+func formatFiles(args []string) int {
+    flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+    write := flags.Bool("w", false, "write the result to the files instead of printing it")
+    check := flags.Bool("check", false, "list the files whose formatting differs and fail if there are any")
+    flags.Parse(args)
+
+    if flags.NArg() == 0 {
+        src, err := io.ReadAll(os.Stdin)
+        if err != nil {
+            fmt.Fprintln(os.Stderr, err)
+            return 1
+        }
+        return formatFile("<stdin>", src, false, *check)
+    }
+
+    status := 0
+    for _, path := range flags.Args() {
+        src, err := os.ReadFile(path)
+        if err != nil {
+            fmt.Fprintln(os.Stderr, err)
+            status = 1
+            continue
+        }
+        if formatFile(path, src, *write, *check) != 0 {
+            status = 1
+        }
+    }
+    return status
+}
+
+func formatFile(path string, src []byte, write, check bool) int {
+    formatted, err := formatter.Source(string(src))
+
+    var parseErr *formatter.ParseError
+    if errors.As(err, &parseErr) {
+        for _, msg := range parseErr.Errors {
+            fmt.Fprintf(os.Stderr, "%s: %s\n", path, msg)
+        }
+        return 1
+    }
+    // nothing is written unless formatting succeeded
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+        return 1
+    }
+
+    switch {
+    case check:
+        if formatted != string(src) {
+            fmt.Println(path)
+            return 1
+        }
+    case write:
+        if formatted != string(src) {
+            if err := os.WriteFile(path, []byte(formatted), 0644); err != nil {
+                fmt.Fprintln(os.Stderr, err)
+                return 1
+            }
+        }
+    default:
+        io.WriteString(os.Stdout, formatted)
+    }
+    return 0
+}
+
+func defaultCacheDir() string {
+    dir, err := os.UserCacheDir()
+    if err != nil {
+        return ""
+    }
+    return filepath.Join(dir, "monkey")
+}
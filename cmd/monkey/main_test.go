@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"monkey/compiler"
+	"monkey/lexer"
+	"monkey/parser"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunScriptCache(t *testing.T) {
+    dir := t.TempDir()
+    cacheDir := filepath.Join(dir, "cache")
+    path := filepath.Join(dir, "script.mk")
+    if err := os.WriteFile(path, []byte(`puts("from source")`), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    run := func(args ...string) string {
+        t.Helper()
+
+        var stdout, stderr bytes.Buffer
+        if status := runScript(append(args, path), &stdout, &stderr); status != 0 {
+            t.Fatalf("run %v failed with %d: %s", args, status, stderr.String())
+        }
+        return stdout.String()
+    }
+
+    if got := run("-cache", cacheDir); got != "from source\n" {
+        t.Fatalf("wrong output of the first run. got=%q", got)
+    }
+    cached, err := filepath.Glob(filepath.Join(cacheDir, "*.mkc"))
+    if err != nil || len(cached) != 1 {
+        t.Fatalf("expected one cached script, got %v (%v)", cached, err)
+    }
+
+    // a second run loads the cached bytecode without parsing the source, so
+    // replacing it shows
+    bytecode, err := compiler.Compile(parser.New(lexer.New(`puts("from cache")`)).ParseProgram())
+    if err != nil {
+        t.Fatal(err)
+    }
+    var buf bytes.Buffer
+    if err := bytecode.Encode(&buf); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.WriteFile(cached[0], buf.Bytes(), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    if got := run("-cache", cacheDir); got != "from cache\n" {
+        t.Errorf("wrong output of the second run. got=%q", got)
+    }
+    if got := run("-cache", ""); got != "from source\n" {
+        t.Errorf("wrong output without the cache. got=%q", got)
+    }
+}
+
+func TestRunScriptUsage(t *testing.T) {
+    var stdout, stderr bytes.Buffer
+    if status := runScript(nil, &stdout, &stderr); status != 2 || stderr.String() != "usage: monkey run [flags] file.mk\n" {
+        t.Errorf("wrong result without a script. status=%d, stderr=%q", status, stderr.String())
+    }
+    stderr.Reset()
+    if status := runScript([]string{"-engine", "jit", "x.mk"}, &stdout, &stderr); status != 2 || stderr.String() != "unknown engine \"jit\", want eval or vm\n" {
+        t.Errorf("wrong result for an unknown engine. status=%d, stderr=%q", status, stderr.String())
+    }
+}
+
+// files that cannot be formatted are left as they are
+func TestFormatFileKeepsBrokenFiles(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "broken.mk")
+    src := []byte("let = 1\n")
+    if err := os.WriteFile(path, src, 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    if status := formatFile(path, src, true, false); status != 1 {
+        t.Errorf("expected formatting to fail. status=%d", status)
+    }
+    if got, err := os.ReadFile(path); err != nil || string(got) != string(src) {
+        t.Errorf("file changed to %q (%v)", got, err)
+    }
+}
@@ -0,0 +1,243 @@
+// Package code defines the bytecode instruction set run by the vm. An
+// instruction is a one byte Opcode followed by its operands, each a big endian
+// unsigned integer of the width given by the opcode's Definition.
+package code
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+type Instructions []byte
+
+type Opcode byte
+
+const (
+    OpConstant Opcode = iota    // push constant operand
+    OpPop
+    OpTrue
+    OpFalse
+    OpNull
+
+    // operators, they pop their operands and push the result
+    OpAdd
+    OpSub
+    OpMul
+    OpDiv
+    OpEqual
+    OpNotEqual
+    OpLessThan
+    OpGreaterThan
+    OpMinus
+    OpBang
+
+    OpJump                      // jump to operand
+    OpJumpNotTruthy             // pop, jump to operand if the value is false or null
+
+    // globals and builtins are looked up by name, the operand being the
+    // constant holding the name. Locals live in the slots of a frame
+    OpGetGlobal
+    OpSetGlobal
+    OpGetLocal                  // depth, slot and the constant naming the local for errors
+    OpSetLocal                  // slot in the current frame
+
+    OpArray                     // number of elements
+    OpHash                      // number of pairs, keys before their values
+    OpIndex
+
+    OpClosure                   // the constant holding the CompiledFunction
+    OpCall                      // number of arguments, pushed after the function
+    OpTailCall                  // like OpCall, replacing the current frame
+    OpReturnValue
+    OpThrow
+
+    // try expressions, see compiler.compileTry
+    OpSetupTry                  // where the catch clauses and the finally block start, NoTarget if missing
+    OpPopHandler
+    OpCatch                     // the constant naming the kind to catch or NoConstant, where the next clause starts
+    OpRethrow
+    OpEnterFinally
+    OpEndFinally
+    OpEnterScope                // catch blocks outside of functions get their own environment
+    OpLeaveScope
+)
+
+const (
+    NoConstant = 1<<16 - 1      // OpCatch catching every kind
+    NoTarget = 1<<32 - 1        // OpSetupTry without catch clauses or finally block
+)
+
+// SourceMap maps instructions back to the source lines they were compiled
+// from. Its positions are sorted by offset, each one covering the
+// instructions up to the next.
+type SourceMap []Position
+
+type Position struct {
+    Offset int                  // of the first instruction compiled from Line
+    Line int
+}
+
+// Line returns the source line of the instruction at offset, 0 if unknown
+func (m SourceMap) Line(offset int) int {
+    i := sort.Search(len(m), func(i int) bool { return m[i].Offset > offset })
+    if i == 0 {
+        return 0
+    }
+    return m[i-1].Line
+}
+
+type Definition struct {
+    Name string
+    OperandWidths []int         // in bytes
+}
+
+var definitions = map[Opcode]*Definition{
+    OpConstant: {"OpConstant", []int{2}},
+    OpPop: {"OpPop", []int{}},
+    OpTrue: {"OpTrue", []int{}},
+    OpFalse: {"OpFalse", []int{}},
+    OpNull: {"OpNull", []int{}},
+
+    OpAdd: {"OpAdd", []int{}},
+    OpSub: {"OpSub", []int{}},
+    OpMul: {"OpMul", []int{}},
+    OpDiv: {"OpDiv", []int{}},
+    OpEqual: {"OpEqual", []int{}},
+    OpNotEqual: {"OpNotEqual", []int{}},
+    OpLessThan: {"OpLessThan", []int{}},
+    OpGreaterThan: {"OpGreaterThan", []int{}},
+    OpMinus: {"OpMinus", []int{}},
+    OpBang: {"OpBang", []int{}},
+
+    OpJump: {"OpJump", []int{4}},
+    OpJumpNotTruthy: {"OpJumpNotTruthy", []int{4}},
+
+    OpGetGlobal: {"OpGetGlobal", []int{2}},
+    OpSetGlobal: {"OpSetGlobal", []int{2}},
+    OpGetLocal: {"OpGetLocal", []int{1, 2, 2}},
+    OpSetLocal: {"OpSetLocal", []int{2}},
+
+    OpArray: {"OpArray", []int{2}},
+    OpHash: {"OpHash", []int{2}},
+    OpIndex: {"OpIndex", []int{}},
+
+    OpClosure: {"OpClosure", []int{2}},
+    OpCall: {"OpCall", []int{1}},
+    OpTailCall: {"OpTailCall", []int{1}},
+    OpReturnValue: {"OpReturnValue", []int{}},
+    OpThrow: {"OpThrow", []int{}},
+
+    OpSetupTry: {"OpSetupTry", []int{4, 4}},
+    OpPopHandler: {"OpPopHandler", []int{}},
+    OpCatch: {"OpCatch", []int{2, 4}},
+    OpRethrow: {"OpRethrow", []int{}},
+    OpEnterFinally: {"OpEnterFinally", []int{}},
+    OpEndFinally: {"OpEndFinally", []int{}},
+    OpEnterScope: {"OpEnterScope", []int{}},
+    OpLeaveScope: {"OpLeaveScope", []int{}},
+}
+
+func Lookup(op byte) (*Definition, error) {
+    def, ok := definitions[Opcode(op)]
+    if !ok {
+        return nil, fmt.Errorf("opcode %d undefined", op)
+    }
+
+    return def, nil
+}
+
+// Make encodes an instruction. Unknown opcodes give an empty instruction.
+func Make(op Opcode, operands ...int) []byte {
+    def, ok := definitions[op]
+    if !ok {
+        return []byte{}
+    }
+
+    length := 1
+    for _, width := range def.OperandWidths {
+        length += width
+    }
+
+    instruction := make([]byte, length)
+    instruction[0] = byte(op)
+
+    offset := 1
+    for i, operand := range operands {
+        width := def.OperandWidths[i]
+        switch width {
+        case 1:
+            instruction[offset] = byte(operand)
+        case 2:
+            binary.BigEndian.PutUint16(instruction[offset:], uint16(operand))
+        case 4:
+            binary.BigEndian.PutUint32(instruction[offset:], uint32(operand))
+        }
+        offset += width
+    }
+
+    return instruction
+}
+
+// ReadOperands decodes the operands of an instruction described by def and
+// reports how many bytes they took
+func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
+    operands := make([]int, len(def.OperandWidths))
+    offset := 0
+
+    for i, width := range def.OperandWidths {
+        switch width {
+        case 1:
+            operands[i] = int(ins[offset])
+        case 2:
+            operands[i] = int(ReadUint16(ins[offset:]))
+        case 4:
+            operands[i] = int(ReadUint32(ins[offset:]))
+        }
+        offset += width
+    }
+
+    return operands, offset
+}
+
+func ReadUint16(ins Instructions) uint16 {
+    return binary.BigEndian.Uint16(ins)
+}
+
+func ReadUint32(ins Instructions) uint32 {
+    return binary.BigEndian.Uint32(ins)
+}
+
+// String disassembles the instructions, one per line prefixed by its offset
+func (ins Instructions) String() string {
+    var out bytes.Buffer
+
+    i := 0
+    for i < len(ins) {
+        def, err := Lookup(ins[i])
+        if err != nil {
+            fmt.Fprintf(&out, "ERROR: %s\n", err)
+            return out.String()
+        }
+
+        operands, read := ReadOperands(def, ins[i+1:])
+        fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
+
+        i += 1 + read
+    }
+
+    return out.String()
+}
+
+func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
+    if len(operands) != len(def.OperandWidths) {
+        return fmt.Sprintf("ERROR: operand len %d does not match defined %d\n", len(operands), len(def.OperandWidths))
+    }
+
+    out := def.Name
+    for _, operand := range operands {
+        out += fmt.Sprintf(" %d", operand)
+    }
+    return out
+}
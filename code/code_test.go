@@ -0,0 +1,116 @@
+package code
+
+import (
+	"testing"
+)
+
+func TestMake(t *testing.T) {
+    tests := []struct {
+        op Opcode
+        operands []int
+        expected []byte
+    }{
+        {OpConstant, []int{65534}, []byte{byte(OpConstant), 255, 254}},
+        {OpAdd, []int{}, []byte{byte(OpAdd)}},
+        {OpCall, []int{255}, []byte{byte(OpCall), 255}},
+        {OpJump, []int{65536}, []byte{byte(OpJump), 0, 1, 0, 0}},
+        {OpGetLocal, []int{1, 258, 3}, []byte{byte(OpGetLocal), 1, 1, 2, 0, 3}},
+    }
+
+    for _, tt := range tests {
+        instruction := Make(tt.op, tt.operands...)
+
+        if len(instruction) != len(tt.expected) {
+            t.Errorf("instruction has wrong length. want=%d, got=%d", len(tt.expected), len(instruction))
+            continue
+        }
+
+        for i, b := range tt.expected {
+            if instruction[i] != b {
+                t.Errorf("wrong byte at pos %d. want=%d, got=%d", i, b, instruction[i])
+            }
+        }
+    }
+}
+
+func TestInstructionsString(t *testing.T) {
+    instructions := []Instructions{
+        Make(OpConstant, 1),
+        Make(OpGetLocal, 0, 1, 2),
+        Make(OpSetupTry, 20, NoTarget),
+        Make(OpAdd),
+    }
+
+    expected := `0000 OpConstant 1
+0003 OpGetLocal 0 1 2
+0009 OpSetupTry 20 4294967295
+0018 OpAdd
+`
+
+    concatted := Instructions{}
+    for _, ins := range instructions {
+        concatted = append(concatted, ins...)
+    }
+
+    if concatted.String() != expected {
+        t.Errorf("instructions wrongly formatted.\nwant=%q\ngot=%q", expected, concatted.String())
+    }
+}
+
+func TestReadOperands(t *testing.T) {
+    tests := []struct {
+        op Opcode
+        operands []int
+        bytesRead int
+    }{
+        {OpConstant, []int{65535}, 2},
+        {OpCatch, []int{7, 70000}, 6},
+        {OpGetLocal, []int{2, 300, 4}, 5},
+    }
+
+    for _, tt := range tests {
+        instruction := Make(tt.op, tt.operands...)
+
+        def, err := Lookup(byte(tt.op))
+        if err != nil {
+            t.Fatalf("definition not found: %q\n", err)
+        }
+
+        operandsRead, n := ReadOperands(def, instruction[1:])
+        if n != tt.bytesRead {
+            t.Fatalf("n wrong. want=%d, got=%d", tt.bytesRead, n)
+        }
+
+        for i, want := range tt.operands {
+            if operandsRead[i] != want {
+                t.Errorf("operand wrong. want=%d, got=%d", want, operandsRead[i])
+            }
+        }
+    }
+}
+
+func TestSourceMapLine(t *testing.T) {
+    m := SourceMap{{Offset: 0, Line: 2}, {Offset: 6, Line: 3}, {Offset: 20, Line: 7}}
+
+    tests := []struct {
+        offset int
+        expected int
+    }{
+        {0, 2},
+        {5, 2},
+        {6, 3},
+        {19, 3},
+        {20, 7},
+        {100, 7},
+    }
+
+    for _, tt := range tests {
+        if line := m.Line(tt.offset); line != tt.expected {
+            t.Errorf("wrong line for offset %d. want=%d, got=%d", tt.offset, tt.expected, line)
+        }
+    }
+
+    if line := (SourceMap{}).Line(3); line != 0 {
+        t.Errorf("empty source map gave line %d", line)
+    }
+}
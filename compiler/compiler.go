@@ -0,0 +1,528 @@
+// Package compiler turns a parsed program into bytecode for the vm, see
+// package code. Variables are placed where the resolver put them: locals of
+// functions in the slots of their frame, everything else looked up by name,
+// so compiled programs share globals and builtins with the evaluator.
+package compiler
+
+import (
+	"fmt"
+	"monkey/ast"
+	"monkey/code"
+	"monkey/object"
+	"monkey/resolver"
+)
+
+// Bytecode is a compiled program. Its instructions end by returning the value
+// of the last statement, like evaluating the program would.
+type Bytecode struct {
+    Instructions code.Instructions
+    SourceMap code.SourceMap
+    Constants []object.Object   // literals, function prototypes and names
+}
+
+// Compile resolves program, see resolver.Resolve, and compiles it
+func Compile(program *ast.Program) (*Bytecode, error) {
+    resolver.Resolve(program, nil)
+
+    c := &compiler{names: make(map[string]int)}
+    c.enterScope()
+
+    if err := c.compileBody(program.Statements); err != nil {
+        return nil, err
+    }
+
+    if len(c.constants) > code.NoConstant {
+        return nil, fmt.Errorf("too many constants: %d", len(c.constants))
+    }
+
+    // functions may be called long after the program defining them has
+    // finished, so they keep its constants
+    for _, constant := range c.constants {
+        if fn, ok := constant.(*object.CompiledFunction); ok {
+            fn.Constants = c.constants
+        }
+    }
+
+    instructions, sourceMap := c.leaveScope()
+    return &Bytecode{Instructions: instructions, SourceMap: sourceMap, Constants: c.constants}, nil
+}
+
+type compiler struct {
+    constants []object.Object
+    names map[string]int        // the constant holding each name, so it is only stored once
+    scopes []*scope             // the program, then the function literals being compiled
+}
+
+type scope struct {
+    instructions code.Instructions
+    sourceMap code.SourceMap
+    last emitted
+    previous emitted
+}
+
+type emitted struct {
+    op code.Opcode
+    pos int
+}
+
+var infixOperators = map[string]code.Opcode{
+    "+": code.OpAdd,
+    "-": code.OpSub,
+    "*": code.OpMul,
+    "/": code.OpDiv,
+    "==": code.OpEqual,
+    "!=": code.OpNotEqual,
+    "<": code.OpLessThan,
+    ">": code.OpGreaterThan,
+}
+
+var prefixOperators = map[string]code.Opcode{
+    "!": code.OpBang,
+    "-": code.OpMinus,
+}
+
+func (c *compiler) compile(node ast.Node) error {
+    if statement, ok := node.(ast.Statement); ok {
+        c.mark(statement)
+    }
+
+    switch node := node.(type) {
+    // STATEMENTS
+    case *ast.ExpressionStatement:
+        if err := c.compile(node.Expression); err != nil {
+            return err
+        }
+        c.emit(code.OpPop)
+    case *ast.BlockStatement:
+        return c.compileBlock(node)
+    case *ast.LetStatement:
+        if err := c.compile(node.Value); err != nil {
+            return err
+        }
+        c.setVariable(node.Name)
+    case *ast.ReturnStatement:
+        if err := c.compile(node.ReturnValue); err != nil {
+            return err
+        }
+        c.emit(code.OpReturnValue)
+    case *ast.ThrowStatement:
+        if err := c.compile(node.Value); err != nil {
+            return err
+        }
+        c.emit(code.OpThrow)
+
+    // EXPRESSIONS
+    case *ast.IntegerLiteral:
+        c.emit(code.OpConstant, c.addConstant(&object.Integer{Value: node.Value}))
+    case *ast.FloatLiteral:
+        c.emit(code.OpConstant, c.addConstant(&object.Float{Value: node.Value}))
+    case *ast.StringLiteral:
+        c.emit(code.OpConstant, c.addConstant(&object.String{Value: node.Value}))
+    case *ast.Boolean:
+        if node.Value {
+            c.emit(code.OpTrue)
+        } else {
+            c.emit(code.OpFalse)
+        }
+    case *ast.NullLiteral:
+        c.emit(code.OpNull)
+    case *ast.PrefixExpression:
+        op, ok := prefixOperators[node.Operator]
+        if !ok {
+            return fmt.Errorf("unknown operator %s", node.Operator)
+        }
+        if err := c.compile(node.Right); err != nil {
+            return err
+        }
+        c.emit(op)
+    case *ast.InfixExpression:
+        op, ok := infixOperators[node.Operator]
+        if !ok {
+            return fmt.Errorf("unknown operator %s", node.Operator)
+        }
+        // operands are evaluated left to right, so `<` is not turned around
+        if err := c.compile(node.Left); err != nil {
+            return err
+        }
+        if err := c.compile(node.Right); err != nil {
+            return err
+        }
+        c.emit(op)
+    case *ast.IfExpression:
+        return c.compileIf(node)
+    case *ast.TryExpression:
+        return c.compileTry(node)
+    case *ast.Identifier:
+        return c.getVariable(node)
+    case *ast.FunctionLiteral:
+        return c.compileFunction(node)
+    case *ast.CallExpression:
+        if len(node.Arguments) > 255 {
+            return fmt.Errorf("too many arguments in call: %d", len(node.Arguments))
+        }
+        if err := c.compile(node.Function); err != nil {
+            return err
+        }
+        for _, arg := range node.Arguments {
+            if err := c.compile(arg); err != nil {
+                return err
+            }
+        }
+        if node.Tail {
+            c.emit(code.OpTailCall, len(node.Arguments))
+        } else {
+            c.emit(code.OpCall, len(node.Arguments))
+        }
+    case *ast.ArrayLiteral:
+        if len(node.Elements) > 1<<16 - 1 {
+            return fmt.Errorf("too many elements in array literal: %d", len(node.Elements))
+        }
+        for _, elem := range node.Elements {
+            if err := c.compile(elem); err != nil {
+                return err
+            }
+        }
+        c.emit(code.OpArray, len(node.Elements))
+    case *ast.HashLiteral:
+        if len(node.Pairs) > 1<<16 - 1 {
+            return fmt.Errorf("too many pairs in hash literal: %d", len(node.Pairs))
+        }
+        for _, pair := range node.Pairs {
+            if err := c.compile(pair.Key); err != nil {
+                return err
+            }
+            if err := c.compile(pair.Value); err != nil {
+                return err
+            }
+        }
+        c.emit(code.OpHash, len(node.Pairs))
+    case *ast.IndexExpression:
+        if err := c.compile(node.Left); err != nil {
+            return err
+        }
+        if err := c.compile(node.Index); err != nil {
+            return err
+        }
+        c.emit(code.OpIndex)
+    default:
+        return fmt.Errorf("cannot compile %T", node)
+    }
+
+    return nil
+}
+
+// compileBody compiles the statements of the program or of a function, which
+// return the value of their last statement if it is an expression and null
+// otherwise
+func (c *compiler) compileBody(statements []ast.Statement) error {
+    for _, statement := range statements {
+        if err := c.compile(statement); err != nil {
+            return err
+        }
+    }
+
+    if len(statements) > 0 && c.lastInstructionIs(code.OpPop) {
+        c.replaceLastInstruction(code.Make(code.OpReturnValue))
+        c.currentScope().last.op = code.OpReturnValue
+        return nil
+    }
+
+    c.emit(code.OpNull)
+    c.emit(code.OpReturnValue)
+    return nil
+}
+
+// compileBlock leaves the value of the block on the stack: the value of its
+// last statement if it is an expression, null otherwise
+func (c *compiler) compileBlock(block *ast.BlockStatement) error {
+    for _, statement := range block.Statements {
+        if err := c.compile(statement); err != nil {
+            return err
+        }
+    }
+
+    if len(block.Statements) > 0 && c.lastInstructionIs(code.OpPop) {
+        c.removeLastPop()
+    } else {
+        c.emit(code.OpNull)
+    }
+
+    return nil
+}
+
+func (c *compiler) compileIf(node *ast.IfExpression) error {
+    if err := c.compile(node.Condition); err != nil {
+        return err
+    }
+
+    jumpNotTruthy := c.emit(code.OpJumpNotTruthy, code.NoTarget)
+    if err := c.compileBlock(node.Consequence); err != nil {
+        return err
+    }
+
+    jump := c.emit(code.OpJump, code.NoTarget)
+    c.changeOperand(jumpNotTruthy, len(c.currentInstructions()))
+
+    if node.Alternative == nil {
+        c.emit(code.OpNull)
+    } else if err := c.compileBlock(node.Alternative); err != nil {
+        return err
+    }
+
+    c.changeOperand(jump, len(c.currentInstructions()))
+    return nil
+}
+
+// compileTry lays out a try expression as
+//
+//      OpSetupTry catches finally
+//      <try block>
+//      OpPopHandler
+//      OpJump end
+//  catches:
+//      OpCatch kind next       once per catch clause, binding the error
+//      <catch block>
+//      OpPopHandler            only with a finally block
+//      OpJump end
+//  next:
+//      OpRethrow               no clause accepted the error
+//  end:
+//      OpEnterFinally
+//  finally:
+//      <finally block>
+//      OpPop
+//      OpEndFinally
+//
+// The vm jumps to catches when an error is raised in the try block, and to
+// finally when one is raised in a catch block or a return leaves the try
+// expression. Either way the value, error or return waiting for the finally
+// block to complete is pushed first and taken up again by OpEndFinally.
+func (c *compiler) compileTry(node *ast.TryExpression) error {
+    setup := c.emit(code.OpSetupTry, code.NoTarget, code.NoTarget)
+    catches, finally := code.NoTarget, code.NoTarget
+
+    if err := c.compileBlock(node.Block); err != nil {
+        return err
+    }
+    c.emit(code.OpPopHandler)
+
+    var ends []int
+    if len(node.Catches) > 0 {
+        ends = append(ends, c.emit(code.OpJump, code.NoTarget))
+        catches = len(c.currentInstructions())
+
+        for _, clause := range node.Catches {
+            if err := c.compileCatch(clause, node.Finally != nil, &ends); err != nil {
+                return err
+            }
+        }
+        c.emit(code.OpRethrow)
+    }
+
+    for _, pos := range ends {
+        c.changeOperand(pos, len(c.currentInstructions()))
+    }
+
+    if node.Finally != nil {
+        c.emit(code.OpEnterFinally)
+        finally = len(c.currentInstructions())
+
+        if err := c.compileBlock(node.Finally); err != nil {
+            return err
+        }
+        c.emit(code.OpPop)
+        c.emit(code.OpEndFinally)
+    }
+
+    c.changeOperand(setup, catches, finally)
+    return nil
+}
+
+func (c *compiler) compileCatch(clause *ast.CatchClause, hasFinally bool, ends *[]int) error {
+    kind := code.NoConstant
+    if clause.Kind != nil {
+        kind = c.name(clause.Kind.Value)
+    }
+    catch := c.emit(code.OpCatch, kind, code.NoTarget)
+
+    // the resolver gave catch blocks inside functions their own slots
+    topLevel := len(c.scopes) == 1
+    if topLevel {
+        c.emit(code.OpEnterScope)
+    }
+
+    if clause.Parameter != nil {
+        c.setVariable(clause.Parameter)
+    } else {
+        c.emit(code.OpPop)
+    }
+
+    if err := c.compileBlock(clause.Body); err != nil {
+        return err
+    }
+
+    if topLevel {
+        c.emit(code.OpLeaveScope)
+    }
+    if hasFinally {
+        c.emit(code.OpPopHandler)
+    }
+
+    *ends = append(*ends, c.emit(code.OpJump, code.NoTarget))
+    c.changeOperand(catch, kind, len(c.currentInstructions()))
+    return nil
+}
+
+func (c *compiler) compileFunction(node *ast.FunctionLiteral) error {
+    if !node.Resolved {
+        return fmt.Errorf("function literal %s has not been resolved", node.String())
+    }
+
+    c.enterScope()
+    if err := c.compileBody(node.Body.Statements); err != nil {
+        return err
+    }
+    instructions, sourceMap := c.leaveScope()
+
+    fn := &object.CompiledFunction{
+        Instructions: instructions,
+        SourceMap: sourceMap,
+        NumLocals: node.Slots,
+        NumParameters: len(node.Parameters),
+        Name: node.Name,
+    }
+    c.emit(code.OpClosure, c.addConstant(fn))
+    return nil
+}
+
+func (c *compiler) getVariable(ident *ast.Identifier) error {
+    if !ident.Local {
+        c.emit(code.OpGetGlobal, c.name(ident.Value))
+        return nil
+    }
+
+    if ident.Depth > 255 {
+        return fmt.Errorf("functions nested too deeply to reach %s", ident.Value)
+    }
+    c.emit(code.OpGetLocal, ident.Depth, ident.Slot, c.name(ident.Value))
+    return nil
+}
+
+// setVariable pops the value on top of the stack into the variable. Variables
+// are always declared in the function they are assigned in.
+func (c *compiler) setVariable(ident *ast.Identifier) {
+    if ident.Local {
+        c.emit(code.OpSetLocal, ident.Slot)
+    } else {
+        c.emit(code.OpSetGlobal, c.name(ident.Value))
+    }
+}
+
+func (c *compiler) addConstant(obj object.Object) int {
+    c.constants = append(c.constants, obj)
+    return len(c.constants) - 1
+}
+
+func (c *compiler) name(name string) int {
+    if index, ok := c.names[name]; ok {
+        return index
+    }
+
+    index := c.addConstant(&object.String{Value: name})
+    c.names[name] = index
+    return index
+}
+
+func (c *compiler) currentScope() *scope {
+    return c.scopes[len(c.scopes)-1]
+}
+
+func (c *compiler) currentInstructions() code.Instructions {
+    return c.currentScope().instructions
+}
+
+func (c *compiler) enterScope() {
+    c.scopes = append(c.scopes, &scope{})
+}
+
+func (c *compiler) leaveScope() (code.Instructions, code.SourceMap) {
+    s := c.currentScope()
+    c.scopes = c.scopes[:len(c.scopes)-1]
+    return s.instructions, s.sourceMap
+}
+
+// mark records that the instructions emitted next are compiled from statement
+func (c *compiler) mark(statement ast.Statement) {
+    line := statementLine(statement)
+    if line == 0 {
+        return
+    }
+
+    s := c.currentScope()
+    pos := code.Position{Offset: len(s.instructions), Line: line}
+
+    if n := len(s.sourceMap); n > 0 {
+        switch {
+        case s.sourceMap[n-1].Line == line:
+            return
+        case s.sourceMap[n-1].Offset == pos.Offset:
+            s.sourceMap[n-1] = pos
+            return
+        }
+    }
+    s.sourceMap = append(s.sourceMap, pos)
+}
+
+// statementLine returns the line statement starts on, 0 for blocks, whose
+// statements are marked themselves
+func statementLine(statement ast.Statement) int {
+    switch statement := statement.(type) {
+    case *ast.ExpressionStatement:
+        return statement.Token.Line
+    case *ast.LetStatement:
+        return statement.Token.Line
+    case *ast.ReturnStatement:
+        return statement.Token.Line
+    case *ast.ThrowStatement:
+        return statement.Token.Line
+    }
+    return 0
+}
+
+// emit appends an instruction and returns its position
+func (c *compiler) emit(op code.Opcode, operands ...int) int {
+    s := c.currentScope()
+    pos := len(s.instructions)
+    s.instructions = append(s.instructions, code.Make(op, operands...)...)
+
+    s.previous = s.last
+    s.last = emitted{op: op, pos: pos}
+    return pos
+}
+
+func (c *compiler) lastInstructionIs(op code.Opcode) bool {
+    s := c.currentScope()
+    return len(s.instructions) > 0 && s.last.op == op
+}
+
+func (c *compiler) removeLastPop() {
+    s := c.currentScope()
+    s.instructions = s.instructions[:s.last.pos]
+    s.last = s.previous
+}
+
+func (c *compiler) replaceLastInstruction(instruction []byte) {
+    c.replaceAt(c.currentScope().last.pos, instruction)
+}
+
+// changeOperand rewrites the operands of the instruction at pos, used to
+// fill in jump targets once they are known
+func (c *compiler) changeOperand(pos int, operands ...int) {
+    s := c.currentScope()
+    op := code.Opcode(s.instructions[pos])
+    c.replaceAt(pos, code.Make(op, operands...))
+}
+
+func (c *compiler) replaceAt(pos int, instruction []byte) {
+    copy(c.currentScope().instructions[pos:], instruction)
+}
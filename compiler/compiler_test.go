@@ -0,0 +1,238 @@
+package compiler
+
+import (
+	"monkey/code"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"testing"
+)
+
+type compilerTestCase struct {
+    input string
+    expectedConstants []interface{}
+    expectedInstructions []code.Instructions
+}
+
+func TestCompile(t *testing.T) {
+    tests := []compilerTestCase{
+        {
+            input: "1 + 2",
+            expectedConstants: []interface{}{1, 2},
+            expectedInstructions: []code.Instructions{
+                code.Make(code.OpConstant, 0),
+                code.Make(code.OpConstant, 1),
+                code.Make(code.OpAdd),
+                code.Make(code.OpReturnValue),
+            },
+        },
+        {
+            // operands stay in source order
+            input: "1 < 2; 3",
+            expectedConstants: []interface{}{1, 2, 3},
+            expectedInstructions: []code.Instructions{
+                code.Make(code.OpConstant, 0),
+                code.Make(code.OpConstant, 1),
+                code.Make(code.OpLessThan),
+                code.Make(code.OpPop),
+                code.Make(code.OpConstant, 2),
+                code.Make(code.OpReturnValue),
+            },
+        },
+        {
+            input: "let x = 1; x",
+            expectedConstants: []interface{}{1, "x"},
+            expectedInstructions: []code.Instructions{
+                code.Make(code.OpConstant, 0),
+                code.Make(code.OpSetGlobal, 1),
+                code.Make(code.OpGetGlobal, 1),
+                code.Make(code.OpReturnValue),
+            },
+        },
+        {
+            input: "if (true) { 10 }; 3333",
+            expectedConstants: []interface{}{10, 3333},
+            expectedInstructions: []code.Instructions{
+                code.Make(code.OpTrue),
+                code.Make(code.OpJumpNotTruthy, 14),
+                code.Make(code.OpConstant, 0),
+                code.Make(code.OpJump, 15),
+                code.Make(code.OpNull),
+                code.Make(code.OpPop),
+                code.Make(code.OpConstant, 1),
+                code.Make(code.OpReturnValue),
+            },
+        },
+        {
+            input: "let x = 1;",
+            expectedConstants: []interface{}{1, "x"},
+            expectedInstructions: []code.Instructions{
+                code.Make(code.OpConstant, 0),
+                code.Make(code.OpSetGlobal, 1),
+                code.Make(code.OpNull),
+                code.Make(code.OpReturnValue),
+            },
+        },
+        {
+            input: `try { 1 } catch (e) { e }`,
+            expectedConstants: []interface{}{1, "e"},
+            expectedInstructions: []code.Instructions{
+                code.Make(code.OpSetupTry, 18, code.NoTarget),
+                code.Make(code.OpConstant, 0),
+                code.Make(code.OpPopHandler),
+                code.Make(code.OpJump, 39),
+                code.Make(code.OpCatch, code.NoConstant, 38),
+                code.Make(code.OpEnterScope),
+                code.Make(code.OpSetGlobal, 1),
+                code.Make(code.OpGetGlobal, 1),
+                code.Make(code.OpLeaveScope),
+                code.Make(code.OpJump, 39),
+                code.Make(code.OpRethrow),
+                code.Make(code.OpReturnValue),
+            },
+        },
+        {
+            input: `try { 1 } finally { 2 }`,
+            expectedConstants: []interface{}{1, 2},
+            expectedInstructions: []code.Instructions{
+                code.Make(code.OpSetupTry, code.NoTarget, 14),
+                code.Make(code.OpConstant, 0),
+                code.Make(code.OpPopHandler),
+                code.Make(code.OpEnterFinally),
+                code.Make(code.OpConstant, 1),
+                code.Make(code.OpPop),
+                code.Make(code.OpEndFinally),
+                code.Make(code.OpReturnValue),
+            },
+        },
+    }
+
+    runCompilerTests(t, tests)
+}
+
+func TestFunctions(t *testing.T) {
+    input := `fn(a) { let b = a; fn() { a + b } }`
+
+    bytecode := testCompile(t, input)
+
+    // names come first, as the inner function is compiled before the outer
+    outer, ok := bytecode.Constants[3].(*object.CompiledFunction)
+    if !ok {
+        t.Fatalf("constant 3 is not a CompiledFunction. got=%T", bytecode.Constants[3])
+    }
+    if outer.NumLocals != 2 || outer.NumParameters != 1 {
+        t.Errorf("wrong frame size. locals=%d, parameters=%d", outer.NumLocals, outer.NumParameters)
+    }
+
+    testInstructions(t, input, []code.Instructions{
+        code.Make(code.OpGetLocal, 0, 0, 0),
+        code.Make(code.OpSetLocal, 1),
+        code.Make(code.OpClosure, 2),
+        code.Make(code.OpReturnValue),
+    }, outer.Instructions)
+
+    inner := bytecode.Constants[2].(*object.CompiledFunction)
+    testInstructions(t, input, []code.Instructions{
+        code.Make(code.OpGetLocal, 1, 0, 0),
+        code.Make(code.OpGetLocal, 1, 1, 1),
+        code.Make(code.OpAdd),
+        code.Make(code.OpReturnValue),
+    }, inner.Instructions)
+
+    // functions share the constants of their program
+    if len(inner.Constants) != len(bytecode.Constants) || &inner.Constants[0] != &bytecode.Constants[0] {
+        t.Errorf("function does not share the program's constants")
+    }
+}
+
+func TestTailCalls(t *testing.T) {
+    bytecode := testCompile(t, `fn(n) { if (n) { f(n) } else { g(f(n)) } }`)
+    fn := bytecode.Constants[len(bytecode.Constants)-1].(*object.CompiledFunction)
+
+    calls := map[code.Opcode]int{}
+    ins := fn.Instructions
+    for i := 0; i < len(ins); {
+        def, _ := code.Lookup(ins[i])
+        _, read := code.ReadOperands(def, ins[i+1:])
+        calls[code.Opcode(ins[i])]++
+        i += 1 + read
+    }
+
+    if calls[code.OpTailCall] != 2 || calls[code.OpCall] != 1 {
+        t.Errorf("wrong calls. tail=%d, other=%d", calls[code.OpTailCall], calls[code.OpCall])
+    }
+}
+
+func TestSourceMap(t *testing.T) {
+    input := `let a = 1;
+let f = fn(x) {
+    let y = x;
+
+    y + a
+};
+f(2)`
+
+    bytecode := testCompile(t, input)
+    testSourceMap(t, code.SourceMap{{Offset: 0, Line: 1}, {Offset: 6, Line: 2}, {Offset: 12, Line: 7}}, bytecode.SourceMap)
+
+    for _, constant := range bytecode.Constants {
+        if fn, ok := constant.(*object.CompiledFunction); ok {
+            testSourceMap(t, code.SourceMap{{Offset: 0, Line: 3}, {Offset: 9, Line: 5}}, fn.SourceMap)
+        }
+    }
+}
+
+func runCompilerTests(t *testing.T, tests []compilerTestCase) {
+    t.Helper()
+
+    for _, tt := range tests {
+        bytecode := testCompile(t, tt.input)
+        testInstructions(t, tt.input, tt.expectedInstructions, bytecode.Instructions)
+        testConstants(t, tt.input, tt.expectedConstants, bytecode.Constants)
+    }
+}
+
+func testCompile(t *testing.T, input string) *Bytecode {
+    t.Helper()
+
+    p := parser.New(lexer.New(input))
+    program := p.ParseProgram()
+    if len(p.Errors()) != 0 {
+        t.Fatalf("parser errors for %q: %v", input, p.Errors())
+    }
+
+    bytecode, err := Compile(program)
+    if err != nil {
+        t.Fatalf("compiler error for %q: %s", input, err)
+    }
+    return bytecode
+}
+
+func testInstructions(t *testing.T, input string, expected []code.Instructions, actual code.Instructions) {
+    t.Helper()
+
+    concatted := code.Instructions{}
+    for _, ins := range expected {
+        concatted = append(concatted, ins...)
+    }
+
+    if actual.String() != concatted.String() {
+        t.Errorf("%s: wrong instructions.\nwant=\n%s\ngot=\n%s", input, concatted, actual)
+    }
+}
+
+func testConstants(t *testing.T, input string, expected []interface{}, actual []object.Object) {
+    t.Helper()
+
+    if len(expected) != len(actual) {
+        t.Errorf("%s: wrong number of constants. want=%d, got=%d", input, len(expected), len(actual))
+        return
+    }
+
+    for i, constant := range expected {
+        want, _ := object.FromGo(constant)
+        if !actual[i].Equals(want) || actual[i].Type() != want.Type() {
+            t.Errorf("%s: constant %d is not %s. got=%s", input, i, want.Inspect(), actual[i].Inspect())
+        }
+    }
+}
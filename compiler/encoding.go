@@ -0,0 +1,321 @@
+package compiler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"monkey/code"
+	"monkey/object"
+)
+
+// FormatVersion is the version of the binary format written by Encode. It
+// changes with the format and with the instruction set, Decode refuses
+// bytecode of any other version.
+const FormatVersion = 1
+
+var (
+    ErrFormat = errors.New("not a compiled Monkey program")
+    ErrVersion = errors.New("unsupported bytecode version")
+)
+
+// the format is the magic, the version, the instructions and source map of
+// the program and then its constants, each tagged with its type. Functions
+// refer to each other by constant index, so the pool needs no nesting.
+var magic = []byte("\x00mkb")
+
+const (
+    tagInteger byte = iota + 1
+    tagFloat
+    tagString
+    tagFunction
+)
+
+// Encode writes b to w in a binary format Decode reads back
+func (b *Bytecode) Encode(w io.Writer) error {
+    e := &encoder{w: bufio.NewWriter(w)}
+
+    e.write(magic)
+    e.uint(FormatVersion)
+    e.instructions(b.Instructions, b.SourceMap)
+
+    e.uint(uint64(len(b.Constants)))
+    for _, constant := range b.Constants {
+        switch constant := constant.(type) {
+        case *object.Integer:
+            e.write([]byte{tagInteger})
+            e.int(constant.Value)
+        case *object.Float:
+            e.write([]byte{tagFloat})
+            e.uint(math.Float64bits(constant.Value))
+        case *object.String:
+            e.write([]byte{tagString})
+            e.string(constant.Value)
+        case *object.CompiledFunction:
+            e.write([]byte{tagFunction})
+            e.string(constant.Name)
+            e.uint(uint64(constant.NumLocals))
+            e.uint(uint64(constant.NumParameters))
+            e.instructions(constant.Instructions, constant.SourceMap)
+        default:
+            return fmt.Errorf("cannot encode constant of type %s", constant.Type())
+        }
+    }
+
+    if e.err != nil {
+        return e.err
+    }
+    return e.w.Flush()
+}
+
+type encoder struct {
+    w *bufio.Writer
+    err error       // the first write error, later writes are skipped
+    buf [binary.MaxVarintLen64]byte
+}
+
+func (e *encoder) write(p []byte) {
+    if e.err == nil {
+        _, e.err = e.w.Write(p)
+    }
+}
+
+func (e *encoder) uint(x uint64) {
+    e.write(e.buf[:binary.PutUvarint(e.buf[:], x)])
+}
+
+func (e *encoder) int(x int64) {
+    e.write(e.buf[:binary.PutVarint(e.buf[:], x)])
+}
+
+func (e *encoder) string(s string) {
+    e.uint(uint64(len(s)))
+    e.write([]byte(s))
+}
+
+func (e *encoder) instructions(ins code.Instructions, sourceMap code.SourceMap) {
+    e.uint(uint64(len(ins)))
+    e.write(ins)
+
+    e.uint(uint64(len(sourceMap)))
+    for _, pos := range sourceMap {
+        e.uint(uint64(pos.Offset))
+        e.uint(uint64(pos.Line))
+    }
+}
+
+// Decode reads bytecode written by Encode. Damaged input is reported with an
+// error wrapping ErrFormat: the instructions have to be complete and refer to
+// constants and jump targets that exist. Beyond that the bytecode is trusted
+// like bytecode from Compile, so only decode what Encode wrote.
+func Decode(r io.Reader) (*Bytecode, error) {
+    d := &decoder{r: bufio.NewReader(r)}
+
+    if !bytes.Equal(d.bytes(uint64(len(magic))), magic) {
+        return nil, ErrFormat
+    }
+    if version := d.uint(); version != FormatVersion {
+        if d.err != nil {
+            return nil, d.err
+        }
+        return nil, fmt.Errorf("%w %d, want %d", ErrVersion, version, FormatVersion)
+    }
+
+    b := &Bytecode{}
+    b.Instructions, b.SourceMap = d.instructions()
+
+    n := d.uint()
+    if n > code.NoConstant {
+        return nil, fmt.Errorf("%w: too many constants: %d", ErrFormat, n)
+    }
+
+    for i := uint64(0); i < n && d.err == nil; i++ {
+        var tag [1]byte
+        d.read(tag[:])
+
+        switch tag[0] {
+        case tagInteger:
+            b.Constants = append(b.Constants, &object.Integer{Value: d.int()})
+        case tagFloat:
+            b.Constants = append(b.Constants, &object.Float{Value: math.Float64frombits(d.uint())})
+        case tagString:
+            b.Constants = append(b.Constants, &object.String{Value: d.string()})
+        case tagFunction:
+            fn := &object.CompiledFunction{Name: d.string()}
+            fn.NumLocals = d.count()
+            fn.NumParameters = d.count()
+            fn.Instructions, fn.SourceMap = d.instructions()
+            b.Constants = append(b.Constants, fn)
+        default:
+            d.fail("unknown constant tag %d", tag[0])
+        }
+    }
+
+    if d.err != nil {
+        return nil, d.err
+    }
+
+    // like Compile, functions keep the constants of their program
+    if err := check(b.Instructions, b.Constants, 0); err != nil {
+        return nil, err
+    }
+    for _, constant := range b.Constants {
+        if fn, ok := constant.(*object.CompiledFunction); ok {
+            if err := check(fn.Instructions, b.Constants, fn.NumLocals); err != nil {
+                return nil, fmt.Errorf("%w in function %q", err, fn.Name)
+            }
+            fn.Constants = b.Constants
+        }
+    }
+
+    return b, nil
+}
+
+type decoder struct {
+    r *bufio.Reader
+    err error       // the first error, later reads return zero values
+}
+
+func (d *decoder) fail(format string, a ...any) {
+    if d.err == nil {
+        d.err = fmt.Errorf("%w: %s", ErrFormat, fmt.Sprintf(format, a...))
+    }
+}
+
+func (d *decoder) read(p []byte) {
+    if d.err != nil {
+        return
+    }
+    if _, err := io.ReadFull(d.r, p); err != nil {
+        d.fail("%v", err)
+    }
+}
+
+// bytes reads n bytes, growing the buffer as they arrive rather than trusting
+// n up front
+func (d *decoder) bytes(n uint64) []byte {
+    if d.err != nil || n > math.MaxInt64 {
+        d.fail("length %d out of range", n)
+        return nil
+    }
+
+    var buf bytes.Buffer
+    if _, err := io.CopyN(&buf, d.r, int64(n)); err != nil {
+        d.fail("%v", err)
+        return nil
+    }
+    return buf.Bytes()
+}
+
+func (d *decoder) uint() uint64 {
+    if d.err != nil {
+        return 0
+    }
+    x, err := binary.ReadUvarint(d.r)
+    if err != nil {
+        d.fail("%v", err)
+    }
+    return x
+}
+
+func (d *decoder) int() int64 {
+    if d.err != nil {
+        return 0
+    }
+    x, err := binary.ReadVarint(d.r)
+    if err != nil {
+        d.fail("%v", err)
+    }
+    return x
+}
+
+// count reads a length or index, which have to fit an int
+func (d *decoder) count() int {
+    x := d.uint()
+    if x > math.MaxInt32 {
+        d.fail("count %d out of range", x)
+        return 0
+    }
+    return int(x)
+}
+
+func (d *decoder) string() string {
+    return string(d.bytes(d.uint()))
+}
+
+func (d *decoder) instructions() (code.Instructions, code.SourceMap) {
+    ins := code.Instructions(d.bytes(d.uint()))
+
+    n := d.count()
+    var sourceMap code.SourceMap
+    for i := 0; i < n && d.err == nil; i++ {
+        sourceMap = append(sourceMap, code.Position{Offset: d.count(), Line: d.count()})
+    }
+
+    return ins, sourceMap
+}
+
+// check verifies that every instruction in ins is complete and that its
+// operands point at constants of the right type, jump targets within ins and,
+// for OpSetLocal, one of the numLocals slots of the function
+func check(ins code.Instructions, constants []object.Object, numLocals int) error {
+    isString := func(index int) bool {
+        _, ok := constants[index].(*object.String)
+        return ok
+    }
+
+    for ip := 0; ip < len(ins); {
+        def, err := code.Lookup(ins[ip])
+        if err != nil {
+            return fmt.Errorf("%w: %v at %d", ErrFormat, err, ip)
+        }
+
+        width := 0
+        for _, w := range def.OperandWidths {
+            width += w
+        }
+        if ip+1+width > len(ins) {
+            return fmt.Errorf("%w: %s at %d is cut off", ErrFormat, def.Name, ip)
+        }
+
+        operands, _ := code.ReadOperands(def, ins[ip+1:])
+
+        valid := true
+        switch code.Opcode(ins[ip]) {
+        case code.OpConstant:
+            valid = operands[0] < len(constants)
+        case code.OpGetGlobal, code.OpSetGlobal:
+            valid = operands[0] < len(constants) && isString(operands[0])
+        case code.OpGetLocal:
+            valid = operands[2] < len(constants) && isString(operands[2])
+        case code.OpSetLocal:
+            valid = operands[0] < numLocals
+        case code.OpClosure:
+            if operands[0] < len(constants) {
+                _, valid = constants[operands[0]].(*object.CompiledFunction)
+            } else {
+                valid = false
+            }
+        case code.OpCatch:
+            valid = operands[0] == code.NoConstant || operands[0] < len(constants) && isString(operands[0])
+            valid = valid && operands[1] <= len(ins)
+        case code.OpJump, code.OpJumpNotTruthy:
+            valid = operands[0] <= len(ins)
+        case code.OpSetupTry:
+            for _, target := range operands {
+                valid = valid && (target == code.NoTarget || target <= len(ins))
+            }
+        }
+
+        if !valid {
+            return fmt.Errorf("%w: invalid operands for %s at %d", ErrFormat, def.Name, ip)
+        }
+
+        ip += 1 + width
+    }
+
+    return nil
+}
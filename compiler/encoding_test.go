@@ -0,0 +1,114 @@
+package compiler
+
+import (
+	"bytes"
+	"errors"
+	"monkey/code"
+	"monkey/object"
+	"testing"
+)
+
+func TestEncodeDecode(t *testing.T) {
+    input := `let add = fn(a, b) { a + b };
+let twice = fn(f) { fn(x) { f(f(x)) } };
+puts(twice(fn(x) { add(x, -7) })(1), 2.5, "héllo");
+try { throw "x" } catch (TypeError e) { e } finally { 0 }`
+
+    bytecode := testCompile(t, input)
+
+    var buf bytes.Buffer
+    if err := bytecode.Encode(&buf); err != nil {
+        t.Fatalf("encode failed: %s", err)
+    }
+
+    decoded, err := Decode(&buf)
+    if err != nil {
+        t.Fatalf("decode failed: %s", err)
+    }
+
+    testInstructions(t, input, []code.Instructions{bytecode.Instructions}, decoded.Instructions)
+    testSourceMap(t, bytecode.SourceMap, decoded.SourceMap)
+
+    if len(decoded.Constants) != len(bytecode.Constants) {
+        t.Fatalf("wrong number of constants. want=%d, got=%d", len(bytecode.Constants), len(decoded.Constants))
+    }
+
+    for i, constant := range bytecode.Constants {
+        fn, ok := constant.(*object.CompiledFunction)
+        if !ok {
+            if !decoded.Constants[i].Equals(constant) || decoded.Constants[i].Type() != constant.Type() {
+                t.Errorf("constant %d is not %s. got=%s", i, constant.Inspect(), decoded.Constants[i].Inspect())
+            }
+            continue
+        }
+
+        got, ok := decoded.Constants[i].(*object.CompiledFunction)
+        if !ok {
+            t.Errorf("constant %d is not a function. got=%T", i, decoded.Constants[i])
+            continue
+        }
+
+        if got.Name != fn.Name || got.NumLocals != fn.NumLocals || got.NumParameters != fn.NumParameters {
+            t.Errorf("function %d differs. want=%q %d %d, got=%q %d %d", i, fn.Name, fn.NumLocals, fn.NumParameters, got.Name, got.NumLocals, got.NumParameters)
+        }
+        testInstructions(t, input, []code.Instructions{fn.Instructions}, got.Instructions)
+        testSourceMap(t, fn.SourceMap, got.SourceMap)
+
+        if len(got.Constants) != len(decoded.Constants) || &got.Constants[0] != &decoded.Constants[0] {
+            t.Errorf("function %d does not share the constants of the program", i)
+        }
+    }
+}
+
+func TestDecodeErrors(t *testing.T) {
+    var valid bytes.Buffer
+    if err := testCompile(t, `let f = fn(x) { x * 2 }; f(21)`).Encode(&valid); err != nil {
+        t.Fatalf("encode failed: %s", err)
+    }
+
+    var dangling bytes.Buffer
+    bytecode := &Bytecode{Instructions: code.Make(code.OpConstant, 3)}
+    if err := bytecode.Encode(&dangling); err != nil {
+        t.Fatalf("encode failed: %s", err)
+    }
+
+    var cutOff bytes.Buffer
+    bytecode = &Bytecode{Instructions: code.Make(code.OpJump, 0)[:3]}
+    if err := bytecode.Encode(&cutOff); err != nil {
+        t.Fatalf("encode failed: %s", err)
+    }
+
+    tests := []struct {
+        input []byte
+        expected error
+    }{
+        {nil, ErrFormat},
+        {[]byte("let x = 1;"), ErrFormat},
+        {append(append([]byte{}, magic...), FormatVersion+1), ErrVersion},
+        {valid.Bytes()[:valid.Len()-3], ErrFormat},
+        {dangling.Bytes(), ErrFormat},
+        {cutOff.Bytes(), ErrFormat},
+    }
+
+    for i, tt := range tests {
+        _, err := Decode(bytes.NewReader(tt.input))
+        if !errors.Is(err, tt.expected) {
+            t.Errorf("tests[%d] - wrong error. want=%v, got=%v", i, tt.expected, err)
+        }
+    }
+}
+
+func testSourceMap(t *testing.T, expected, actual code.SourceMap) {
+    t.Helper()
+
+    if len(expected) != len(actual) {
+        t.Errorf("wrong source map length. want=%d, got=%d", len(expected), len(actual))
+        return
+    }
+
+    for i, pos := range expected {
+        if actual[i] != pos {
+            t.Errorf("source map entry %d wrong. want=%+v, got=%+v", i, pos, actual[i])
+        }
+    }
+}
@@ -0,0 +1,262 @@
+// Package enginetest holds the programs every engine running Monkey has to
+// agree on, the tree-walking evaluator and the bytecode vm alike, together
+// with the values they must produce. Each engine's tests run the corpus
+// through it with Run.
+package enginetest
+
+import (
+	"monkey/object"
+	"testing"
+)
+
+type Case struct {
+    Input string
+    Expected interface{}    // converted with object.FromGo, errors compare by kind and message
+}
+
+// Run evaluates every case with run, which must start each program with
+// fresh globals, and checks the result has the expected type, value and
+// printed form
+func Run(t *testing.T, run func(input string) object.Object) {
+    t.Helper()
+
+    for _, tt := range Corpus {
+        want, err := object.FromGo(tt.Expected)
+        if err != nil {
+            t.Fatalf("bad expectation for %q: %s", tt.Input, err)
+        }
+
+        got := run(tt.Input)
+        if got == nil || !got.Equals(want) || got.Type() != want.Type() || got.Inspect() != want.Inspect() {
+            t.Errorf("%q: expected %s %s. got %T (%+v)", tt.Input, want.Type(), want.Inspect(), got, got)
+        }
+    }
+}
+
+// HashOf builds an expected hash from alternating keys and values, in order
+func HashOf(pairs ...interface{}) *object.Hash {
+    hash := object.NewHash()
+    for i := 0; i < len(pairs); i += 2 {
+        key, _ := object.AsHashable(MustFromGo(pairs[i]))
+        hash.Set(key, MustFromGo(pairs[i + 1]))
+    }
+    return hash
+}
+
+// SetOf builds an expected set of elems, in order
+func SetOf(elems ...interface{}) *object.Set {
+    set := object.NewSet()
+    for _, elem := range elems {
+        key, _ := object.AsHashable(MustFromGo(elem))
+        set.Add(key)
+    }
+    return set
+}
+
+// MustFromGo is object.FromGo for values known to convert
+func MustFromGo(v interface{}) object.Object {
+    obj, err := object.FromGo(v)
+    if err != nil {
+        panic(err)
+    }
+    return obj
+}
+
+func fail(kind object.ErrorKind, message string) *object.Error {
+    return &object.Error{Kind: kind, Message: message}
+}
+
+// NameError is the error for using name while it is undefined
+func NameError(name string) *object.Error {
+    return fail(object.NameError, "identifier not found: "+name)
+}
+
+var Corpus = []Case{
+    // arithmetic and comparisons
+    {"5", 5},
+    {"-10", -10},
+    {"5 + 5 + 5 + 5 - 10", 10},
+    {"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+    {"20 + 2 * -10", 0},
+    {"1 < 2", true},
+    {"1 > 2", false},
+    {"1 == 1", true},
+    {"1 != 1", false},
+    {"(1 < 2) == true", true},
+    {"!true", false},
+    {"!!5", true},
+    {"!null", true},
+    {"1.5 + 1.5", 3.0},
+    {"1 + 0.5", 1.5},
+    {"10 / 4", 2},
+    {"10 / 4.0", 2.5},
+    {"1 == 1.0", true},
+    {"[1, 2] == [1.0, 2.0]", true},
+    {`"Hello" + " " + "World!"`, "Hello World!"},
+    {`"a" == "a"`, true},
+    {`"a" != "b"`, true},
+    {"null == null", true},
+    {"null == false", false},
+    {"5 + true", fail(object.TypeError, "type mismatch: INTEGER + BOOLEAN")},
+    {"5 + true; 5", fail(object.TypeError, "type mismatch: INTEGER + BOOLEAN")},
+    {"-true", fail(object.TypeError, "unknown operator: -BOOLEAN")},
+    {"true + false", fail(object.TypeError, "unknown operator: BOOLEAN + BOOLEAN")},
+    {`"Hello" - "World"`, fail(object.TypeError, "unknown operator: STRING - STRING")},
+    {"1 / 0", fail(object.ZeroDivisionError, "division by zero: 1 / 0")},
+
+    // conditionals and returns
+    {"if (true) { 10 }", 10},
+    {"if (false) { 10 }", nil},
+    {"if (1 > 2) { 10 } else { 20 }", 20},
+    {"if (null) { 1 } else { 2 }", 2},
+    {"if (1) { if (false) { 1 } else { 2 } }", 2},
+    {"let x = if (true) { 1 } else { 2 }; x + 1", 2},
+    {"return 10; 9", 10},
+    {"9; return 2 * 5; 9", 10},
+    {"if (10 > 1) { if (10 > 1) { return 10 } return 1 }", 10},
+    {"if (10 > 1) { true + false; 5 }", fail(object.TypeError, "unknown operator: BOOLEAN + BOOLEAN")},
+
+    // variables
+    {"let a = 5 * 5; a", 25},
+    {"let a = 5; let b = a; let c = a + b + 5; c", 15},
+    {"let a = 1; let a = a + 1; a", 2},
+    {"let x2 = 2; let _1 = x2 * 3; _1", 6},
+    {"foobar", NameError("foobar")},
+
+    // functions and closures
+    {"let identity = fn(x) { x }; identity(5)", 5},
+    {"let identity = fn(x) { return x; 1 }; identity(5)", 5},
+    {"let add = fn(x, y) { x + y }; add(5 + 5, add(5, 5))", 20},
+    {"fn(x) { x }(5)", 5},
+    {"let newAdder = fn(x) { fn(y) { x + y } }; newAdder(2)(3)", 5},
+    {"let f = fn(a) { let b = a * 2; fn(c) { fn(d) { a + b + c + d } } }; f(1)(10)(100)", 113},
+    {"let f = fn() { let a = fn() { b }; let b = 7; a() }; f()", 7},
+    {"let a = fn() { b }; let b = 7; a()", 7},
+    {"let x = 1; let f = fn() { let x = 2; x }; [f(), x]", []int{2, 1}},
+    {"let f = fn(n) { if (n > 0) { let big = \"yes\" }; big }; f(1)", "yes"},
+    {"let f = fn(n) { if (n > 0) { let big = \"yes\" }; big }; f(0)", NameError("big")},
+    {"let x = 1; let f = fn() { let y = x; let x = 2; y }; f()", NameError("x")},
+    {"let f = fn(x) { let x = x + 1; x }; f(1)", 2},
+    {"let fact = fn(n) { if (n == 0) { 1 } else { n * fact(n - 1) } }; fact(10)", 3628800},
+    {"let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; fib(15)", 610},
+    {"let apply = fn(f, x) { f(x) }; apply(fn(x) { x * 3 }, 4)", 12},
+    {"let compose = fn(f, g) { fn(x) { g(f(x)) } }; compose(fn(x) { x + 1 }, fn(x) { x * 2 })(5)", 12},
+    {"fn(x) { x }()", fail(object.ArityError, "wrong number of arguments. got=0, want=1")},
+    {"fn(x) { x }(1, 2)", fail(object.ArityError, "wrong number of arguments. got=2, want=1")},
+    {"5()", fail(object.TypeError, "not a function: INTEGER")},
+    {"type(fn() {})", "FUNCTION"},
+    {"let f = fn() { 1 }; f == f", true},
+    {"fn(){}()", nil},
+    {"[fn(){}()]", []interface{}{nil}},
+    {"type(fn(){}())", "NULL"},
+
+    // tail calls and recursion depth
+    {"let loop = fn(n, acc) { if (n == 0) { acc } else { loop(n - 1, acc + 1) } }; loop(100000, 0)", 100000},
+    {"let loop = fn(n) { if (n == 0) { return \"done\"; } return loop(n - 1); }; loop(100000)", "done"},
+    {`let even = fn(n) { if (n == 0) { true } else { odd(n - 1) } };
+      let odd = fn(n) { if (n == 0) { false } else { even(n - 1) } };
+      [even(10001), odd(10001)]`, []bool{false, true}},
+    {"let f = fn(n) { if (n == 0) { len(\"abc\") } else { f(n - 1) } }; f(100000)", 3},
+    {"let f = fn(n) { if (n == 0) { g(1, 2) } else { f(n - 1) } }; let g = fn(x) { x }; f(1000)", fail(object.ArityError, "wrong number of arguments. got=2, want=1")},
+    {"let f = fn() { 1 + f() }; f()", fail(object.RecursionError, "maximum recursion depth exceeded")},
+    {"let f = fn() { 1 + f() }; try { f() } catch (RecursionError e) { e[\"kind\"] }", "RecursionError"},
+
+    // strings, arrays and hashes
+    {`len("hello world")`, 11},
+    {`len(1)`, fail(object.TypeError, "argument to `len` not supported, got INTEGER")},
+    {`len("one", "two")`, fail(object.ArityError, "wrong number of arguments. got=2, want=1")},
+    {"[1, 2 * 2, 3 + 3]", []int{1, 4, 6}},
+    {"[]", []int{}},
+    {"let a = [1, 2, 3]; a[0] + a[1] + a[2]", 6},
+    {"let a = [1, 2, 3]; let i = a[0]; a[i]", 2},
+    {"[1, 2, 3][3]", nil},
+    {"[1, 2, 3][-1]", nil},
+    {"first([1, 2])", 1},
+    {"last([1, 2])", 2},
+    {"rest([1, 2, 3])", []int{2, 3}},
+    {"push([1], 2)", []int{1, 2}},
+    {"let a = [1]; push(a, 2); a", []int{1}},
+    {"1[0]", fail(object.TypeError, "index operator not supported: INTEGER")},
+    {`let two = "two"; {"one": 10 - 9, two: 1 + 1, "thr" + "ee": 6 / 2, 4: 4, true: 5}`, HashOf("one", 1, "two", 2, "three", 3, 4, 4, true, 5)},
+    {`{"b": 1, "a": 2, "b": 3}`, HashOf("b", 3, "a", 2)},
+    {"{}", HashOf()},
+    {`{"a": one, two: 2}`, NameError("one")},
+    {`{"a": 1, two: three}`, NameError("two")},
+    {`{"foo": 5}["foo"]`, 5},
+    {`{"foo": 5}["bar"]`, nil},
+    {`{[1, "a"]: 5}[[1, "a"]]`, 5},
+    {`{freeze({"a": 1}): 5}[freeze({"a": 1})]`, 5},
+    {`{1: "a"}[1.0]`, "a"},
+    {`{"name": "Monkey"}[fn(x) { x }]`, fail(object.TypeError, "unusable as hash key: FUNCTION")},
+    {`{{"a": 1}: 1}`, fail(object.TypeError, "unusable as hash key: HASH")},
+    {`keys({"b": 2, "a": 1, 3: 3})`, []interface{}{"b", "a", 3}},
+    {`entries({"b": 2, "a": 1})`, []interface{}{[]interface{}{"b", 2}, []interface{}{"a", 1}}},
+    {`merge({"a": 1, "b": 2}, {"b": 3, "c": 4})`, HashOf("a", 1, "b", 3, "c", 4)},
+    {`let h = {"a": 1}; delete(h, "a"); h`, HashOf("a", 1)},
+    {`has({"a": 1}, "a")`, true},
+    {`union(set([1, 2]), set([2, 3]))`, SetOf(1, 2, 3)},
+    {`let s = set([1]); add(s, 2); s`, SetOf(1)},
+    {`set([fn(x) { x }])`, fail(object.TypeError, "unusable as set element: FUNCTION")},
+
+    // conversions
+    {`type(1.5)`, "FLOAT"},
+    {`type(len)`, "BUILTIN"},
+    {`int(" -7 ")`, -7},
+    {`int("abc")`, fail(object.ValueError, `could not parse "abc" as integer`)},
+    {`float("2.5")`, 2.5},
+    {`str([1, "a"])`, "[1, a]"},
+    {`bool(0)`, true},
+    {`is_null([1][5])`, true},
+
+    // try, catch and finally
+    {`try { 1 } catch (e) { 2 }`, 1},
+    {`try { throw "oops"; 1 } catch (e) { e["message"] }`, "oops"},
+    {`try { 1 + true } catch (e) { [e["kind"], e["message"]] }`, []string{"TypeError", "type mismatch: INTEGER + BOOLEAN"}},
+    {`try { throw [1, 2] } catch (e) { [e["value"], e["message"]] }`, []interface{}{[]int{1, 2}, "[1, 2]"}},
+    {`try { foo } catch { "recovered" }`, "recovered"},
+    {`1 + try { throw "x" } catch { 2 }`, 3},
+    {`[1, try { 2 } finally { 3 }, 4]`, []int{1, 2, 4}},
+    {`throw "uncaught"; 1`, fail(object.UserError, "uncaught")},
+    {`try { throw "a" } catch (e) { throw "b" }`, fail(object.UserError, "b")},
+    {`try { try { throw "inner" } catch (e) { throw e } } catch (e) { e["message"] }`, "inner"},
+    {`let x = 1; try { 2 } finally { let x = 5 }; x`, 5},
+    {`try { throw "a" } finally { 3 }`, fail(object.UserError, "a")},
+    {`try { throw "a" } catch (e) { 1 } finally { throw "f" }`, fail(object.UserError, "f")},
+    {`let r = []; try { try { throw "a" } finally { let r = push(r, "f") } } catch (e) { push(r, e["message"]) }`, []string{"f", "a"}},
+    {`let f = fn() { try { return 1 } finally { 2 }; 3 }; f()`, 1},
+    {`let f = fn() { try { return 1 } finally { return 2 } }; f()`, 2},
+    {`let f = fn() { try { throw "x" } catch (e) { return 7 }; 3 }; f()`, 7},
+    {`let f = fn() { try { throw "x" } catch (e) { return 7 } finally { 8 } }; f()`, 7},
+    {`let f = fn() { try { try { return 1 } finally { 2 } } finally { 3 } }; f()`, 1},
+    {`let f = fn() { try { try { return 1 } finally { throw "f" } } catch (e) { e["message"] } }; f()`, "f"},
+    {`let f = fn() { try { return 1 } finally { 2 } }; let g = fn() { f() + 1 }; g()`, 2},
+    {`try { return 1 } finally { 2 }`, 1},
+    {`let parse = fn(s) { try { int(s) } catch (e) { -1 } }; [parse("1"), parse("x")]`, []int{1, -1}},
+    {`let e = 5; try { throw "x" } catch (e) { 1 }; e`, 5},
+    {`try { throw "x" } catch (err) { 1 }; err`, NameError("err")},
+    {`try { throw "x" } catch (e) { let inside = 1 }; inside`, NameError("inside")},
+    {`let f = fn(e) { try { throw "x" } catch (e) { e["message"] } }; [f(1), f(2)]`, []string{"x", "x"}},
+    {`let f = fn(e) { let r = try { throw "x" } catch (e) { e["message"] }; [e, r] }; f(1)`, []interface{}{1, "x"}},
+    {`let f = fn() { try { throw "x" } catch (e) { fn() { e["message"] } } }; f()()`, "x"},
+    {`try { throw "x" } catch (e) { fn() { e["message"] } }()`, "x"},
+    {`let f = fn(n) { try { if (n == 0) { throw "bottom" } else { f(n - 1) } } catch (e) { throw e } }; f(10)`, fail(object.UserError, "bottom")},
+
+    // error kinds and stacks
+    {`try { 1 / 0 } catch (ZeroDivisionError e) { "div" } catch (e) { "other" }`, "div"},
+    {`try { foo } catch (ZeroDivisionError e) { "div" } catch (e) { "other" }`, "other"},
+    {`try { foo } catch (TypeError e) { "type" } catch (NameError e) { e["kind"] }`, "NameError"},
+    {`try { foo } catch (TypeError e) { "type" }`, NameError("foo")},
+    {`try { foo } catch (TypeError e) { "type" } finally { 1 }`, NameError("foo")},
+    {`throw {"kind": "ValueError", "message": "too far"}`, fail(object.ValueError, "too far")},
+    {`throw {"kind": "LimitError", "message": "fake"}`, fail(object.UserError, "fake")},
+    {`try { throw {"message": "bad", "kind": "ValueError", "id": 7} } catch (ValueError e) { e["value"]["id"] }`, 7},
+    {`try { try { throw "x" } catch (e) { throw merge(e, {"id": 1}) } } catch (e) { [e["kind"], e["message"], e["value"]["id"]] }`, []interface{}{"UserError", "x", 1}},
+    {`let r = 0; try { try { foo } catch (TypeError e) { 1 } finally { let r = 9 } } catch (e) { r }`, 9},
+    {`let inner = fn() { throw "deep" };
+      let outer = fn() { let r = inner(); r };
+      try { fn() { let r = outer(); r }() } catch (e) { e["stack"] }`, []string{"inner", "outer", "<anonymous>"}},
+    {`let inner = fn() { throw "deep" };
+      let outer = fn() { inner() };
+      try { fn() { outer() }() } catch (e) { e["stack"] }`, []string{"inner"}},
+    {`let f = fn() { try { 1 + true } catch (e) { throw e } }; let g = fn() { let r = f(); r }; try { g() } catch (e) { e["stack"] }`, []string{"f", "g"}},
+}
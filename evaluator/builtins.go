@@ -1,15 +1,32 @@
 package evaluator
 
 import (
-    "fmt"
     "monkey/object"
+    "strconv"
+    "strings"
 )
 
+// NewBuiltins returns a fresh copy of the builtin functions, with the ones
+// doing I/O going through stdio instead of the standard streams. Interpreters
+// that want their own set of builtins bind these in their outermost environment.
+func NewBuiltins(stdio *IO) map[string]*object.BuiltIn {
+    fns := make(map[string]*object.BuiltIn, len(builtins))
+    for name, builtin := range builtins {
+        fns[name] = builtin
+    }
+
+    for name, builtin := range stdio.builtins() {
+        fns[name] = builtin
+    }
+
+    return fns
+}
+
 var builtins = map [string]*object.BuiltIn{
     "len": &object.BuiltIn{
         Fn: func(args ...object.Object) object.Object {
             if len(args) != 1 {
-                return newError("wrong number of arguments. got=%d, want=1", len(args))
+                return newError(object.ArityError, "wrong number of arguments. got=%d, want=1", len(args))
             }
 
             switch arg := args[0].(type) {
@@ -17,19 +34,23 @@ var builtins = map [string]*object.BuiltIn{
                 return &object.Integer{Value: int64(len(arg.Elements))}
             case *object.String:
                 return &object.Integer{Value: int64(len(arg.Value))}
+            case *object.Hash:
+                return &object.Integer{Value: int64(arg.Len())}
+            case *object.Set:
+                return &object.Integer{Value: int64(arg.Len())}
             default:
-                return newError("argument to `len` not supported, got %s", args[0].Type())
+                return newError(object.TypeError, "argument to `len` not supported, got %s", args[0].Type())
             }
         },
     },
     "first": &object.BuiltIn{
         Fn: func(args ...object.Object) object.Object {
             if len(args) != 1 {
-                return newError("wrong number of arguments. got=%d, want=1", len(args))
+                return newError(object.ArityError, "wrong number of arguments. got=%d, want=1", len(args))
             }
 
             if args[0].Type() != object.ARRAY_OBJ {
-                return newError("argument to `first` must be ARRAY, got %s", args[0].Type())
+                return newError(object.TypeError, "argument to `first` must be ARRAY, got %s", args[0].Type())
             }
 
             arr := args[0].(*object.Array)
@@ -43,11 +64,11 @@ var builtins = map [string]*object.BuiltIn{
     "last": &object.BuiltIn{
         Fn: func(args ...object.Object) object.Object {
             if len(args) != 1 {
-                return newError("wrong number of arguments. got=%d, want=1", len(args))
+                return newError(object.ArityError, "wrong number of arguments. got=%d, want=1", len(args))
             }
 
             if args[0].Type() != object.ARRAY_OBJ {
-                return newError("argument to `last` must be ARRAY, got %s", args[0].Type())
+                return newError(object.TypeError, "argument to `last` must be ARRAY, got %s", args[0].Type())
             }
 
             arr := args[0].(*object.Array)
@@ -62,11 +83,11 @@ var builtins = map [string]*object.BuiltIn{
     "rest": &object.BuiltIn{
         Fn: func(args ...object.Object) object.Object {
             if len(args) != 1 {
-                return newError("wrong number of arguments. got=%d, want=1", len(args))
+                return newError(object.ArityError, "wrong number of arguments. got=%d, want=1", len(args))
             }
 
             if args[0].Type() != object.ARRAY_OBJ {
-                return newError("argument to `rest` must be ARRAY, got %s", args[0].Type())
+                return newError(object.TypeError, "argument to `rest` must be ARRAY, got %s", args[0].Type())
             }
 
             arr := args[0].(*object.Array)
@@ -85,11 +106,11 @@ var builtins = map [string]*object.BuiltIn{
     "push": &object.BuiltIn{
         Fn: func(args ...object.Object) object.Object {
             if len(args) != 2 {
-                return newError("wrong number of arguments. got=%d, want=2", len(args))
+                return newError(object.ArityError, "wrong number of arguments. got=%d, want=2", len(args))
             }
 
             if args[0].Type() != object.ARRAY_OBJ {
-                return newError("argument to `push` must be ARRAY, got %s", args[0].Type())
+                return newError(object.TypeError, "argument to `push` must be ARRAY, got %s", args[0].Type())
             }
             
             arr := args[0].(*object.Array)
@@ -102,13 +123,347 @@ var builtins = map [string]*object.BuiltIn{
             return &object.Array{Elements: newElems}
         },
     },
-    "puts": &object.BuiltIn{
+    "keys": &object.BuiltIn{
+        Fn: func(args ...object.Object) object.Object {
+            if len(args) != 1 {
+                return newError(object.ArityError, "wrong number of arguments. got=%d, want=1", len(args))
+            }
+
+            if args[0].Type() != object.HASH_OBJ {
+                return newError(object.TypeError, "argument to `keys` must be HASH, got %s", args[0].Type())
+            }
+
+            pairs := args[0].(*object.Hash).OrderedPairs()
+            elems := make([]object.Object, len(pairs))
+            for i, pair := range pairs {
+                elems[i] = pair.Key
+            }
+
+            return &object.Array{Elements: elems}
+        },
+    },
+    "values": &object.BuiltIn{
+        Fn: func(args ...object.Object) object.Object {
+            if len(args) != 1 {
+                return newError(object.ArityError, "wrong number of arguments. got=%d, want=1", len(args))
+            }
+
+            if set, ok := args[0].(*object.Set); ok {
+                return &object.Array{Elements: set.Elements()}
+            }
+
+            if args[0].Type() != object.HASH_OBJ {
+                return newError(object.TypeError, "argument to `values` must be HASH or SET, got %s", args[0].Type())
+            }
+
+            pairs := args[0].(*object.Hash).OrderedPairs()
+            elems := make([]object.Object, len(pairs))
+            for i, pair := range pairs {
+                elems[i] = pair.Value
+            }
+
+            return &object.Array{Elements: elems}
+        },
+    },
+    // entries turns a hash into an array of [key, value] arrays so it can be
+    // iterated over with first and rest like any other array
+    "entries": &object.BuiltIn{
+        Fn: func(args ...object.Object) object.Object {
+            if len(args) != 1 {
+                return newError(object.ArityError, "wrong number of arguments. got=%d, want=1", len(args))
+            }
+
+            if args[0].Type() != object.HASH_OBJ {
+                return newError(object.TypeError, "argument to `entries` must be HASH, got %s", args[0].Type())
+            }
+
+            pairs := args[0].(*object.Hash).OrderedPairs()
+            elems := make([]object.Object, len(pairs))
+            for i, pair := range pairs {
+                elems[i] = &object.Array{Elements: []object.Object{pair.Key, pair.Value}}
+            }
+
+            return &object.Array{Elements: elems}
+        },
+    },
+    "has": &object.BuiltIn{
+        Fn: func(args ...object.Object) object.Object {
+            if len(args) != 2 {
+                return newError(object.ArityError, "wrong number of arguments. got=%d, want=2", len(args))
+            }
+
+            key, ok := object.AsHashable(args[1])
+
+            switch arg := args[0].(type) {
+            case *object.Hash:
+                if !ok {
+                    return newError(object.TypeError, "unusable as hash key: %s", args[1].Type())
+                }
+                _, ok = arg.Get(key)
+                return boolToBoolean(ok)
+            case *object.Set:
+                // nothing unhashable can ever be a member
+                return boolToBoolean(ok && arg.Has(key))
+            default:
+                return newError(object.TypeError, "argument to `has` must be HASH or SET, got %s", args[0].Type())
+            }
+        },
+    },
+    // like push, merge and delete leave their arguments untouched and return a new hash
+    "merge": &object.BuiltIn{
         Fn: func(args ...object.Object) object.Object {
+            if len(args) < 2 {
+                return newError(object.ArityError, "wrong number of arguments. got=%d, want at least 2", len(args))
+            }
+
+            merged := object.NewHash()
             for _, arg := range args {
-                fmt.Println(arg.Inspect())
+                hash, ok := arg.(*object.Hash)
+                if !ok {
+                    return newError(object.TypeError, "argument to `merge` must be HASH, got %s", arg.Type())
+                }
+
+                // later hashes win when keys clash
+                for _, pair := range hash.OrderedPairs() {
+                    merged.Set(pair.Key.(object.Hashable), pair.Value)
+                }
             }
 
-            return NULL
+            return merged
         },
     },
+    "delete": &object.BuiltIn{
+        Fn: func(args ...object.Object) object.Object {
+            if len(args) != 2 {
+                return newError(object.ArityError, "wrong number of arguments. got=%d, want=2", len(args))
+            }
+
+            if args[0].Type() != object.HASH_OBJ {
+                return newError(object.TypeError, "argument to `delete` must be HASH, got %s", args[0].Type())
+            }
+
+            key, ok := object.AsHashable(args[1])
+            if !ok {
+                return newError(object.TypeError, "unusable as hash key: %s", args[1].Type())
+            }
+
+            hash := args[0].(*object.Hash).Copy()
+            hash.Delete(key)
+
+            return hash
+        },
+    },
+    // freeze returns a read-only copy of a hash, which can then be used as a key
+    // in another hash
+    "freeze": &object.BuiltIn{
+        Fn: func(args ...object.Object) object.Object {
+            if len(args) != 1 {
+                return newError(object.ArityError, "wrong number of arguments. got=%d, want=1", len(args))
+            }
+
+            if args[0].Type() != object.HASH_OBJ {
+                return newError(object.TypeError, "argument to `freeze` must be HASH, got %s", args[0].Type())
+            }
+
+            hash := args[0].(*object.Hash)
+            if hash.Frozen() {
+                return hash
+            }
+
+            frozen := hash.Copy()
+            frozen.Freeze()
+
+            return frozen
+        },
+    },
+    // set builds a set out of the elements of an array, dropping duplicates
+    "set": &object.BuiltIn{
+        Fn: func(args ...object.Object) object.Object {
+            if len(args) > 1 {
+                return newError(object.ArityError, "wrong number of arguments. got=%d, want=0 or 1", len(args))
+            }
+
+            set := object.NewSet()
+            if len(args) == 0 {
+                return set
+            }
+
+            if args[0].Type() != object.ARRAY_OBJ {
+                return newError(object.TypeError, "argument to `set` must be ARRAY, got %s", args[0].Type())
+            }
+
+            for _, elem := range args[0].(*object.Array).Elements {
+                key, ok := object.AsHashable(elem)
+                if !ok {
+                    return newError(object.TypeError, "unusable as set element: %s", elem.Type())
+                }
+                set.Add(key)
+            }
+
+            return set
+        },
+    },
+    // add and remove return a new set, the way push does for arrays
+    "add": &object.BuiltIn{
+        Fn: func(args ...object.Object) object.Object {
+            if len(args) != 2 {
+                return newError(object.ArityError, "wrong number of arguments. got=%d, want=2", len(args))
+            }
+
+            if args[0].Type() != object.SET_OBJ {
+                return newError(object.TypeError, "argument to `add` must be SET, got %s", args[0].Type())
+            }
+
+            elem, ok := object.AsHashable(args[1])
+            if !ok {
+                return newError(object.TypeError, "unusable as set element: %s", args[1].Type())
+            }
+
+            set := args[0].(*object.Set).Copy()
+            set.Add(elem)
+
+            return set
+        },
+    },
+    "remove": &object.BuiltIn{
+        Fn: func(args ...object.Object) object.Object {
+            if len(args) != 2 {
+                return newError(object.ArityError, "wrong number of arguments. got=%d, want=2", len(args))
+            }
+
+            if args[0].Type() != object.SET_OBJ {
+                return newError(object.TypeError, "argument to `remove` must be SET, got %s", args[0].Type())
+            }
+
+            set := args[0].(*object.Set).Copy()
+            if elem, ok := object.AsHashable(args[1]); ok {
+                set.Remove(elem)
+            }
+
+            return set
+        },
+    },
+    "union": setOperation("union", (*object.Set).Union),
+    "intersection": setOperation("intersection", (*object.Set).Intersection),
+    "difference": setOperation("difference", (*object.Set).Difference),
+    // type returns the name of the type of its argument, e.g. "INTEGER"
+    "type": &object.BuiltIn{
+        Fn: func(args ...object.Object) object.Object {
+            if len(args) != 1 {
+                return newError(object.ArityError, "wrong number of arguments. got=%d, want=1", len(args))
+            }
+
+            return &object.String{Value: string(args[0].Type())}
+        },
+    },
+    "int": &object.BuiltIn{
+        Fn: func(args ...object.Object) object.Object {
+            if len(args) != 1 {
+                return newError(object.ArityError, "wrong number of arguments. got=%d, want=1", len(args))
+            }
+
+            switch arg := args[0].(type) {
+            case *object.Integer:
+                return arg
+            case *object.Float:
+                // truncates towards zero
+                return &object.Integer{Value: int64(arg.Value)}
+            case *object.Boolean:
+                if arg.Value {
+                    return &object.Integer{Value: 1}
+                }
+                return &object.Integer{Value: 0}
+            case *object.String:
+                value, err := strconv.ParseInt(strings.TrimSpace(arg.Value), 10, 64)
+                if err != nil {
+                    return newError(object.ValueError, "could not parse %q as integer", arg.Value)
+                }
+                return &object.Integer{Value: value}
+            default:
+                return newError(object.TypeError, "argument to `int` not supported, got %s", args[0].Type())
+            }
+        },
+    },
+    "float": &object.BuiltIn{
+        Fn: func(args ...object.Object) object.Object {
+            if len(args) != 1 {
+                return newError(object.ArityError, "wrong number of arguments. got=%d, want=1", len(args))
+            }
+
+            switch arg := args[0].(type) {
+            case *object.Float:
+                return arg
+            case *object.Integer:
+                return &object.Float{Value: float64(arg.Value)}
+            case *object.Boolean:
+                if arg.Value {
+                    return &object.Float{Value: 1}
+                }
+                return &object.Float{Value: 0}
+            case *object.String:
+                value, err := strconv.ParseFloat(strings.TrimSpace(arg.Value), 64)
+                if err != nil {
+                    return newError(object.ValueError, "could not parse %q as float", arg.Value)
+                }
+                return &object.Float{Value: value}
+            default:
+                return newError(object.TypeError, "argument to `float` not supported, got %s", args[0].Type())
+            }
+        },
+    },
+    "str": &object.BuiltIn{
+        Fn: func(args ...object.Object) object.Object {
+            if len(args) != 1 {
+                return newError(object.ArityError, "wrong number of arguments. got=%d, want=1", len(args))
+            }
+
+            if str, ok := args[0].(*object.String); ok {
+                return str
+            }
+
+            return &object.String{Value: args[0].Inspect()}
+        },
+    },
+    // bool follows the same rules as if conditions: only null and false are falsy
+    "bool": &object.BuiltIn{
+        Fn: func(args ...object.Object) object.Object {
+            if len(args) != 1 {
+                return newError(object.ArityError, "wrong number of arguments. got=%d, want=1", len(args))
+            }
+
+            return boolToBoolean(isTruthy(args[0]))
+        },
+    },
+    "is_null": &object.BuiltIn{
+        Fn: func(args ...object.Object) object.Object {
+            if len(args) != 1 {
+                return newError(object.ArityError, "wrong number of arguments. got=%d, want=1", len(args))
+            }
+
+            return boolToBoolean(args[0].Type() == object.NULL_OBJ)
+        },
+    },
+}
+
+
+func setOperation(name string, op func(*object.Set, *object.Set) *object.Set) *object.BuiltIn {
+    return &object.BuiltIn{
+        Fn: func(args ...object.Object) object.Object {
+            if len(args) != 2 {
+                return newError(object.ArityError, "wrong number of arguments. got=%d, want=2", len(args))
+            }
+
+            left, ok := args[0].(*object.Set)
+            if !ok {
+                return newError(object.TypeError, "argument to `%s` must be SET, got %s", name, args[0].Type())
+            }
+
+            right, ok := args[1].(*object.Set)
+            if !ok {
+                return newError(object.TypeError, "argument to `%s` must be SET, got %s", name, args[1].Type())
+            }
+
+            return op(left, right)
+        },
+    }
 }
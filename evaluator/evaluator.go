@@ -1,6 +1,7 @@
 package evaluator
 
 import (
+    "context"
     "fmt"
     "monkey/ast"
     "monkey/object"
@@ -8,112 +9,262 @@ import (
 
 // no need to create new instances of true and false every time if we can reference them
 var (
-    NULL = &object.Null{}
-    TRUE = &object.Boolean{Value: true}
-    FALSE = &object.Boolean{Value: false}
+    NULL = object.NULL
+    TRUE = object.TRUE
+    FALSE = object.FALSE
 )
 
+// Eval evaluates node in env until it completes
 func Eval(node ast.Node, env *object.Environment) object.Object {
+    return EvalContext(context.Background(), node, env, Limits{})
+}
+
+// EvalContext evaluates node in env like Eval, but gives up with a LimitError
+// once ctx is done or the evaluation goes over limits
+func EvalContext(ctx context.Context, node ast.Node, env *object.Environment, limits Limits) object.Object {
+    e := &evaluator{Meter: NewMeter(ctx, limits)}
+    return e.eval(node, env)
+}
+
+// evaluator holds the state of a single call to Eval
+type evaluator struct {
+    *Meter
+    depth int           // Monkey functions currently being called
+}
+
+func (e *evaluator) eval(node ast.Node, env *object.Environment) object.Object {
+    if err := e.Step(); err != nil {
+        return err
+    }
+
     switch node := node.(type) {
         // STATEMENTS
         case *ast.Program:
-            return evalProgram(node, env)
+            return e.evalProgram(node, env)
         case *ast.ExpressionStatement:
-            return Eval(node.Expression, env)
+            return e.eval(node.Expression, env)
         case *ast.BlockStatement:
-            return evalBlockStatement(node, env)
+            return e.evalBlockStatement(node, env)
         case *ast.LetStatement:
-            val := Eval(node.Value, env)
+            val := e.eval(node.Value, env)
             if isError(val) {
                 return val
             }
-            env.Set(node.Name.Value, val)
+            if node.Name.Local {
+                env.SetSlot(node.Name.Slot, val)
+            } else {
+                env.Set(node.Name.Value, val)
+            }
         case *ast.ReturnStatement:
-            val := Eval(node.ReturnValue, env)
+            val := e.eval(node.ReturnValue, env)
             if isError(val) {
                 return val
             }
             return &object.ReturnValue{Value: val}
+        case *ast.ThrowStatement:
+            val := e.eval(node.Value, env)
+            if isError(val) {
+                return val
+            }
+            return newThrownError(val)
 
         // EXPRESSIONS
         case *ast.IntegerLiteral:
             return &object.Integer{Value: node.Value}
+        case *ast.FloatLiteral:
+            return &object.Float{Value: node.Value}
+        case *ast.NullLiteral:
+            return NULL
         case *ast.PrefixExpression:
-            right := Eval(node.Right, env)
+            right := e.eval(node.Right, env)
             if isError(right) {
                 return right
             }
             return evalPrefixExpression(node.Operator, right)
         case *ast.InfixExpression:
-            left := Eval(node.Left, env)
+            left := e.eval(node.Left, env)
             if isError(left) {
                 return left
             }
 
-            right := Eval(node.Right, env)
+            right := e.eval(node.Right, env)
             if isError(right) {
                 return right
             }
 
-            return evalInfixExpression(node.Operator, left, right)
+            return e.Allocate(evalInfixExpression(node.Operator, left, right))
         case *ast.Boolean:
             return boolToBoolean(node.Value)
         case *ast.IfExpression:
-            return evalIfExpression(node, env)
+            return e.evalIfExpression(node, env)
+        case *ast.TryExpression:
+            return e.evalTryExpression(node, env)
         case *ast.Identifier:
             return evalIdentifier(node, env)
         case *ast.FunctionLiteral:
             return &object.Function{
+                Name: node.Name,
                 // reuse Parameters and Body of the AST node
                 Parameters: node.Parameters,
                 Body: node.Body,
                 Env: env,
+                Resolved: node.Resolved,
+                Slots: node.Slots,
             }
         case *ast.CallExpression:
-            function := Eval(node.Function, env)
+            function := e.eval(node.Function, env)
             if isError(function) {
                 return function
             }
 
-            args := evalExpressions(node.Arguments, env)
+            args := e.evalExpressions(node.Arguments, env)
             if len(args) == 1 && isError(args[0]) {
                 return args[0]
             }
 
-            return applyFunction(function, args)
+            if node.Tail {
+                // applyFunction makes the call once the current one has returned
+                return &tailCall{function: function, args: args}
+            }
+
+            return e.applyFunction(function, args)
         case *ast.IndexExpression:
-            left := Eval(node.Left, env)
+            left := e.eval(node.Left, env)
             if isError(left) {
                 return left
             }
-            index := Eval(node.Index, env)
+            index := e.eval(node.Index, env)
             if isError(index) {
                 return index
             }
             return evalIndexExpression(left, index)
         case *ast.StringLiteral:
-            return &object.String{
+            return e.Allocate(&object.String{
                 Value: node.Value,
-            }
+            })
         case *ast.ArrayLiteral:
-            elems := evalExpressions(node.Elements, env)
+            elems := e.evalExpressions(node.Elements, env)
             if len(elems) == 1 && isError(elems[0]) {
                 return elems[0]
             }
-            return &object.Array{Elements: elems}
+            return e.Allocate(&object.Array{Elements: elems})
         case *ast.HashLiteral:
-            return evalHashLiteral(node, env)
+            return e.Allocate(e.evalHashLiteral(node, env))
     }
 
     return nil
 }
 
-func newError(format string, a ...interface{}) *object.Error {
+func newError(kind object.ErrorKind, format string, a ...interface{}) *object.Error {
     return &object.Error{
+        Kind: kind,
         Message: fmt.Sprintf(format, a...),
     }
 }
 
+// throwing a string uses it as the message. Throwing a caught error again keeps
+// its kind, message, value and stack. Scripts can also raise errors of other
+// kinds by throwing their own hash with "kind" and "message" keys. Anything
+// else is a UserError described by its Inspect output
+func newThrownError(val object.Object) *object.Error {
+    hash, isHash := val.(*object.Hash)
+    if isHash && isErrorHash(hash) {
+        errObj := &object.Error{
+            Kind: object.ErrorKind(hashString(hash, "kind")),
+            Message: hashString(hash, "message"),
+        }
+
+        if value := hashValue(hash, "value"); value != NULL {
+            errObj.Value = value
+        }
+
+        if stack, ok := hashValue(hash, "stack").(*object.Array); ok {
+            for _, frame := range stack.Elements {
+                errObj.Stack = append(errObj.Stack, frame.Inspect())
+            }
+        }
+
+        return errObj
+    }
+
+    // other hashes with a message raise an error of their kind if it is a
+    // known one and can be caught, a UserError otherwise. They are kept whole
+    // as the thrown value
+    if isHash {
+        if message, ok := hashValue(hash, "message").(*object.String); ok {
+            kind := object.ErrorKind(hashString(hash, "kind"))
+            if !kind.Valid() || kind == object.LimitError {
+                kind = object.UserError
+            }
+            return &object.Error{Kind: kind, Message: message.Value, Value: val}
+        }
+    }
+
+    if str, ok := val.(*object.String); ok {
+        return &object.Error{Kind: object.UserError, Message: str.Value, Value: val}
+    }
+
+    return &object.Error{Kind: object.UserError, Message: val.Inspect(), Value: val}
+}
+
+// errorToHash turns an error into the value bound by a catch block. The hash
+// is frozen, which is how newThrownError tells a rethrow from a user hash
+// that happens to have the same keys.
+func errorToHash(errObj *object.Error) *object.Hash {
+    value := object.Object(NULL)
+    if errObj.Value != nil {
+        value = errObj.Value
+    }
+
+    stack := make([]object.Object, len(errObj.Stack))
+    for i, frame := range errObj.Stack {
+        stack[i] = &object.String{Value: frame}
+    }
+
+    hash := object.NewHash()
+    hash.Set(&object.String{Value: "message"}, &object.String{Value: errObj.Message})
+    hash.Set(&object.String{Value: "kind"}, &object.String{Value: string(errObj.ErrorKind())})
+    hash.Set(&object.String{Value: "value"}, value)
+    hash.Set(&object.String{Value: "stack"}, &object.Array{Elements: stack})
+    hash.Freeze()
+
+    return hash
+}
+
+var errorHashKeys = []string{"message", "kind", "value", "stack"}
+
+// isErrorHash reports whether hash looks like one made by errorToHash: frozen,
+// with exactly its keys
+func isErrorHash(hash *object.Hash) bool {
+    if !hash.Frozen() || hash.Len() != len(errorHashKeys) {
+        return false
+    }
+
+    for _, key := range errorHashKeys {
+        if _, ok := hash.Get(&object.String{Value: key}); !ok {
+            return false
+        }
+    }
+
+    _, hasMessage := hashValue(hash, "message").(*object.String)
+    _, hasKind := hashValue(hash, "kind").(*object.String)
+    return hasMessage && hasKind
+}
+
+func hashValue(hash *object.Hash, key string) object.Object {
+    if value, ok := hash.Get(&object.String{Value: key}); ok {
+        return value
+    }
+    return NULL
+}
+
+func hashString(hash *object.Hash, key string) string {
+    if str, ok := hashValue(hash, key).(*object.String); ok {
+        return str.Value
+    }
+    return ""
+}
+
 func isError(obj object.Object) bool {
     if obj != nil {
         return obj.Type() == object.ERROR_OBJ
@@ -128,11 +279,11 @@ func boolToBoolean(val bool) *object.Boolean {
     return FALSE
 }
 
-func evalProgram(program *ast.Program, env *object.Environment) object.Object {
+func (e *evaluator) evalProgram(program *ast.Program, env *object.Environment) object.Object {
     var result object.Object
 
     for _, statement := range program.Statements {
-        result = Eval(statement, env)
+        result = e.eval(statement, env)
 
         switch result := result.(type) {
         case *object.ReturnValue:
@@ -152,7 +303,7 @@ func evalPrefixExpression(operator string, right object.Object) object.Object {
         case "-":
             return evalMinusPrefixOperatorExpression(right)
         default:
-            return newError("unknown operator: %s%s", operator, right.Type())
+            return newError(object.TypeError, "unknown operator: %s%s", operator, right.Type())
     }
 }
 
@@ -160,32 +311,38 @@ func evalInfixExpression(operator string, left object.Object, right object.Objec
     switch {
     case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
         return evalIntegerInfixExpression(operator, left, right)
+    case isNumber(left) && isNumber(right):
+        // at least one side is a float, so the integer side gets promoted
+        return evalFloatInfixExpression(operator, left, right)
     case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
         return evalStringInfixExpression(operator, left, right)
-    // since we're handling pointers in the next few statements, check for all other operands
-    // will have to happen before them. Otherwise 5 == 5 would evaluate to false since new pointers
-    // are initialized whenever an Integer object is created. Comparison between booleans is therefore
-    // faster as the interpreter has to unwrap Integer objects before a comparison is made
+    // everything else compares structurally, so [1, 2] == [1, 2] even though
+    // the two arrays are different objects
     case operator == "==":
-        return boolToBoolean(left == right)
+        return boolToBoolean(left.Equals(right))
     case operator == "!=":
-        return boolToBoolean(left != right)
+        return boolToBoolean(!left.Equals(right))
     case left.Type() != right.Type():
-        return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
+        return newError(object.TypeError, "type mismatch: %s %s %s", left.Type(), operator, right.Type())
     default:
-        return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+        return newError(object.TypeError, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
     }
 }
 
 func evalStringInfixExpression(operator string, left object.Object, right object.Object) object.Object {
-    // supporting only concat for now. Can support == and != later
-    if operator != "+" {
-        return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
-    }
-
     leftVal := left.(*object.String).Value
     rightVal := right.(*object.String).Value
-    return &object.String{Value: leftVal + rightVal}
+
+    switch operator {
+    case "+":
+        return &object.String{Value: leftVal + rightVal}
+    case "==":
+        return boolToBoolean(leftVal == rightVal)
+    case "!=":
+        return boolToBoolean(leftVal != rightVal)
+    default:
+        return newError(object.TypeError, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
+    }
 }
 
 func evalIntegerInfixExpression(operator string, left object.Object, right object.Object) object.Object {
@@ -201,6 +358,9 @@ func evalIntegerInfixExpression(operator string, left object.Object, right objec
     case "*":
         return &object.Integer{Value: leftVal * rightVal}
     case "/":
+        if rightVal == 0 {
+            return newError(object.ZeroDivisionError, "division by zero: %d / %d", leftVal, rightVal)
+        }
         return &object.Integer{Value: leftVal / rightVal}
     case "<":
         return boolToBoolean(leftVal < rightVal)
@@ -211,8 +371,45 @@ func evalIntegerInfixExpression(operator string, left object.Object, right objec
     case "!=":
         return boolToBoolean(leftVal != rightVal)
     default:
-        return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+        return newError(object.TypeError, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
+    }
+}
+
+func evalFloatInfixExpression(operator string, left object.Object, right object.Object) object.Object {
+    leftVal := toFloat(left)
+    rightVal := toFloat(right)
+
+    switch operator {
+    case "+":
+        return &object.Float{Value: leftVal + rightVal}
+    case "-":
+        return &object.Float{Value: leftVal - rightVal}
+    case "*":
+        return &object.Float{Value: leftVal * rightVal}
+    case "/":
+        return &object.Float{Value: leftVal / rightVal}
+    case "<":
+        return boolToBoolean(leftVal < rightVal)
+    case ">":
+        return boolToBoolean(leftVal > rightVal)
+    case "==":
+        return boolToBoolean(leftVal == rightVal)
+    case "!=":
+        return boolToBoolean(leftVal != rightVal)
+    default:
+        return newError(object.TypeError, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
+    }
+}
+
+func isNumber(obj object.Object) bool {
+    return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.FLOAT_OBJ
+}
+
+func toFloat(obj object.Object) float64 {
+    if integer, ok := obj.(*object.Integer); ok {
+        return float64(integer.Value)
     }
+    return obj.(*object.Float).Value
 }
 
 func evalBangOperatorExpression(right object.Object) object.Object {
@@ -229,8 +426,12 @@ func evalBangOperatorExpression(right object.Object) object.Object {
 }
 
 func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
+    if float, ok := right.(*object.Float); ok {
+        return &object.Float{Value: -float.Value}
+    }
+
     if right.Type() != object.INTEGER_OBJ {
-        return newError("unknown operator: -%s", right.Type())
+        return newError(object.TypeError, "unknown operator: -%s", right.Type())
     }
 
     value := right.(*object.Integer).Value
@@ -252,26 +453,80 @@ func isTruthy(obj object.Object) bool {
 
 // consequence is evaluated when condition is truthy i.e. not null and not false
 // can design this to have consequence evaluated when condition is strictly true as well
-func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
-    condition := Eval(ie.Condition, env)
+func (e *evaluator) evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
+    condition := e.eval(ie.Condition, env)
     if isError(condition) {
         return condition
     }
 
     if isTruthy(condition) {
-        return Eval(ie.Consequence, env)
+        return e.eval(ie.Consequence, env)
     } else if (ie.Alternative != nil) {
-        return Eval(ie.Alternative, env)
+        return e.eval(ie.Alternative, env)
     } else {
         return NULL
     }
 }
 
-func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
+// errors raised inside the try block are handed to the first catch block that
+// accepts their kind as a hash (see errorToHash). If none does, the error
+// keeps propagating. The finally block runs unless a limit was exceeded, and
+// only replaces the result of the expression if it errors or returns itself
+func (e *evaluator) evalTryExpression(te *ast.TryExpression, env *object.Environment) object.Object {
+    result := e.eval(te.Block, env)
+
+    if errObj, ok := result.(*object.Error); ok {
+        if clause := matchingCatch(te.Catches, errObj); clause != nil {
+            // the error is only visible inside the catch block. In a frame
+            // the resolver gave the block its own slots instead
+            catchEnv := env
+            if !env.IsFrame() {
+                catchEnv = object.NewEnclosedEnvironment(env)
+            }
+
+            if clause.Parameter != nil && clause.Parameter.Local {
+                catchEnv.SetSlot(clause.Parameter.Slot, errorToHash(errObj))
+            } else if clause.Parameter != nil {
+                catchEnv.Set(clause.Parameter.Value, errorToHash(errObj))
+            }
+            result = e.eval(clause.Body, catchEnv)
+        }
+    }
+
+    // a finally block returning a value must not hide an exceeded limit
+    if e.Stopped() != nil {
+        return e.Stopped()
+    }
+
+    if te.Finally != nil {
+        finally := e.eval(te.Finally, env)
+        if finally != nil && (finally.Type() == object.RETURN_VALUE_OBJ || finally.Type() == object.ERROR_OBJ) {
+            return finally
+        }
+    }
+
+    return result
+}
+
+func matchingCatch(catches []*ast.CatchClause, errObj *object.Error) *ast.CatchClause {
+    for _, clause := range catches {
+        kind := ""
+        if clause.Kind != nil {
+            kind = clause.Kind.Value
+        }
+
+        if Catches(kind, errObj) {
+            return clause
+        }
+    }
+    return nil
+}
+
+func (e *evaluator) evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
     var result object.Object
 
     for _, statement := range block.Statements {
-        result = Eval(statement, env)
+        result = e.eval(statement, env)
 
         if result != nil && (result.Type() == object.RETURN_VALUE_OBJ || result.Type() == object.ERROR_OBJ) {
             return result
@@ -282,6 +537,13 @@ func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) obje
 }
 
 func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
+    if node.Local {
+        if val, ok := env.GetSlot(node.Depth, node.Slot); ok {
+            return val
+        }
+        return newError(object.NameError, "identifier not found: %s", node.Value)
+    }
+
     if val, ok := env.Get(node.Value); ok {
         return val
     }
@@ -290,40 +552,40 @@ func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object
         return builtin
     }
 
-    return newError("identifier not found: " + node.Value)
+    return newError(object.NameError, "identifier not found: %s", node.Value)
 }
 
-func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
-    pairs := make(map[object.HashKey]object.HashPair)
+func (e *evaluator) evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
+    hash := object.NewHash()
 
-    for keyNode, valueNode := range node.Pairs {
-        key := Eval(keyNode, env)
+    // pairs are evaluated left to right, keys before their values
+    for _, pair := range node.Pairs {
+        key := e.eval(pair.Key, env)
         if isError(key) {
             return key
         }
 
-        hashKey, ok := key.(object.Hashable)
+        hashKey, ok := object.AsHashable(key)
         if !ok {
-            return newError("unusable as hash key: %s", key.Type())
+            return newError(object.TypeError, "unusable as hash key: %s", key.Type())
         }
 
-        value := Eval(valueNode, env)
+        value := e.eval(pair.Value, env)
         if isError(value) {
             return value
         }
 
-        hashed := hashKey.HashKey()
-        pairs[hashed] = object.HashPair{Key: key, Value: value}
+        hash.Set(hashKey, value)
     }
 
-    return &object.Hash{Pairs: pairs}
+    return hash
 }
 
-func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
+func (e *evaluator) evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
     var result []object.Object
 
     for _, exp := range exps {
-        evaluated := Eval(exp, env)
+        evaluated := e.eval(exp, env)
         if isError(evaluated) {
             return []object.Object{evaluated}
         }
@@ -340,7 +602,7 @@ func evalIndexExpression(left, index object.Object) object.Object {
     case left.Type() == object.HASH_OBJ:
         return evalHashIndexExpression(left, index)
     default:
-        return newError("index operator not supported: %s", left.Type())
+        return newError(object.TypeError, "index operator not supported: %s", left.Type())
     }
 }
 
@@ -360,34 +622,77 @@ func evalArrayIndexExpression(array, index object.Object) object.Object {
 func evalHashIndexExpression(hash, index object.Object) object.Object {
     hashObject := hash.(*object.Hash)
 
-    key, ok := index.(object.Hashable)
+    key, ok := object.AsHashable(index)
     if !ok {
-        return newError("unusable as hash key: %s", index.Type())
+        return newError(object.TypeError, "unusable as hash key: %s", index.Type())
     }
 
-    pair, ok := hashObject.Pairs[key.HashKey()]
+    value, ok := hashObject.Get(key)
     if !ok {
         return NULL
     }
 
-    return pair.Value
+    return value
+}
+
+// applyFunction works as a trampoline: calls in tail position come back as a
+// tailCall and are made here in a loop, instead of growing the Go stack
+func (e *evaluator) applyFunction(fn object.Object, args []object.Object) object.Object {
+    for {
+        result := e.call(fn, args)
+
+        next, ok := result.(*tailCall)
+        if !ok {
+            return result
+        }
+        fn, args = next.function, next.args
+    }
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+func (e *evaluator) call(fn object.Object, args []object.Object) object.Object {
     switch function := fn.(type) {
     case *object.Function:
+        if len(args) != len(function.Parameters) {
+            return newError(object.ArityError, "wrong number of arguments. got=%d, want=%d", len(args), len(function.Parameters))
+        }
+
+        if e.depth >= e.MaxDepth() {
+            return newError(object.RecursionError, "maximum recursion depth exceeded")
+        }
+
         extendedEnv := extendFunctionEnv(function, args)
-        evaluated := Eval(function.Body, extendedEnv)
+        e.depth++
+        evaluated := e.eval(function.Body, extendedEnv)
+        e.depth--
+        if errObj, ok := evaluated.(*object.Error); ok {
+            errObj.Stack = append(errObj.Stack, functionName(function))
+        }
         return unwrapReturnValue(evaluated)
 
     case *object.BuiltIn:
-        return function.Fn(args...)
+        return e.Allocate(function.Fn(args...))
 
-        default:return newError("not a function: %s", fn.Type())
+    default:
+        return newError(object.TypeError, "not a function: %s", fn.Type())
     }
 }
 
+func functionName(fn *object.Function) string {
+    if fn.Name == "" {
+        return "<anonymous>"
+    }
+    return fn.Name
+}
+
 func extendFunctionEnv(fn *object.Function, args []object.Object) *object.Environment {
+    if fn.Resolved {
+        frame := object.NewFrame(fn.Env, fn.Slots)
+        for i, arg := range args {
+            frame.SetSlot(i, arg)
+        }
+        return frame
+    }
+
     env := object.NewEnclosedEnvironment(fn.Env)
 
     for paramIdx, param := range fn.Parameters {
@@ -397,10 +702,15 @@ func extendFunctionEnv(fn *object.Function, args []object.Object) *object.Enviro
     return env
 }
 
+// unwrapReturnValue returns the result of a call. A body without statements
+// evaluates to nil, which calls turn into null.
 func unwrapReturnValue(obj object.Object) object.Object {
     if returnValue, ok := obj.(*object.ReturnValue); ok {
         return returnValue.Value
     }
+    if obj == nil {
+        return NULL
+    }
 
     return obj
 }
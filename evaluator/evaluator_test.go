@@ -1,21 +1,67 @@
 package evaluator
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"math"
+	"monkey/enginetest"
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
+	"monkey/resolver"
+	"strings"
 	"testing"
+	"time"
 )
 
+// testEval resolves the program first, as the interpreter and the REPL do.
+// testEvalUnresolved keeps every variable in maps.
 func testEval(input string) object.Object {
     l := lexer.New(input)
     p := parser.New(l)
     program := p.ParseProgram()
     env := object.NewEnvironment()
+    resolver.Resolve(program, env)
 
     return Eval(program, env)
 }
 
+func testEvalUnresolved(input string) object.Object {
+    program := parser.New(lexer.New(input)).ParseProgram()
+    return Eval(program, object.NewEnvironment())
+}
+
+// testObjectEquals compares with structural equality so that whole arrays
+// and hashes can be checked in one go. Equal treats 1 and 1.0 alike, so the
+// types and printed forms have to match as well.
+func testObjectEquals(t *testing.T, obj object.Object, expected object.Object) bool {
+    if obj == nil || !obj.Equals(expected) || obj.Type() != expected.Type() || obj.Inspect() != expected.Inspect() {
+        t.Errorf("object is not %s %s. Got %T (%+v)", expected.Type(), expected.Inspect(), obj, obj)
+        return false
+    }
+    return true
+}
+
+// testValue is testObjectEquals for expectations written as Go values, see
+// object.FromGo. Errors and other objects can be given as they are.
+func testValue(t *testing.T, input string, obj object.Object, expected interface{}) bool {
+    want, err := object.FromGo(expected)
+    if err != nil {
+        t.Fatalf("bad expectation for %q: %s", input, err)
+    }
+
+    if !testObjectEquals(t, obj, want) {
+        t.Errorf("... for input %q", input)
+        return false
+    }
+    return true
+}
+
+func typeError(message string) *object.Error {
+    return &object.Error{Kind: object.TypeError, Message: message}
+}
+
 func testNullObject(t *testing.T, obj object.Object) bool {
     if obj != NULL {
         t.Errorf("object is not NULL. Got %T (%+v)", obj, obj)
@@ -24,6 +70,10 @@ func testNullObject(t *testing.T, obj object.Object) bool {
     return true
 }
 
+func TestCorpus(t *testing.T) {
+    enginetest.Run(t, testEval)
+}
+
 func TestEvalIntegerExpression(t *testing.T) {
     tests := []struct {
         input string
@@ -91,6 +141,19 @@ func TestEvalBooleanExpression(t *testing.T) {
         {"(1 < 2) == false", false},
         {"(1 > 2) == true", false},
         {"(1 > 2) == false", true},
+        {`"a" == "a"`, true},
+        {`"a" == "b"`, false},
+        {`"a" != "b"`, true},
+        {`"a" == 1`, false},
+        {"[1, 2] == [1, 2]", true},
+        {"[1, 2] == [2, 1]", false},
+        {"[1, [2, 3]] != [1, [2, 3]]", false},
+        {`{"a": 1, "b": 2} == {"b": 2, "a": 1}`, true},
+        {`{"a": 1} == {"a": 2}`, false},
+        {"let f = fn(x) { x }; f == f", true},
+        {"fn(x) { x } == fn(x) { x }", false},
+        {"let make = fn() { fn() { 1 } }; make() == make()", false},
+        {"len == len", true},
     }
 
     for _, tt := range tests {
@@ -230,6 +293,14 @@ func TestErrorHandling(t *testing.T) {
             `{"name": "Monkey"}[fn(x) { x }];`,
             "unusable as hash key: FUNCTION",
         },
+        {
+            `{[1, fn(x) { x }]: 1}`,
+            "unusable as hash key: ARRAY",
+        },
+        {
+            `{{"a": 1}: 1}`,
+            "unusable as hash key: HASH",
+        },
     }
 
     for i, tt := range tests {
@@ -302,6 +373,24 @@ func TestFunctionApplication(t *testing.T) {
     }
 }
 
+// a body without statements gives null, not nothing
+func TestEmptyFunctionBody(t *testing.T) {
+    tests := []struct {
+        input string
+        expected interface{}
+    }{
+        {"fn(){}()", nil},
+        {"let q = fn(){ }; [q()]", []interface{}{nil}},
+        {"let q = fn(){ }; type([q()][0])", "NULL"},
+        {"is_null(fn(){}())", true},
+        {"str(fn(x){}(1))", "null"},
+    }
+
+    for _, tt := range tests {
+        testValue(t, tt.input, testEval(tt.input), tt.expected)
+    }
+}
+
 func TestClosures(t *testing.T) {
     input := `
     let newAdder = fn(x) {
@@ -352,6 +441,8 @@ func TestBuiltinFunctions(t *testing.T) {
         {`len("hello world")`, 11},
         {`len(1)`, "argument to `len` not supported, got INTEGER"},
         {`len("one", "two")`, "wrong number of arguments. got=2, want=1"},
+        {`len({})`, 0},
+        {`len({"a": 1, "b": 2})`, 2},
     }
 
     for _, tt := range tests {
@@ -383,13 +474,11 @@ func TestArrayLiterals(t *testing.T) {
         t.Fatalf("object is not Array. got=%T (%+v)", evaluated, evaluated)
     }
 
-    if len(result.Elements) != 3 {
-        t.Fatalf("array has wrong num of elements. got=%d", len(result.Elements))
-    }
-    
-    testIntegerObject(t, result.Elements[0], 1)
-    testIntegerObject(t, result.Elements[1], 4)
-    testIntegerObject(t, result.Elements[2], 6)
+    testObjectEquals(t, result, &object.Array{Elements: []object.Object{
+        &object.Integer{Value: 1},
+        &object.Integer{Value: 4},
+        &object.Integer{Value: 6},
+    }})
 }
 
 func TestArrayIndexExpressions(t *testing.T) { 
@@ -467,25 +556,49 @@ func TestHashLiterals(t *testing.T) {
         t.Fatalf("Eval didn't return Hash. got=%T (%+v)", evaluated, evaluated)
     }
 
-    expected := map[object.HashKey]int64{ 
-        (&object.String{Value: "one"}).HashKey(): 1, 
-        (&object.String{Value: "two"}).HashKey(): 2, 
-        (&object.String{Value: "three"}).HashKey(): 3, 
-        (&object.Integer{Value: 4}).HashKey(): 4,
-        TRUE.HashKey(): 5, 
-        FALSE.HashKey(): 6,
+    expected := []struct {
+        key object.Hashable
+        value int64
+    }{
+        {&object.String{Value: "one"}, 1},
+        {&object.String{Value: "two"}, 2},
+        {&object.String{Value: "three"}, 3},
+        {&object.Integer{Value: 4}, 4},
+        {TRUE, 5},
+        {FALSE, 6},
     }
 
-    if len(result.Pairs) != len(expected) {
-        t.Fatalf("Hash has wrong num of pairs. got=%d", len(result.Pairs))
+    if result.Len() != len(expected) {
+        t.Fatalf("Hash has wrong num of pairs. got=%d", result.Len())
     }
 
-    for expectedKey, expectedValue := range expected {
-        pair, ok := result.Pairs[expectedKey]
+    for i, pair := range result.OrderedPairs() {
+        if pair.Key.Inspect() != expected[i].key.Inspect() {
+            t.Errorf("pair %d out of order. expected key %s, got %s", i, expected[i].key.Inspect(), pair.Key.Inspect())
+        }
+
+        value, ok := result.Get(expected[i].key)
         if !ok {
             t.Errorf("no pair for given key in Pairs")
+            continue
         }
-        testIntegerObject(t, pair.Value, expectedValue)
+        testIntegerObject(t, value, expected[i].value)
+    }
+}
+
+func TestHashLiteralEvaluationOrder(t *testing.T) {
+    tests := []struct {
+        input string
+        expected interface{}
+    }{
+        {`{"b": 1, "a": 2, "b": 3}`, enginetest.HashOf("b", 3, "a", 2)},
+        {`{"a": one, two: 2}`, enginetest.NameError("one")},
+        {`{one: 1, "b": two}`, enginetest.NameError("one")},
+        {`{"a": 1, two: three}`, enginetest.NameError("two")},
+    }
+
+    for _, tt := range tests {
+        testValue(t, tt.input, testEval(tt.input), tt.expected)
     }
 }
 
@@ -522,6 +635,18 @@ func TestHashIndexExpressions(t *testing.T) {
             `{false: 5}[false]`,
             5,
         },
+        {
+            `{[1, "a"]: 5}[[1, "a"]]`,
+            5,
+        },
+        {
+            `{[1, "a"]: 5}[["a", 1]]`,
+            nil,
+        },
+        {
+            `{freeze({"a": 1}): 5}[freeze({"a": 1})]`,
+            5,
+        },
     }
 
     for _, tt := range tests {
@@ -534,3 +659,564 @@ func TestHashIndexExpressions(t *testing.T) {
         }
     }
 }
+
+func TestHashBuiltins(t *testing.T) {
+    tests := []struct {
+        input string
+        expected interface{}
+    }{
+        {`keys({"b": 2, "a": 1, 3: 3, true: 4})`, []interface{}{"b", "a", 3, true}},
+        {`values({"b": 2, "a": 1})`, []int{2, 1}},
+        {`entries({"b": 2, "a": 1})`, []interface{}{[]interface{}{"b", 2}, []interface{}{"a", 1}}},
+        {`entries({})`, []interface{}{}},
+        {`has({"a": 1}, "a")`, true},
+        {`has({"a": 1}, "b")`, false},
+        {`has({1: 1}, 1)`, true},
+        {`merge({"a": 1, "b": 2}, {"b": 3, "c": 4})`, enginetest.HashOf("a", 1, "b", 3, "c", 4)},
+        {`merge({"a": 1}, {"b": 2}, {"a": 3})`, enginetest.HashOf("a", 3, "b", 2)},
+        {`delete({"a": 1, "b": 2}, "a")`, enginetest.HashOf("b", 2)},
+        {`delete({"a": 1}, "z")`, enginetest.HashOf("a", 1)},
+        {`let h = {"a": 1}; delete(h, "a"); h`, enginetest.HashOf("a", 1)},
+        {`keys([1])`, typeError("argument to `keys` must be HASH, got ARRAY")},
+        {`has({}, fn(x) { x })`, typeError("unusable as hash key: FUNCTION")},
+        {`merge({})`, &object.Error{Kind: object.ArityError, Message: "wrong number of arguments. got=1, want at least 2"}},
+        {`merge({}, 1)`, typeError("argument to `merge` must be HASH, got INTEGER")},
+        {`has({[1, 2]: true}, [1, 2])`, true},
+        {`delete({[1]: 1, [2]: 2}, [1])`, enginetest.HashOf([]int{2}, 2)},
+        {`freeze({"a": 1})`, enginetest.HashOf("a", 1)},
+        {`freeze(1)`, typeError("argument to `freeze` must be HASH, got INTEGER")},
+    }
+
+    for _, tt := range tests {
+        testValue(t, tt.input, testEval(tt.input), tt.expected)
+    }
+}
+
+func TestSets(t *testing.T) {
+    tests := []struct {
+        input string
+        expected interface{}
+    }{
+        {`set()`, enginetest.SetOf()},
+        {`set([3, 1, 3, "a", 1])`, enginetest.SetOf(3, 1, "a")},
+        {`len(set([1, 1, 2]))`, 2},
+        {`has(set([1, 2]), 2)`, true},
+        {`has(set([1, 2]), 3)`, false},
+        {`has(set([[1, 2]]), [1, 2])`, true},
+        {`has(set([1]), fn(x) { x })`, false},
+        {`add(set([1]), 2)`, enginetest.SetOf(1, 2)},
+        {`add(set([1]), 1)`, enginetest.SetOf(1)},
+        {`let s = set([1]); add(s, 2); s`, enginetest.SetOf(1)},
+        {`remove(set([1, 2, 3]), 2)`, enginetest.SetOf(1, 3)},
+        {`remove(set([1]), 5)`, enginetest.SetOf(1)},
+        {`union(set([1, 2]), set([2, 3]))`, enginetest.SetOf(1, 2, 3)},
+        {`intersection(set([1, 2, 3]), set([3, 2, 5]))`, enginetest.SetOf(2, 3)},
+        {`difference(set([1, 2, 3]), set([2]))`, enginetest.SetOf(1, 3)},
+        {`values(set([2, 1]))`, []int{2, 1}},
+        {`set([1, 2]) == set([2, 1])`, true},
+        {`set([1, 2]) == set([1])`, false},
+        {`set(1)`, typeError("argument to `set` must be ARRAY, got INTEGER")},
+        {`set([fn(x) { x }])`, typeError("unusable as set element: FUNCTION")},
+        {`add(set(), {})`, typeError("unusable as set element: HASH")},
+        {`union(set(), [1])`, typeError("argument to `union` must be SET, got ARRAY")},
+        {`has([1], 1)`, typeError("argument to `has` must be HASH or SET, got ARRAY")},
+    }
+
+    for _, tt := range tests {
+        testValue(t, tt.input, testEval(tt.input), tt.expected)
+    }
+}
+
+func TestFloatExpressions(t *testing.T) {
+    tests := []struct {
+        input string
+        expected interface{}
+    }{
+        {"1.5", 1.5},
+        {"-1.5", -1.5},
+        {"1.5 + 1.5", 3.0},
+        {"1 + 0.5", 1.5},
+        {"10 / 4.0", 2.5},
+        {"2.5 * 2", 5.0},
+        {"10 / 4", 2},
+        {"1.0 / 0", math.Inf(1)},
+        {"1.5 < 2", true},
+        {"1 == 1.0", true},
+        {"[1, 2] == [1.0, 2.0]", true},
+        {"2.5 > 3", false},
+        // whole floats are the same hash key as the equal integer
+        {"{1: \"a\"}[1.0]", "a"},
+        {"{1.5: \"a\"}[1.5]", "a"},
+        {"len(set([1, 1.0, 2.5, 2.5]))", 2},
+        {"has(set([2]), 2.0)", true},
+    }
+
+    for _, tt := range tests {
+        testValue(t, tt.input, testEval(tt.input), tt.expected)
+    }
+}
+
+func TestTypeConversionBuiltins(t *testing.T) {
+    tests := []struct {
+        input string
+        expected interface{}
+    }{
+        {`type(1)`, "INTEGER"},
+        {`type(1.5)`, "FLOAT"},
+        {`type("a")`, "STRING"},
+        {`type(true)`, "BOOLEAN"},
+        {`type(null)`, "NULL"},
+        {`type([])`, "ARRAY"},
+        {`type({})`, "HASH"},
+        {`type(set())`, "SET"},
+        {`type(fn() {})`, "FUNCTION"},
+        {`type(len)`, "BUILTIN"},
+        {`type(1) == "INTEGER"`, true},
+        {`int("42")`, 42},
+        {`int(" -7 ")`, -7},
+        {`int(3.9)`, 3},
+        {`int(-3.9)`, -3},
+        {`int(true)`, 1},
+        {`int("abc")`, &object.Error{Kind: object.ValueError, Message: `could not parse "abc" as integer`}},
+        {`int([])`, &object.Error{Kind: object.TypeError, Message: "argument to `int` not supported, got ARRAY"}},
+        {`float(2)`, 2.0},
+        {`float("2.5")`, 2.5},
+        {`float("x")`, &object.Error{Kind: object.ValueError, Message: `could not parse "x" as float`}},
+        {`str(12) + str(true)`, "12true"},
+        {`str([1, "a"])`, "[1, a]"},
+        {`str("a")`, "a"},
+        {`bool(0)`, true},
+        {`bool(null)`, false},
+        {`bool(false)`, false},
+        {`is_null(null)`, true},
+        {`is_null([1][5])`, true},
+        {`is_null(0)`, false},
+        {`null`, nil},
+        {`null == null`, true},
+        {`[1][5] == null`, true},
+        {`null == false`, false},
+        {`if (null) { 1 } else { 2 }`, 2},
+    }
+
+    for _, tt := range tests {
+        testValue(t, tt.input, testEval(tt.input), tt.expected)
+    }
+}
+
+func TestTryCatchFinally(t *testing.T) {
+    tests := []struct {
+        input string
+        expected interface{}
+    }{
+        {`try { 1 } catch (e) { 2 }`, 1},
+        {`try { throw "oops"; 1 } catch (e) { e["message"] }`, "oops"},
+        {`try { throw "oops" } catch (e) { e["kind"] }`, "UserError"},
+        {`try { 1 + true } catch (e) { e["message"] }`, "type mismatch: INTEGER + BOOLEAN"},
+        {`try { 1 + true } catch (e) { e["kind"] }`, "TypeError"},
+        {`try { throw [1, 2] } catch (e) { e["value"] }`, []int{1, 2}},
+        {`try { throw [1, 2] } catch (e) { e["message"] }`, "[1, 2]"},
+        {`try { foo } catch { "recovered" }`, "recovered"},
+        {`try { foo } catch (e) { e["message"] }`, "identifier not found: foo"},
+        {`throw "uncaught"; 1`, &object.Error{Kind: object.UserError, Message: "uncaught"}},
+        {`try { throw "a" } catch (e) { throw "b" }`, &object.Error{Kind: object.UserError, Message: "b"}},
+        {`try { throw "a" } catch (e) { throw e }`, &object.Error{Kind: object.UserError, Message: "a"}},
+        {`try { try { throw "inner" } catch (e) { throw e } } catch (e) { e["message"] }`, "inner"},
+        {`let x = 1; try { 2 } finally { let x = 5 }; x`, 5},
+        {`try { 2 } finally { 3 }`, 2},
+        {`try { throw "a" } finally { 3 }`, &object.Error{Kind: object.UserError, Message: "a"}},
+        {`try { throw "a" } catch (e) { 1 } finally { throw "f" }`, &object.Error{Kind: object.UserError, Message: "f"}},
+        {`let f = fn() { try { return 1 } finally { 2 }; 3 }; f()`, 1},
+        {`let f = fn() { try { return 1 } finally { return 2 } }; f()`, 2},
+        {`let f = fn() { try { throw "x" } catch (e) { return 7 }; 3 }; f()`, 7},
+        {`let parse = fn(s) { try { int(s) } catch (e) { -1 } }; [parse("1"), parse("x")]`, []int{1, -1}},
+        // the catch parameter doesn't leak into or clobber the enclosing scope
+        {`let e = 5; try { throw "x" } catch (e) { 1 }; e`, 5},
+        {`try { throw "x" } catch (err) { 1 }; err`, enginetest.NameError("err")},
+        {`let f = fn(e) { try { throw "x" } catch (e) { e["message"] } }; [f(1), f(2)]`, []string{"x", "x"}},
+    }
+
+    for _, tt := range tests {
+        testValue(t, tt.input, testEval(tt.input), tt.expected)
+    }
+}
+
+func TestErrorStack(t *testing.T) {
+    input := `
+    let inner = fn() { throw "deep" };
+    let outer = fn() { let r = inner(); r };
+    try { fn() { let r = outer(); r }() } catch (e) { e["stack"] }`
+
+    evaluated := testEval(input)
+    if evaluated.Inspect() != "[inner, outer, <anonymous>]" {
+        t.Errorf("wrong stack. got=%s", evaluated.Inspect())
+    }
+
+    // functions that ended in a tail call are gone from the stack
+    tail := testEval(`
+    let inner = fn() { throw "deep" };
+    let outer = fn() { inner() };
+    try { fn() { outer() }() } catch (e) { e["stack"] }`)
+    if tail.Inspect() != "[inner]" {
+        t.Errorf("wrong stack after tail calls. got=%s", tail.Inspect())
+    }
+
+    uncaught := testEval(`let f = fn() { 1 + true }; f()`)
+    errObj, ok := uncaught.(*object.Error)
+    if !ok {
+        t.Fatalf("object is not Error. got=%T (%+v)", uncaught, uncaught)
+    }
+    if len(errObj.Stack) != 1 || errObj.Stack[0] != "f" {
+        t.Errorf("wrong stack. got=%v", errObj.Stack)
+    }
+}
+
+func TestErrorKinds(t *testing.T) {
+    tests := []struct {
+        input string
+        kind object.ErrorKind
+        message string
+    }{
+        {"5 + true", object.TypeError, "type mismatch: INTEGER + BOOLEAN"},
+        {"-true", object.TypeError, "unknown operator: -BOOLEAN"},
+        {"foobar", object.NameError, "identifier not found: foobar"},
+        {"len(1, 2)", object.ArityError, "wrong number of arguments. got=2, want=1"},
+        {"fn(x) { x }()", object.ArityError, "wrong number of arguments. got=0, want=1"},
+        {"fn(x) { x }(1, 2)", object.ArityError, "wrong number of arguments. got=2, want=1"},
+        {"1 / 0", object.ZeroDivisionError, "division by zero: 1 / 0"},
+        {`int("abc")`, object.ValueError, `could not parse "abc" as integer`},
+        {"5()", object.TypeError, "not a function: INTEGER"},
+        {`throw "oops"`, object.UserError, "oops"},
+        {`throw {"kind": "ValueError", "message": "too far"}`, object.ValueError, "too far"},
+        {`throw {"kind": "Validation", "message": "bad"}`, object.UserError, "bad"},
+        {`throw {"kind": "LimitError", "message": "fake"}`, object.UserError, "fake"},
+    }
+
+    for _, tt := range tests {
+        evaluated := testEval(tt.input)
+
+        errObj, ok := evaluated.(*object.Error)
+        if !ok {
+            t.Errorf("no error object returned for %q. Got %T (%+v)", tt.input, evaluated, evaluated)
+            continue
+        }
+
+        if errObj.Kind != tt.kind {
+            t.Errorf("wrong kind for %q. expected=%s, got=%s", tt.input, tt.kind, errObj.Kind)
+        }
+
+        if errObj.Message != tt.message {
+            t.Errorf("wrong message for %q. expected=%q, got=%q", tt.input, tt.message, errObj.Message)
+        }
+
+        if !errors.Is(errObj, tt.kind) || !object.IsErrorKind(errObj, tt.kind) {
+            t.Errorf("error for %q does not match its kind %s", tt.input, tt.kind)
+        }
+
+        if errors.Is(errObj, object.RuntimeError) {
+            t.Errorf("error for %q matches RuntimeError", tt.input)
+        }
+    }
+}
+
+func TestCatchByKind(t *testing.T) {
+    tests := []struct {
+        input string
+        expected interface{}
+    }{
+        {`try { 1 / 0 } catch (ZeroDivisionError e) { "div" } catch (e) { "other" }`, "div"},
+        {`try { foo } catch (ZeroDivisionError e) { "div" } catch (e) { "other" }`, "other"},
+        {`try { foo } catch (TypeError e) { "type" } catch (NameError e) { e["kind"] }`, "NameError"},
+        {`try { foo } catch (TypeError e) { "type" }`, enginetest.NameError("foo")},
+        {`try { throw "x" } catch (UserError e) { e["message"] }`, "x"},
+        {`try { throw {"kind": "NameError", "message": "m"} } catch (NameError e) { e["message"] }`, "m"},
+        {`try { throw {"message": "bad", "kind": "Validation", "id": 7} } catch (UserError e) { [e["message"], e["value"]["kind"], e["value"]["id"]] }`, []interface{}{"bad", "Validation", 7}},
+        // the thrown hash is kept whole, extra keys included
+        {`try { throw {"message": "bad", "kind": "ValueError", "id": 7} } catch (ValueError e) { e["value"]["id"] }`, 7},
+        // rethrowing a caught error keeps its value, but a copy of it is a plain hash
+        {`try { try { throw {"message": "bad", "kind": "ValueError", "id": 7} } catch (e) { throw e } } catch (ValueError e) { e["value"]["id"] }`, 7},
+        {`try { try { throw "x" } catch (e) { throw merge(e, {"id": 1}) } } catch (e) { [e["kind"], e["message"], e["value"]["id"]] }`, []interface{}{"UserError", "x", 1}},
+        {`let r = 0; try { try { foo } catch (TypeError e) { 1 } finally { let r = 9 } } catch (e) { r }`, 9},
+    }
+
+    for _, tt := range tests {
+        testValue(t, tt.input, testEval(tt.input), tt.expected)
+    }
+}
+
+func TestIOBuiltins(t *testing.T) {
+    tests := []struct {
+        input string
+        stdin string
+        stdout string
+        stderr string
+    }{
+        {`puts(1, "two", [3])`, "", "1\ntwo\n[3]\n", ""},
+        {`print(1, "two", [3])`, "", "1 two [3]\n", ""},
+        {`print()`, "", "\n", ""},
+        {`eprint("oops", 1)`, "", "", "oops 1\n"},
+        {`print(input("> "))`, "line\r\nnext\n", "> line\n", ""},
+        {`print(input(), input(), input())`, "a\nb", "a b null\n", ""},
+    }
+
+    for _, tt := range tests {
+        var stdout, stderr bytes.Buffer
+        stdio := NewIO(strings.NewReader(tt.stdin), &stdout, &stderr)
+
+        builtins := object.NewEnvironment()
+        for name, builtin := range NewBuiltins(stdio) {
+            builtins.Set(name, builtin)
+        }
+
+        program := parser.New(lexer.New(tt.input)).ParseProgram()
+        Eval(program, object.NewEnclosedEnvironment(builtins))
+
+        if stdout.String() != tt.stdout {
+            t.Errorf("%s: wrong stdout. expected=%q, got=%q", tt.input, tt.stdout, stdout.String())
+        }
+        if stderr.String() != tt.stderr {
+            t.Errorf("%s: wrong stderr. expected=%q, got=%q", tt.input, tt.stderr, stderr.String())
+        }
+    }
+
+    errObj, ok := testEval(`input(1, 2)`).(*object.Error)
+    if !ok || errObj.Kind != object.ArityError {
+        t.Errorf("expected ArityError from input(1, 2). got=%v", errObj)
+    }
+}
+
+func testEvalWithLimits(ctx context.Context, input string, limits Limits) object.Object {
+    program := parser.New(lexer.New(input)).ParseProgram()
+    return EvalContext(ctx, program, object.NewEnvironment(), limits)
+}
+
+func TestStepBudget(t *testing.T) {
+    countdown := `
+    let countdown = fn(n) { if (n == 0) { "done" } else { countdown(n - 1) } };
+    countdown(50)
+    `
+
+    result := testEvalWithLimits(context.Background(), countdown, Limits{MaxSteps: 100000})
+    if !testObjectEquals(t, result, &object.String{Value: "done"}) {
+        return
+    }
+
+    tests := []string{
+        countdown,
+        // running out of steps cannot be caught
+        `try { ` + countdown + ` } catch (e) { "caught" }`,
+        `try { ` + countdown + ` } catch (LimitError e) { "caught" } finally { "finally" }`,
+        // nor can a finally block replace it by returning
+        `let f = fn() { try { ` + countdown + ` } finally { return "finally" } }; f()`,
+        `let f = fn() { try { ` + countdown + ` } catch (e) { 1 } finally { return "finally" } }; [f()]`,
+    }
+
+    for _, input := range tests {
+        errObj, ok := testEvalWithLimits(context.Background(), input, Limits{MaxSteps: 100}).(*object.Error)
+        if !ok {
+            t.Errorf("%s: expected an error", input)
+            continue
+        }
+
+        if errObj.Kind != object.LimitError || errObj.Message != "step budget of 100 exceeded" {
+            t.Errorf("wrong error. got=%s", errObj.Inspect())
+        }
+    }
+}
+
+func TestContextCancellation(t *testing.T) {
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+
+    errObj, ok := testEvalWithLimits(ctx, "1 + 1", Limits{}).(*object.Error)
+    if !ok || errObj.Kind != object.LimitError || errObj.Message != "evaluation stopped: context canceled" {
+        t.Fatalf("expected LimitError for a cancelled context. got=%v", errObj)
+    }
+
+    // once cancelled, no step succeeds, even in a finally block that returns
+    ctx, cancel = context.WithCancel(context.Background())
+    env := object.NewEnvironment()
+    env.Set("stop", &object.BuiltIn{Fn: func(args ...object.Object) object.Object {
+        cancel()
+        return NULL
+    }})
+
+    spin := `
+    let spin = fn(n) { if (n == 0) { 0 } else { spin(n - 1) } };
+    let f = fn() { try { stop(); spin(5000) } finally { return "finally" } };
+    f()
+    `
+    program := parser.New(lexer.New(spin)).ParseProgram()
+    errObj, ok = EvalContext(ctx, program, env, Limits{}).(*object.Error)
+    if !ok || errObj.Kind != object.LimitError || errObj.Message != "evaluation stopped: context canceled" {
+        t.Fatalf("expected LimitError despite the finally block. got=%v", errObj)
+    }
+
+    ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+    defer cancel()
+
+    // never finishes in time on its own
+    fib := `let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; fib(40)`
+    errObj, ok = testEvalWithLimits(ctx, fib, Limits{}).(*object.Error)
+    if !ok || errObj.Kind != object.LimitError || errObj.Message != "evaluation stopped: context deadline exceeded" {
+        t.Fatalf("expected LimitError after the deadline. got=%v", errObj)
+    }
+}
+
+func TestRecursionDepth(t *testing.T) {
+    tests := []struct {
+        input string
+        limits Limits
+        expected object.Object
+    }{
+        {`let f = fn() { 1 + f() }; f()`, Limits{}, &object.Error{Kind: object.RecursionError, Message: "maximum recursion depth exceeded"}},
+        {`let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(100)`, Limits{MaxDepth: 100}, &object.Error{Kind: object.RecursionError, Message: "maximum recursion depth exceeded"}},
+        {`let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(99)`, Limits{MaxDepth: 100}, &object.Integer{Value: 99}},
+        // the stack unwinds before the catch block runs, so it can be caught
+        {`let f = fn() { 1 + f() }; try { f() } catch (RecursionError e) { e["kind"] }`, Limits{}, &object.String{Value: "RecursionError"}},
+        // tail calls don't nest, so they never hit the depth limit
+        {`let f = fn(n) { if (n == 0) { "done" } else { f(n - 1) } }; f(1000)`, Limits{MaxDepth: 10}, &object.String{Value: "done"}},
+        {`let f = fn() { f() }; f()`, Limits{MaxSteps: 10000}, &object.Error{Kind: object.LimitError, Message: "step budget of 10000 exceeded"}},
+    }
+
+    for _, tt := range tests {
+        result := testEvalWithLimits(context.Background(), tt.input, tt.limits)
+        if !result.Equals(tt.expected) {
+            t.Errorf("%s: expected %s. got=%s", tt.input, tt.expected.Inspect(), result.Inspect())
+        }
+    }
+}
+
+func TestMemoryLimit(t *testing.T) {
+    grow := `
+    let grow = fn(arr, n) { if (n == 0) { len(arr) } else { grow(push(arr, n), n - 1) } };
+    grow([], 1000)
+    `
+
+    result := testEvalWithLimits(context.Background(), grow, Limits{})
+    if !testObjectEquals(t, result, &object.Integer{Value: 1000}) {
+        return
+    }
+
+    tests := []string{
+        grow,
+        `let s = "abcdefghij"; let double = fn(s, n) { if (n == 0) { s } else { double(s + s, n - 1) } }; double(s, 20)`,
+        `let f = fn(n) { if (n == 0) { {} } else { {n: f(n - 1), "pad": [n, n, n]} } }; try { f(1000) } catch (e) { 0 }`,
+        // returning from finally allocates nothing, but must not hide the error
+        `let f = fn() { try { ` + grow + ` } finally { return 1 } }; f()`,
+    }
+
+    for _, input := range tests {
+        errObj, ok := testEvalWithLimits(context.Background(), input, Limits{MaxMemory: 100000}).(*object.Error)
+        if !ok {
+            t.Errorf("%s: expected an error", input)
+            continue
+        }
+
+        if errObj.Kind != object.LimitError || errObj.Message != "memory limit of 100000 bytes exceeded" {
+            t.Errorf("wrong error. got=%s", errObj.Inspect())
+        }
+    }
+}
+
+func TestTailCalls(t *testing.T) {
+    tests := []struct {
+        input string
+        expected object.Object
+    }{
+        // a million iterations would overflow the default depth limit long
+        // before the Go stack if the calls nested
+        {`let loop = fn(n, acc) { if (n == 0) { acc } else { loop(n - 1, acc + 1) } }; loop(1000000, 0)`, &object.Integer{Value: 1000000}},
+        {`let loop = fn(n) { if (n == 0) { return "done"; } return loop(n - 1); }; loop(100000)`, &object.String{Value: "done"}},
+        {`let loop = fn(n) { if (n > 0) { return loop(n - 1); } "done" }; loop(100000)`, &object.String{Value: "done"}},
+        // mutual recursion through both branches of an if
+        {`
+        let even = fn(n) { if (n == 0) { true } else { odd(n - 1) } };
+        let odd = fn(n) { if (n == 0) { false } else { even(n - 1) } };
+        [even(100001), odd(100001)]
+        `, &object.Array{Elements: []object.Object{FALSE, TRUE}}},
+        {`let f = fn(n) { if (n == 0) { len("abc") } else { f(n - 1) } }; f(100000)`, &object.Integer{Value: 3}},
+    }
+
+    for _, tt := range tests {
+        testObjectEquals(t, testEval(tt.input), tt.expected)
+    }
+}
+
+func TestTailCallErrors(t *testing.T) {
+    tests := []struct {
+        input string
+        expected *object.Error
+    }{
+        {`let f = fn(n) { if (n == 0) { g(1, 2) } else { f(n - 1) } }; let g = fn(x) { x }; f(100000)`, &object.Error{Kind: object.ArityError, Message: "wrong number of arguments. got=2, want=1"}},
+        {`let f = fn(n) { if (n == 0) { 1() } else { f(n - 1) } }; f(100000)`, &object.Error{Kind: object.TypeError, Message: "not a function: INTEGER"}},
+        // calls inside try are not in tail position, so the catch still runs
+        {`let f = fn(n) { try { if (n == 0) { throw "bottom" } else { f(n - 1) } } catch (e) { throw e } }; f(10)`, &object.Error{Kind: object.UserError, Message: "bottom"}},
+    }
+
+    for _, tt := range tests {
+        testObjectEquals(t, testEval(tt.input), tt.expected)
+    }
+}
+
+func TestResolvedScoping(t *testing.T) {
+    tests := []struct {
+        input string
+        expected interface{}
+    }{
+        {`let f = fn(a) { let b = a * 2; fn(c) { a + b + c } }; f(1)(10)`, 13},
+        {`let x = 1; let f = fn() { let x = 2; x }; [f(), x]`, []int{2, 1}},
+        {`let f = fn(n) { if (n > 0) { let big = "yes" }; big }; f(1)`, "yes"},
+        {`let f = fn(n) { if (n > 0) { let big = "yes" }; big }; f(0)`, enginetest.NameError("big")},
+        // a closure may use a local declared after it, once it has been assigned
+        {`let f = fn() { let a = fn() { b }; let b = 7; a() }; f()`, 7},
+        {`let f = fn() { let a = fn() { b }; let b = 7; a }; f()()`, 7},
+        {`let counter = fn() { let n = 0; fn() { let n = n + 1; n } }; counter()()`, enginetest.NameError("n")},
+        {`let f = fn(e) { try { throw "x" } catch (e) { let msg = e["message"]; msg }; [e, msg] }; f(1)`, enginetest.NameError("msg")},
+        {`let f = fn(e) { let r = try { throw "x" } catch (e) { e["message"] }; [e, r] }; f(1)`, []interface{}{1, "x"}},
+        {`let f = fn(a, a) { a }; f(1, 2)`, 2},
+        {`let f = fn(x) { let x = x + 1; x }; f(1)`, 2},
+        {`let fact = fn(n) { if (n == 0) { 1 } else { n * fact(n - 1) } }; fact(10)`, 3628800},
+    }
+
+    for _, tt := range tests {
+        testValue(t, tt.input, testEval(tt.input), tt.expected)
+    }
+}
+
+// variables declared anywhere in a function are local to all of it, so
+// reading one before its let statement no longer falls back to a global
+func TestResolvedLocalsShadowGlobals(t *testing.T) {
+    input := `let x = 1; let f = fn() { let y = x; let x = 2; y }; f()`
+
+    testValue(t, input, testEvalUnresolved(input), 1)
+    testValue(t, input, testEval(input), enginetest.NameError("x"))
+}
+
+const benchmarkProgram = `
+let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } };
+let sum = fn(n, acc) { if (n == 0) { acc } else { let next = acc + n; sum(n - 1, next) } };
+let closures = fn(a, b) { let c = a + b; let f = fn(x) { x + a + b + c }; f(1) + f(2) };
+[fib(15), sum(2000, 0), closures(1, 2)]
+`
+
+func benchmarkEval(b *testing.B, resolve bool) {
+    for i := 0; i < b.N; i++ {
+        program := parser.New(lexer.New(benchmarkProgram)).ParseProgram()
+        env := object.NewEnvironment()
+        if resolve {
+            resolver.Resolve(program, env)
+        }
+
+        if result := Eval(program, env); isError(result) {
+            b.Fatal(result.Inspect())
+        }
+    }
+}
+
+func BenchmarkEvalMaps(b *testing.B) {
+    benchmarkEval(b, false)
+}
+
+func BenchmarkEvalResolved(b *testing.B) {
+    benchmarkEval(b, true)
+}
@@ -0,0 +1,100 @@
+package evaluator
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "monkey/object"
+    "os"
+    "strings"
+)
+
+// IO is where the builtins that do I/O (puts, print, eprint and input) read
+// from and write to. Each interpreter gets its own so that output can be
+// captured or routed per session.
+type IO struct {
+    Stdin *bufio.Reader
+    Stdout io.Writer
+    Stderr io.Writer
+}
+
+// NewIO wraps stdin in a bufio.Reader unless it already is one, so that
+// callers reading lines from the same reader don't lose buffered input
+func NewIO(stdin io.Reader, stdout, stderr io.Writer) *IO {
+    reader, ok := stdin.(*bufio.Reader)
+    if !ok {
+        reader = bufio.NewReader(stdin)
+    }
+
+    return &IO{
+        Stdin: reader,
+        Stdout: stdout,
+        Stderr: stderr,
+    }
+}
+
+// the package level builtins use the standard streams
+func init() {
+    for name, builtin := range NewIO(os.Stdin, os.Stdout, os.Stderr).builtins() {
+        builtins[name] = builtin
+    }
+}
+
+func (stdio *IO) builtins() map[string]*object.BuiltIn {
+    return map[string]*object.BuiltIn{
+        // puts writes each argument on its own line
+        "puts": &object.BuiltIn{
+            Fn: func(args ...object.Object) object.Object {
+                for _, arg := range args {
+                    fmt.Fprintln(stdio.Stdout, arg.Inspect())
+                }
+
+                return NULL
+            },
+        },
+        // print writes its arguments on one line, separated by spaces
+        "print": &object.BuiltIn{
+            Fn: func(args ...object.Object) object.Object {
+                fmt.Fprintln(stdio.Stdout, joinInspected(args))
+                return NULL
+            },
+        },
+        "eprint": &object.BuiltIn{
+            Fn: func(args ...object.Object) object.Object {
+                fmt.Fprintln(stdio.Stderr, joinInspected(args))
+                return NULL
+            },
+        },
+        // input reads a line without its line ending, after writing the
+        // optional prompt. It returns null once the input is exhausted
+        "input": &object.BuiltIn{
+            Fn: func(args ...object.Object) object.Object {
+                if len(args) > 1 {
+                    return newError(object.ArityError, "wrong number of arguments. got=%d, want=0 or 1", len(args))
+                }
+
+                if len(args) == 1 {
+                    fmt.Fprint(stdio.Stdout, args[0].Inspect())
+                }
+
+                line, err := stdio.Stdin.ReadString('\n')
+                if err != nil && line == "" {
+                    if err == io.EOF {
+                        return NULL
+                    }
+                    return newError(object.RuntimeError, "could not read input: %s", err)
+                }
+
+                return &object.String{Value: strings.TrimRight(line, "\r\n")}
+            },
+        },
+    }
+}
+
+func joinInspected(args []object.Object) string {
+    parts := make([]string, len(args))
+    for i, arg := range args {
+        parts[i] = arg.Inspect()
+    }
+    return strings.Join(parts, " ")
+}
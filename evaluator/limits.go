@@ -0,0 +1,113 @@
+package evaluator
+
+import (
+    "context"
+    "monkey/object"
+)
+
+// Limits bound the work a single evaluation may do. Zero fields mean no limit,
+// except for MaxDepth: running out of Go stack kills the whole process, so
+// calls are always limited to DefaultMaxDepth unless told otherwise.
+//
+// Calls in tail position replace their caller instead of nesting, so they do
+// not count towards MaxDepth. A script recursing forever through tail calls
+// is a plain infinite loop, which only MaxSteps or the context can stop.
+type Limits struct {
+    MaxSteps int64      // every node evaluated, or instruction run by the vm, counts as one step
+    MaxDepth int        // nested calls of Monkey functions, not counting tail calls
+    MaxMemory int64     // approximate bytes allocated for strings, arrays, hashes and sets
+}
+
+const DefaultMaxDepth = 10000
+
+// checking the context on every step would be wasteful, so it is only looked
+// at on the first step and then every contextCheckInterval steps
+const contextCheckInterval = 1024
+
+// Meter enforces Limits during one run of a script. The evaluator and the
+// bytecode vm share it, so that both engines stop scripts the same way.
+type Meter struct {
+    ctx context.Context
+    limits Limits
+    steps int64
+    memory int64        // bytes allocated so far, see Allocate
+    stopped *object.Error   // the LimitError once a limit has been exceeded
+}
+
+func NewMeter(ctx context.Context, limits Limits) *Meter {
+    return &Meter{ctx: ctx, limits: limits}
+}
+
+// Step counts one step and reports the LimitError once a limit has been
+// exceeded
+func (m *Meter) Step() *object.Error {
+    if m.stopped != nil {
+        return m.stopped
+    }
+
+    m.steps++
+
+    if m.limits.MaxSteps > 0 && m.steps > m.limits.MaxSteps {
+        return m.stop(newError(object.LimitError, "step budget of %d exceeded", m.limits.MaxSteps))
+    }
+
+    if (m.steps-1)%contextCheckInterval == 0 {
+        if err := m.ctx.Err(); err != nil {
+            return m.stop(newError(object.LimitError, "evaluation stopped: %s", err))
+        }
+    }
+
+    return nil
+}
+
+// stop records that a limit has been exceeded, so that every step after it
+// fails with the same error
+func (m *Meter) stop(err *object.Error) *object.Error {
+    m.stopped = err
+    return err
+}
+
+// Stopped returns the LimitError once a limit has been exceeded, nil before
+func (m *Meter) Stopped() *object.Error {
+    return m.stopped
+}
+
+func (m *Meter) MaxDepth() int {
+    if m.limits.MaxDepth > 0 {
+        return m.limits.MaxDepth
+    }
+    return DefaultMaxDepth
+}
+
+// Allocate charges the size of obj against the memory limit. The accounting
+// is approximate: every string, array, hash or set produced is counted once
+// when it is created, whether or not it is still in use, and containers only
+// count their own slots, not the elements they hold.
+func (m *Meter) Allocate(obj object.Object) object.Object {
+    if m.limits.MaxMemory <= 0 {
+        return obj
+    }
+
+    m.memory += sizeOf(obj)
+    if m.memory > m.limits.MaxMemory {
+        return m.stop(newError(object.LimitError, "memory limit of %d bytes exceeded", m.limits.MaxMemory))
+    }
+
+    return obj
+}
+
+// rough sizes in bytes of the Go values behind each object
+func sizeOf(obj object.Object) int64 {
+    switch obj := obj.(type) {
+    case *object.String:
+        return 16 + int64(len(obj.Value))
+    case *object.Array:
+        return 24 + 16*int64(len(obj.Elements))
+    case *object.Hash:
+        return 48 + 64*int64(obj.Len())
+    case *object.Set:
+        return 48 + 64*int64(obj.Len())
+    default:
+        return 0
+    }
+}
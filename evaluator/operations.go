@@ -0,0 +1,52 @@
+package evaluator
+
+import (
+    "monkey/object"
+)
+
+// The functions below give other engines, such as the bytecode vm, exactly
+// the semantics the evaluator has for operators, indexing, truthiness and
+// errors, so that scripts cannot tell which engine runs them.
+
+func PrefixOperation(operator string, right object.Object) object.Object {
+    return evalPrefixExpression(operator, right)
+}
+
+func InfixOperation(operator string, left, right object.Object) object.Object {
+    return evalInfixExpression(operator, left, right)
+}
+
+func IndexOperation(left, index object.Object) object.Object {
+    return evalIndexExpression(left, index)
+}
+
+func IsTruthy(obj object.Object) bool {
+    return isTruthy(obj)
+}
+
+// Builtin returns the builtin called name. Environments made by interpreters
+// hold their own copies, which take precedence.
+func Builtin(name string) (*object.BuiltIn, bool) {
+    builtin, ok := builtins[name]
+    return builtin, ok
+}
+
+// ThrownError is the error raised by throwing val
+func ThrownError(val object.Object) *object.Error {
+    return newThrownError(val)
+}
+
+// ErrorHash is the value a catch block binds for errObj
+func ErrorHash(errObj *object.Error) *object.Hash {
+    return errorToHash(errObj)
+}
+
+// Catches reports whether a catch clause for kind, empty for one without a
+// kind, accepts errObj
+func Catches(kind string, errObj *object.Error) bool {
+    // running out of limits has to stop the script, so it is never caught
+    if errObj.ErrorKind() == object.LimitError {
+        return false
+    }
+    return kind == "" || object.ErrorKind(kind) == errObj.ErrorKind()
+}
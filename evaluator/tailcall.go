@@ -0,0 +1,24 @@
+package evaluator
+
+import (
+    "monkey/object"
+)
+
+// tailCall is the result of a call in tail position that has not been made
+// yet. It never escapes applyFunction.
+type tailCall struct {
+    function object.Object
+    args []object.Object
+}
+
+func (tc *tailCall) Type() object.ObjectType {
+    return "TAIL_CALL"
+}
+
+func (tc *tailCall) Inspect() string {
+    return "tail call to " + tc.function.Inspect()
+}
+
+func (tc *tailCall) Equals(other object.Object) bool {
+    return tc == other
+}
@@ -0,0 +1,486 @@
+// Package formatter prints Monkey programs in one canonical layout, so that
+// formatting source twice gives the same result. Blocks are indented by four
+// spaces, every statement is on a line of its own and ends in a semicolon,
+// except for if and try expressions standing alone, infix operators are
+// surrounded by spaces and only the parentheses needed are kept.
+//
+// Arrays, hashes and arguments that do not fit in Width columns are broken up,
+// one element per line. A last element starting a block, a function say,
+// does not count against the width, so the closing bracket follows its block.
+// Comments and single blank lines between statements are kept.
+package formatter
+
+import (
+	"math"
+	"monkey/ast"
+	"monkey/lexer"
+	"monkey/parser"
+	"strings"
+)
+
+const (
+    Width = 80              // columns a line may take before it is broken
+    Indent = "    "
+)
+
+// ParseError holds the errors of source that could not be formatted since
+// it does not parse
+type ParseError struct {
+    Errors []string
+}
+
+func (e *ParseError) Error() string {
+    return strings.Join(e.Errors, "\n")
+}
+
+// Source returns src formatted
+func Source(src string) (string, error) {
+    l := lexer.New(src)
+    p := parser.New(l)
+    program := p.ParseProgram()
+    if len(p.Errors()) != 0 {
+        return "", &ParseError{Errors: p.Errors()}
+    }
+
+    f := &printer{comments: l.Comments(), lines: strings.Split(src, "\n")}
+    out := f.statements(program.Statements, "", math.MaxInt)
+    if out == "" {
+        return "", nil
+    }
+    return out + "\n", nil
+}
+
+type printer struct {
+    comments []lexer.Comment
+    next int                // the first comment not printed yet
+    lines []string          // of the source, to find blank lines
+}
+
+// comment returns the next comment if there is one before line
+func (p *printer) comment(line int) (lexer.Comment, bool) {
+    if p.next < len(p.comments) && p.comments[p.next].Line < line {
+        return p.comments[p.next], true
+    }
+    return lexer.Comment{}, false
+}
+
+func (p *printer) blank(line int) bool {
+    return line >= 1 && line <= len(p.lines) && strings.TrimSpace(p.lines[line-1]) == ""
+}
+
+// statements formats the statements of a program or block, with the comments
+// before line end, each line indented by indent
+func (p *printer) statements(statements []ast.Statement, indent string, end int) string {
+    var out strings.Builder
+
+    // newline starts the line for what is at line in the source, keeping a
+    // blank line before it
+    newline := func(line int) {
+        if out.Len() > 0 {
+            out.WriteString("\n")
+            if p.blank(line - 1) {
+                out.WriteString("\n")
+            }
+        }
+        out.WriteString(indent)
+    }
+
+    comments := func(line int) {
+        for c, ok := p.comment(line); ok; c, ok = p.comment(line) {
+            newline(c.Line)
+            out.WriteString(c.Text)
+            p.next++
+        }
+    }
+
+    for i, statement := range statements {
+        line := statementLine(statement)
+        comments(line)
+        newline(line)
+
+        var next ast.Statement
+        limit := end
+        if i + 1 < len(statements) {
+            next = statements[i+1]
+            limit = statementLine(next)
+        }
+        out.WriteString(p.statement(statement, indent, next))
+
+        // a comment after the statement stays at its end
+        if c, ok := p.comment(limit); ok && c.Trailing {
+            out.WriteString(" " + c.Text)
+            p.next++
+        }
+    }
+    comments(end)
+
+    return out.String()
+}
+
+func statementLine(statement ast.Statement) int {
+    switch statement := statement.(type) {
+    case *ast.LetStatement:
+        return statement.Token.Line
+    case *ast.ReturnStatement:
+        return statement.Token.Line
+    case *ast.ThrowStatement:
+        return statement.Token.Line
+    case *ast.ExpressionStatement:
+        return statement.Token.Line
+    }
+    return 0
+}
+
+// statement formats statement, starting at indent. next is the statement
+// following it in the same block, if any.
+func (p *printer) statement(statement ast.Statement, indent string, next ast.Statement) string {
+    keyword := func(prefix string, value ast.Expression) string {
+        return prefix + p.expression(value, indent, len(indent) + len(prefix)) + ";"
+    }
+
+    switch statement := statement.(type) {
+    case *ast.LetStatement:
+        return keyword("let " + statement.Name.Value + " = ", statement.Value)
+    case *ast.ReturnStatement:
+        return keyword("return ", statement.ReturnValue)
+    case *ast.ThrowStatement:
+        return keyword("throw ", statement.Value)
+    case *ast.ExpressionStatement:
+        out := p.expression(statement.Expression, indent, len(indent))
+        if endsInBlock(statement.Expression) && !continues(next) {
+            return out
+        }
+        return out + ";"
+    }
+    return statement.String()
+}
+
+func endsInBlock(node ast.Expression) bool {
+    switch node.(type) {
+    case *ast.IfExpression, *ast.TryExpression:
+        return true
+    }
+    return false
+}
+
+// continues reports whether next would be parsed as part of the expression
+// before it, were there no semicolon in between: it starts with a bracket
+// or a minus
+func continues(next ast.Statement) bool {
+    statement, ok := next.(*ast.ExpressionStatement)
+    if !ok {
+        return false
+    }
+
+    node := statement.Expression
+    for {
+        switch expr := node.(type) {
+        case *ast.InfixExpression:
+            if precedence(expr.Left) < precedence(expr) {
+                return true
+            }
+            node = expr.Left
+        case *ast.CallExpression:
+            if precedence(expr.Function) < parser.CALL {
+                return true
+            }
+            node = expr.Function
+        case *ast.IndexExpression:
+            if precedence(expr.Left) < parser.CALL {
+                return true
+            }
+            node = expr.Left
+        case *ast.PrefixExpression:
+            return expr.Operator == "-"
+        case *ast.ArrayLiteral:
+            return true
+        default:
+            return false
+        }
+    }
+}
+
+// expression formats node, starting at column col. Lines after the first are
+// indented by indent.
+func (p *printer) expression(node ast.Expression, indent string, col int) string {
+    switch node := node.(type) {
+    case *ast.Identifier:
+        return node.Value
+    case *ast.IntegerLiteral:
+        return node.Token.Literal
+    case *ast.FloatLiteral:
+        return node.Token.Literal
+    case *ast.StringLiteral:
+        return "\"" + node.Value + "\""
+    case *ast.Boolean:
+        if node.Value {
+            return "true"
+        }
+        return "false"
+    case *ast.NullLiteral:
+        return "null"
+    case *ast.PrefixExpression:
+        // --x would read like a decrement
+        if right, ok := node.Right.(*ast.PrefixExpression); ok && node.Operator == "-" && right.Operator == "-" {
+            return node.Operator + "(" + p.expression(right, indent, col + len(node.Operator) + 1) + ")"
+        }
+        return node.Operator + p.operand(node.Right, parser.PREFIX, indent, col + len(node.Operator))
+    case *ast.InfixExpression:
+        // operators are left associative, so an equal one on the right needs
+        // parentheses
+        prec := precedence(node)
+        left := p.operand(node.Left, prec, indent, col)
+        operator := " " + node.Operator + " "
+        return left + operator + p.operand(node.Right, prec + 1, indent, after(col, left) + len(operator))
+    case *ast.CallExpression:
+        function := p.operand(node.Function, parser.CALL, indent, col)
+        return function + p.list("(", ")", p.items(node.Arguments), indent, after(col, function))
+    case *ast.IndexExpression:
+        left := p.operand(node.Left, parser.CALL, indent, col)
+        return left + "[" + p.expression(node.Index, indent, after(col, left) + 1) + "]"
+    case *ast.ArrayLiteral:
+        return p.list("[", "]", p.items(node.Elements), indent, col)
+    case *ast.HashLiteral:
+        items := []item{}
+        for _, pair := range node.Pairs {
+            pair := pair
+            items = append(items, item{
+                first: firstLine(pair.Key),
+                last: lastLine(pair.Value),
+                format: func(indent string, col int) string {
+                    key := p.expression(pair.Key, indent, col)
+                    return key + ": " + p.expression(pair.Value, indent, after(col, key) + 2)
+                },
+            })
+        }
+        return p.list("{", "}", items, indent, col)
+    case *ast.FunctionLiteral:
+        params := []string{}
+        for _, param := range node.Parameters {
+            params = append(params, param.Value)
+        }
+        return "fn(" + strings.Join(params, ", ") + ") " + p.block(node.Body, indent)
+    case *ast.IfExpression:
+        out := "if (" + p.expression(node.Condition, indent, col + 4) + ") " + p.block(node.Consequence, indent)
+        if node.Alternative != nil {
+            out += " else " + p.block(node.Alternative, indent)
+        }
+        return out
+    case *ast.TryExpression:
+        out := "try " + p.block(node.Block, indent)
+        for _, clause := range node.Catches {
+            out += " catch "
+            if clause.Parameter != nil {
+                out += "("
+                if clause.Kind != nil {
+                    out += clause.Kind.Value + " "
+                }
+                out += clause.Parameter.Value + ") "
+            }
+            out += p.block(clause.Body, indent)
+        }
+        if node.Finally != nil {
+            out += " finally " + p.block(node.Finally, indent)
+        }
+        return out
+    }
+    return node.String()
+}
+
+// operand formats node in parentheses if it binds less tightly than min
+func (p *printer) operand(node ast.Expression, min int, indent string, col int) string {
+    if precedence(node) < min {
+        return "(" + p.expression(node, indent, col + 1) + ")"
+    }
+    return p.expression(node, indent, col)
+}
+
+// precedence returns how tightly node holds together, operands of operators
+// binding more tightly need parentheses
+func precedence(node ast.Expression) int {
+    switch node := node.(type) {
+    case *ast.InfixExpression:
+        return parser.Precedence(node.Token.Type)
+    case *ast.PrefixExpression:
+        return parser.PREFIX
+    case *ast.CallExpression:
+        return parser.CALL
+    case *ast.IndexExpression:
+        return parser.INDEX
+    }
+    return parser.INDEX + 1
+}
+
+func (p *printer) block(block *ast.BlockStatement, indent string) string {
+    body := p.statements(block.Statements, indent + Indent, block.End.Line)
+    if body == "" {
+        return "{}"
+    }
+    return "{\n" + body + "\n" + indent + "}"
+}
+
+// item is an element of an array, a pair of a hash or an argument of a call
+type item struct {
+    first, last int         // the lines of the source it spans
+    format func(indent string, col int) string
+}
+
+func (p *printer) items(nodes []ast.Expression) []item {
+    items := []item{}
+    for _, node := range nodes {
+        node := node
+        items = append(items, item{
+            first: firstLine(node),
+            last: lastLine(node),
+            format: func(indent string, col int) string {
+                return p.expression(node, indent, col)
+            },
+        })
+    }
+    return items
+}
+
+// list formats items between open and close, on one line if they fit and
+// there are no comments between them, otherwise each on a line of its own
+func (p *printer) list(open, close string, items []item, indent string, col int) string {
+    if len(items) == 0 {
+        return open + close
+    }
+
+    next := p.next
+    parts := []string{}
+    at := col + len(open)
+    for _, it := range items {
+        part := it.format(indent, at)
+        parts = append(parts, part)
+        at = after(at, part) + 2
+    }
+
+    // comments left before the last line are between the items, formatting
+    // the items took those in their blocks
+    flat := open + strings.Join(parts, ", ") + close
+    firstLine, _, _ := strings.Cut(flat, "\n")
+    _, between := p.comment(items[len(items)-1].last)
+    if !between && col + len(firstLine) <= Width && !strings.Contains(strings.Join(parts[:len(parts)-1], ""), "\n") {
+        return flat
+    }
+    p.next = next
+
+    inner := indent + Indent
+    var out strings.Builder
+    out.WriteString(open)
+
+    for i, it := range items {
+        for c, ok := p.comment(it.first); ok; c, ok = p.comment(it.first) {
+            out.WriteString("\n" + inner + c.Text)
+            p.next++
+        }
+
+        out.WriteString("\n" + inner + it.format(inner, len(inner)))
+
+        limit := it.last + 1
+        if i + 1 < len(items) {
+            out.WriteString(",")
+            limit = items[i+1].first
+        }
+        if c, ok := p.comment(limit); ok && c.Trailing {
+            out.WriteString(" " + c.Text)
+            p.next++
+        }
+    }
+
+    out.WriteString("\n" + indent + close)
+    return out.String()
+}
+
+// after returns the column reached by writing s from column col
+func after(col int, s string) int {
+    if i := strings.LastIndex(s, "\n"); i >= 0 {
+        return len(s) - i - 1
+    }
+    return col + len(s)
+}
+
+// firstLine returns the line of the source node starts on
+func firstLine(node ast.Expression) int {
+    switch node := node.(type) {
+    case *ast.InfixExpression:
+        return firstLine(node.Left)
+    case *ast.CallExpression:
+        return firstLine(node.Function)
+    case *ast.IndexExpression:
+        return firstLine(node.Left)
+    case *ast.Identifier:
+        return node.Token.Line
+    case *ast.IntegerLiteral:
+        return node.Token.Line
+    case *ast.FloatLiteral:
+        return node.Token.Line
+    case *ast.StringLiteral:
+        return node.Token.Line
+    case *ast.Boolean:
+        return node.Token.Line
+    case *ast.NullLiteral:
+        return node.Token.Line
+    case *ast.PrefixExpression:
+        return node.Token.Line
+    case *ast.ArrayLiteral:
+        return node.Token.Line
+    case *ast.HashLiteral:
+        return node.Token.Line
+    case *ast.FunctionLiteral:
+        return node.Token.Line
+    case *ast.IfExpression:
+        return node.Token.Line
+    case *ast.TryExpression:
+        return node.Token.Line
+    }
+    return 0
+}
+
+// lastLine returns the line of the source node ends on, as far as its tokens
+// tell: the closing brackets of arrays, hashes and calls are not kept
+func lastLine(node ast.Expression) int {
+    last := firstLine(node)
+
+    switch node := node.(type) {
+    case *ast.StringLiteral:
+        last += strings.Count(node.Value, "\n")
+    case *ast.PrefixExpression:
+        last = lastLine(node.Right)
+    case *ast.InfixExpression:
+        last = lastLine(node.Right)
+    case *ast.IndexExpression:
+        last = lastLine(node.Index)
+    case *ast.CallExpression:
+        last = lastLine(node.Function)
+        if n := len(node.Arguments); n > 0 {
+            last = lastLine(node.Arguments[n-1])
+        }
+    case *ast.ArrayLiteral:
+        if n := len(node.Elements); n > 0 {
+            last = lastLine(node.Elements[n-1])
+        }
+    case *ast.HashLiteral:
+        if n := len(node.Pairs); n > 0 {
+            last = lastLine(node.Pairs[n-1].Value)
+        }
+    case *ast.FunctionLiteral:
+        last = node.Body.End.Line
+    case *ast.IfExpression:
+        last = node.Consequence.End.Line
+        if node.Alternative != nil {
+            last = node.Alternative.End.Line
+        }
+    case *ast.TryExpression:
+        last = node.Block.End.Line
+        if n := len(node.Catches); n > 0 {
+            last = node.Catches[n-1].Body.End.Line
+        }
+        if node.Finally != nil {
+            last = node.Finally.End.Line
+        }
+    }
+
+    return last
+}
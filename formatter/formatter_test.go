@@ -0,0 +1,96 @@
+package formatter
+
+import (
+	"errors"
+	"monkey/enginetest"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/resolver"
+	"strings"
+	"testing"
+)
+
+func TestSource(t *testing.T) {
+    tests := []struct {
+        input string
+        expected string
+    }{
+        {"", ""},
+        {"let   x=1+2*3", "let x = 1 + 2 * 3;\n"},
+        {"(1 + 2) * 3; 1 + (2 * 3); a - (b - c); (a - b) - c", "(1 + 2) * 3;\n1 + 2 * 3;\na - (b - c);\na - b - c;\n"},
+        {"-(-x); !(a < b); (-a)[0]; -a[0]; (f)(x); (fn(x) { x })(1)", "-(-x);\n!(a < b);\n(-a)[0];\n-a[0];\nf(x);\nfn(x) {\n    x;\n}(1);\n"},
+        {"-(-1); - -1; -(-(-a)); !!x; -!x; !-x", "-(-1);\n-(-1);\n-(-(-a));\n!!x;\n-!x;\n!-x;\n"},
+        {`{"a": [1.50, true, null], "b": {}}`, "{\"a\": [1.50, true, null], \"b\": {}};\n"},
+        {"let f = fn() {}; return f()", "let f = fn() {};\nreturn f();\n"},
+        // if and try standing alone need no semicolon, unless what follows
+        // would continue them
+        {"if (x) { 1 } else { 2 }\nputs(1)", "if (x) {\n    1;\n} else {\n    2;\n}\nputs(1);\n"},
+        {"if (x) { 1 }; -1; try { a } catch (e) { e }; [1]", "if (x) {\n    1;\n};\n-1;\ntry {\n    a;\n} catch (e) {\n    e;\n};\n[1];\n"},
+        {"try { throw 1 } catch (TypeError e) { e } catch { 2 } finally { 3 }",
+            "try {\n    throw 1;\n} catch (TypeError e) {\n    e;\n} catch {\n    2;\n} finally {\n    3;\n}\n"},
+        // blank lines are kept, though only one
+        {"let a = 1;\n\n\n\nlet b = 2;\nlet c = 3;\n", "let a = 1;\n\nlet b = 2;\nlet c = 3;\n"},
+        {"let f = fn(x) {\n\n  let y = x;\n\n  y\n}", "let f = fn(x) {\n    let y = x;\n\n    y;\n};\n"},
+        // too long for a line
+        {`let config = {"name": "monkey", "version": 2, "tags": ["interpreter", "language"], "x": 1}`,
+            "let config = {\n    \"name\": \"monkey\",\n    \"version\": 2,\n    \"tags\": [\"interpreter\", \"language\"],\n    \"x\": 1\n};\n"},
+        {`puts("a rather long string to print", "and another one", "and one more to print")`,
+            "puts(\n    \"a rather long string to print\",\n    \"and another one\",\n    \"and one more to print\"\n);\n"},
+        // a function passed last keeps the call on its line
+        {"map(xs, fn(x) { x * 2 })", "map(xs, fn(x) {\n    x * 2;\n});\n"},
+        // comments
+        {"// start\nlet a = 1; // one\n\n// two\nlet b = 2\n// end", "// start\nlet a = 1; // one\n\n// two\nlet b = 2;\n// end\n"},
+        {"let f = fn() { // body\n  1 // one\n  // last\n} // f", "let f = fn() {\n    // body\n    1; // one\n    // last\n}; // f\n"},
+        {"let a = [\n  1, // one\n  // two\n  2\n]", "let a = [\n    1, // one\n    // two\n    2\n];\n"},
+        {"map(xs, fn(x) {\n  // double\n  x * 2\n})", "map(xs, fn(x) {\n    // double\n    x * 2;\n});\n"},
+    }
+
+    for _, tt := range tests {
+        got, err := Source(tt.input)
+        if err != nil {
+            t.Fatalf("error formatting %q: %v", tt.input, err)
+        }
+        if got != tt.expected {
+            t.Errorf("wrong output for %q.\nexpected=%q\ngot=     %q", tt.input, tt.expected, got)
+            continue
+        }
+
+        if again, _ := Source(got); again != got {
+            t.Errorf("formatting %q again changed it to %q", got, again)
+        }
+    }
+}
+
+func TestSourceParseError(t *testing.T) {
+    _, err := Source("let = 1")
+
+    var parseErr *ParseError
+    if !errors.As(err, &parseErr) || len(parseErr.Errors) == 0 {
+        t.Fatalf("expected a ParseError. got=%v", err)
+    }
+}
+
+// formatted programs give the same results, and stay the same formatted again
+func TestCorpus(t *testing.T) {
+    enginetest.Run(t, func(input string) object.Object {
+        formatted, err := Source(input)
+        if err != nil {
+            t.Fatalf("error formatting %q: %v", input, err)
+        }
+        if again, _ := Source(formatted); again != formatted {
+            t.Errorf("formatting %q again changed it to %q", formatted, again)
+        }
+
+        p := parser.New(lexer.New(formatted))
+        program := p.ParseProgram()
+        if len(p.Errors()) != 0 {
+            t.Fatalf("parser errors for %q formatted as %q: %s", input, formatted, strings.Join(p.Errors(), "; "))
+        }
+
+        env := object.NewEnvironment()
+        resolver.Resolve(program, env)
+        return evaluator.Eval(program, env)
+    })
+}
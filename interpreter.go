@@ -0,0 +1,300 @@
+// Package monkey is the entry point for embedding the Monkey language in Go
+// programs. It wires the lexer, the parser and one of the engines, the
+// evaluator or the compiler and vm, together behind a single Interpreter type.
+package monkey
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/optimizer"
+	"monkey/parser"
+	"monkey/resolver"
+	"monkey/vm"
+	"os"
+	"strings"
+)
+
+// Interpreter runs Monkey source code. Every interpreter has its own globals
+// and builtins, so several of them can live side by side in one process. An
+// Interpreter is not safe for concurrent use.
+type Interpreter struct {
+    builtins *object.Environment    // outermost scope, holds the builtin functions
+    globals *object.Environment
+    stdin io.Reader
+    stdout io.Writer
+    stderr io.Writer
+    stdio *evaluator.IO         // what the I/O builtins read from and write to
+    limits evaluator.Limits
+    checkNames bool
+    optimize bool
+    engine Engine
+    cacheDir string             // where RunFile keeps compiled scripts, see WithCache
+}
+
+// Engine selects what runs the scripts of an Interpreter. Both engines give
+// the same results, the vm is faster for scripts doing a lot of work.
+type Engine int
+
+const (
+    EngineEval Engine = iota    // walks the syntax tree, the default
+    EngineVM                    // compiles to bytecode and runs it on the vm
+)
+
+type Option func(*Interpreter)
+
+// WithStdin makes input read from r instead of os.Stdin
+func WithStdin(r io.Reader) Option {
+    return func(in *Interpreter) {
+        in.stdin = r
+    }
+}
+
+// WithStdout sends the output of puts and print to w instead of os.Stdout
+func WithStdout(w io.Writer) Option {
+    return func(in *Interpreter) {
+        in.stdout = w
+    }
+}
+
+// WithStderr sets where eprint writes and the interpreter reports errors,
+// os.Stderr by default
+func WithStderr(w io.Writer) Option {
+    return func(in *Interpreter) {
+        in.stderr = w
+    }
+}
+
+// WithEngine selects the engine running scripts, EngineEval by default
+func WithEngine(engine Engine) Option {
+    return func(in *Interpreter) {
+        in.engine = engine
+    }
+}
+
+// WithCache makes RunFile keep the bytecode of the scripts it runs in dir,
+// keyed by a hash of their source, and load it instead of parsing and
+// compiling scripts again that have not changed. It only applies to EngineVM.
+// Cached scripts are not checked for undefined names again, see
+// WithNameCheck, since they were when compiled. The cache is best effort:
+// scripts still run if dir cannot be read or written.
+func WithCache(dir string) Option {
+    return func(in *Interpreter) {
+        in.cacheDir = dir
+    }
+}
+
+// WithMaxSteps stops every call to Eval with a LimitError after it has
+// evaluated n nodes, or run n instructions on the vm. Use a context deadline
+// to bound wall-clock time instead.
+func WithMaxSteps(n int64) Option {
+    return func(in *Interpreter) {
+        in.limits.MaxSteps = n
+    }
+}
+
+// WithMaxDepth limits how deeply Monkey functions may call each other,
+// evaluator.DefaultMaxDepth by default
+func WithMaxDepth(n int) Option {
+    return func(in *Interpreter) {
+        in.limits.MaxDepth = n
+    }
+}
+
+// WithMaxMemory stops every call to Eval with a LimitError once it has
+// allocated about n bytes of strings, arrays, hashes and sets
+func WithMaxMemory(n int64) Option {
+    return func(in *Interpreter) {
+        in.limits.MaxMemory = n
+    }
+}
+
+// WithNameCheck makes Eval refuse scripts using names that are defined
+// nowhere, with a *NameCheckError, instead of failing once they run into one
+func WithNameCheck() Option {
+    return func(in *Interpreter) {
+        in.checkNames = true
+    }
+}
+
+// WithOptimizer makes Eval fold constant expressions and prune if branches
+// that are never taken before running scripts, see optimizer.Optimize
+func WithOptimizer() Option {
+    return func(in *Interpreter) {
+        in.optimize = true
+    }
+}
+
+func New(opts ...Option) *Interpreter {
+    in := &Interpreter{
+        stdin: os.Stdin,
+        stdout: os.Stdout,
+        stderr: os.Stderr,
+    }
+
+    for _, opt := range opts {
+        opt(in)
+    }
+
+    in.stdio = evaluator.NewIO(in.stdin, in.stdout, in.stderr)
+    in.builtins = object.NewEnvironment()
+    for name, builtin := range evaluator.NewBuiltins(in.stdio) {
+        in.builtins.Set(name, builtin)
+    }
+    in.globals = object.NewEnclosedEnvironment(in.builtins)
+
+    return in
+}
+
+// ParseError holds every error reported by the parser for a piece of source
+type ParseError struct {
+    Errors []string
+}
+
+func (e *ParseError) Error() string {
+    return "parser errors:\n\t" + strings.Join(e.Errors, "\n\t")
+}
+
+// NameCheckError lists the undefined names found by WithNameCheck. It matches
+// object.NameError with errors.Is, like the error the script would hit.
+type NameCheckError struct {
+    Errors []string
+}
+
+func (e *NameCheckError) Error() string {
+    return "name errors:\n\t" + strings.Join(e.Errors, "\n\t")
+}
+
+func (e *NameCheckError) Is(target error) bool {
+    return target == object.NameError
+}
+
+// Eval runs src in the global scope of the interpreter and returns the value
+// of its last statement. Parser errors are returned as a *ParseError and
+// runtime errors as the *object.Error produced by the script, which can be
+// inspected with errors.Is(err, object.TypeError) and the like.
+//
+// Evaluation stops with an object.LimitError, which scripts cannot catch, once
+// ctx is done, the step budget set by WithMaxSteps runs out or the script
+// allocates more than allowed by WithMaxMemory.
+func (in *Interpreter) Eval(ctx context.Context, src string) (object.Object, error) {
+    if err := ctx.Err(); err != nil {
+        return nil, err
+    }
+
+    program, err := in.parse(src)
+    if err != nil {
+        return nil, err
+    }
+
+    if in.engine == EngineVM {
+        bytecode, err := compiler.Compile(program)
+        if err != nil {
+            return nil, err
+        }
+        return in.run(ctx, bytecode)
+    }
+
+    return in.result(evaluator.EvalContext(ctx, program, in.globals, in.limits))
+}
+
+// parse parses, optimizes and resolves src, checking names if asked to
+func (in *Interpreter) parse(src string) (*ast.Program, error) {
+    p := parser.New(lexer.New(src))
+    program := p.ParseProgram()
+    if len(p.Errors()) != 0 {
+        return nil, &ParseError{Errors: p.Errors()}
+    }
+
+    if in.optimize {
+        optimizer.Optimize(program)
+    }
+
+    undefined := resolver.Resolve(program, in.globals)
+    if in.checkNames && len(undefined) != 0 {
+        return nil, &NameCheckError{Errors: undefined}
+    }
+
+    return program, nil
+}
+
+func (in *Interpreter) run(ctx context.Context, bytecode *compiler.Bytecode) (object.Object, error) {
+    return in.result(vm.RunContext(ctx, bytecode, in.globals, in.limits))
+}
+
+func (in *Interpreter) result(result object.Object) (object.Object, error) {
+    if errObj, ok := result.(*object.Error); ok {
+        return nil, errObj
+    }
+
+    if result == nil {
+        result = evaluator.NULL
+    }
+
+    return result, nil
+}
+
+// RunFile evaluates the Monkey source file at path, using the cache set up by
+// WithCache if any
+func (in *Interpreter) RunFile(ctx context.Context, path string) (object.Object, error) {
+    src, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+
+    if in.cacheDir != "" && in.engine == EngineVM {
+        return in.runCached(ctx, src)
+    }
+
+    return in.Eval(ctx, string(src))
+}
+
+func (in *Interpreter) SetGlobal(name string, val object.Object) {
+    in.globals.Set(name, val)
+}
+
+func (in *Interpreter) GetGlobal(name string) (object.Object, bool) {
+    return in.globals.Get(name)
+}
+
+// RegisterBuiltin makes fn callable as name from scripts run by this
+// interpreter only. Globals with the same name take precedence.
+func (in *Interpreter) RegisterBuiltin(name string, fn object.BuiltInFunction) {
+    in.builtins.Set(name, &object.BuiltIn{Fn: fn})
+}
+
+// RegisterFunc exposes an arbitrary Go function to scripts, converting
+// arguments and results as described by object.NewBuiltin
+func (in *Interpreter) RegisterFunc(name string, fn any) error {
+    builtin, err := object.NewBuiltin(name, fn)
+    if err != nil {
+        return err
+    }
+
+    in.builtins.Set(name, builtin)
+    return nil
+}
+
+// Env returns the global environment of the interpreter
+func (in *Interpreter) Env() *object.Environment {
+    return in.globals
+}
+
+// Stdin returns the buffered reader input reads from. Callers reading lines
+// themselves should use it so that input does not miss buffered data.
+func (in *Interpreter) Stdin() *bufio.Reader {
+    return in.stdio.Stdin
+}
+
+func (in *Interpreter) Stdout() io.Writer {
+    return in.stdout
+}
+
+func (in *Interpreter) Stderr() io.Writer {
+    return in.stderr
+}
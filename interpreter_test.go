@@ -0,0 +1,395 @@
+package monkey
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"monkey/compiler"
+	"monkey/enginetest"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInterpreterEval(t *testing.T) {
+    in := New()
+
+    result, err := in.Eval(context.Background(), "let a = 5; a * 2")
+    if err != nil {
+        t.Fatalf("unexpected error: %s", err)
+    }
+
+    if result.Inspect() != "10" {
+        t.Errorf("wrong result. got=%s", result.Inspect())
+    }
+
+    // globals persist between calls
+    result, err = in.Eval(context.Background(), "a + 1")
+    if err != nil {
+        t.Fatalf("unexpected error: %s", err)
+    }
+
+    if result.Inspect() != "6" {
+        t.Errorf("wrong result. got=%s", result.Inspect())
+    }
+
+    result, err = in.Eval(context.Background(), "let b = 1;")
+    if err != nil || result.Type() != object.NULL_OBJ {
+        t.Errorf("let statement should evaluate to null. got=%v, %v", result, err)
+    }
+}
+
+func TestInterpreterErrors(t *testing.T) {
+    in := New()
+
+    _, err := in.Eval(context.Background(), "let = 5;")
+    var parseErr *ParseError
+    if !errors.As(err, &parseErr) {
+        t.Fatalf("expected a *ParseError. got=%T (%v)", err, err)
+    }
+
+    _, err = in.Eval(context.Background(), `1 + "a"`)
+    if !errors.Is(err, object.TypeError) {
+        t.Errorf("expected a TypeError. got=%T (%v)", err, err)
+    }
+
+    var errObj *object.Error
+    if !errors.As(err, &errObj) || errObj.Message != "type mismatch: INTEGER + STRING" {
+        t.Errorf("wrong error. got=%v", err)
+    }
+
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+    if _, err := in.Eval(ctx, "1"); !errors.Is(err, context.Canceled) {
+        t.Errorf("expected context.Canceled. got=%v", err)
+    }
+}
+
+func TestInterpreterGlobalsAndBuiltins(t *testing.T) {
+    var out bytes.Buffer
+    first := New(WithStdout(&out))
+    second := New()
+
+    first.SetGlobal("limit", &object.Integer{Value: 3})
+    first.RegisterBuiltin("double", func(args ...object.Object) object.Object {
+        return &object.Integer{Value: args[0].(*object.Integer).Value * 2}
+    })
+
+    result, err := first.Eval(context.Background(), `puts("hi"); let total = double(limit); total`)
+    if err != nil {
+        t.Fatalf("unexpected error: %s", err)
+    }
+    if result.Inspect() != "6" {
+        t.Errorf("wrong result. got=%s", result.Inspect())
+    }
+    if out.String() != "hi\n" {
+        t.Errorf("puts did not write to the configured stdout. got=%q", out.String())
+    }
+
+    total, ok := first.GetGlobal("total")
+    if !ok || total.Inspect() != "6" {
+        t.Errorf("GetGlobal(total) wrong. got=%v, %t", total, ok)
+    }
+
+    // nothing leaks into another interpreter
+    if _, ok := second.GetGlobal("limit"); ok {
+        t.Errorf("global leaked between interpreters")
+    }
+    if _, err := second.Eval(context.Background(), "double(1)"); !errors.Is(err, object.NameError) {
+        t.Errorf("builtin leaked between interpreters. got=%v", err)
+    }
+}
+
+func TestInterpreterEngines(t *testing.T) {
+    for _, engine := range []Engine{EngineEval, EngineVM} {
+        enginetest.Run(t, func(input string) object.Object {
+            result, err := New(WithEngine(engine)).Eval(context.Background(), input)
+            if errObj, ok := err.(*object.Error); ok {
+                return errObj
+            }
+            if err != nil {
+                t.Fatalf("unexpected error for %q: %s", input, err)
+            }
+            return result
+        })
+    }
+
+    // functions compiled by one call to Eval can be called by the next, and
+    // see the builtins and globals of the interpreter
+    var out bytes.Buffer
+    in := New(WithEngine(EngineVM), WithStdout(&out))
+    in.SetGlobal("limit", &object.Integer{Value: 3})
+    in.RegisterBuiltin("double", func(args ...object.Object) object.Object {
+        return &object.Integer{Value: args[0].(*object.Integer).Value * 2}
+    })
+
+    if _, err := in.Eval(context.Background(), `let scale = fn(x) { double(x) * limit }`); err != nil {
+        t.Fatalf("unexpected error: %s", err)
+    }
+    result, err := in.Eval(context.Background(), `puts(scale(2)); scale(1)`)
+    if err != nil || result.Inspect() != "6" || out.String() != "12\n" {
+        t.Errorf("wrong result. got=%v, %v, output %q", result, err, out.String())
+    }
+}
+
+func TestInterpreterOptimizer(t *testing.T) {
+    for _, engine := range []Engine{EngineEval, EngineVM} {
+        enginetest.Run(t, func(input string) object.Object {
+            result, err := New(WithEngine(engine), WithOptimizer()).Eval(context.Background(), input)
+            if errObj, ok := err.(*object.Error); ok {
+                return errObj
+            }
+            if err != nil {
+                t.Fatalf("unexpected error for %q: %s", input, err)
+            }
+            return result
+        })
+    }
+
+    // folded constants are not computed again on every call
+    src := `let seconds = fn(h) { let minutes = 60; h * minutes * (60 * 1) }; seconds(1) + seconds(2)`
+    steps := func(opts ...Option) int64 {
+        var budget int64 = 1
+        for {
+            _, err := New(append(opts, WithMaxSteps(budget))...).Eval(context.Background(), src)
+            if !errors.Is(err, object.LimitError) {
+                if err != nil {
+                    t.Fatalf("unexpected error: %s", err)
+                }
+                return budget
+            }
+            budget++
+        }
+    }
+
+    if plain, optimized := steps(), steps(WithOptimizer()); optimized >= plain {
+        t.Errorf("optimizing did not save steps. plain=%d, optimized=%d", plain, optimized)
+    }
+}
+
+func TestInterpreterIO(t *testing.T) {
+    var stdout, stderr bytes.Buffer
+    in := New(
+        WithStdin(strings.NewReader("Ada\nLovelace")),
+        WithStdout(&stdout),
+        WithStderr(&stderr),
+    )
+
+    src := `
+    let first = input("first? ");
+    let last = input();
+    print("hello", first, last);
+    eprint("end of input:", input());
+    `
+    if _, err := in.Eval(context.Background(), src); err != nil {
+        t.Fatalf("unexpected error: %s", err)
+    }
+
+    if stdout.String() != "first? hello Ada Lovelace\n" {
+        t.Errorf("wrong stdout. got=%q", stdout.String())
+    }
+    if stderr.String() != "end of input: null\n" {
+        t.Errorf("wrong stderr. got=%q", stderr.String())
+    }
+}
+
+func TestInterpreterLimits(t *testing.T) {
+    loop := `let loop = fn(n) { if (n == 0) { 0 } else { loop(n - 1) } }; loop(1000)`
+
+    if _, err := New(WithMaxSteps(50)).Eval(context.Background(), loop); !errors.Is(err, object.LimitError) {
+        t.Errorf("expected a LimitError from the step budget. got=%v", err)
+    }
+    if _, err := New(WithMaxSteps(50), WithEngine(EngineVM)).Eval(context.Background(), loop); !errors.Is(err, object.LimitError) {
+        t.Errorf("expected a LimitError from the step budget of the vm. got=%v", err)
+    }
+
+    // the budget applies to each call to Eval separately
+    in := New(WithMaxSteps(100000))
+    for i := 0; i < 3; i++ {
+        if _, err := in.Eval(context.Background(), loop); err != nil {
+            t.Fatalf("unexpected error: %s", err)
+        }
+    }
+
+    // the addition keeps the recursive call out of tail position
+    deep := `let sum = fn(n) { if (n == 0) { 0 } else { n + sum(n - 1) } }; sum(1000)`
+    if _, err := New(WithMaxDepth(10)).Eval(context.Background(), deep); !errors.Is(err, object.RecursionError) {
+        t.Errorf("expected a RecursionError. got=%v", err)
+    }
+
+    fill := `let fill = fn(arr, n) { if (n == 0) { arr } else { fill(push(arr, "x"), n - 1) } }; fill([], 500)`
+    if _, err := New(WithMaxMemory(10000)).Eval(context.Background(), fill); !errors.Is(err, object.LimitError) {
+        t.Errorf("expected a LimitError from the memory limit. got=%v", err)
+    }
+
+    guarded := `let f = fn() { try { [` + strings.Repeat("1, ", 100) + `1] } finally { return 1 } }; f()`
+    if _, err := New(WithMaxMemory(1000)).Eval(context.Background(), guarded); !errors.Is(err, object.LimitError) {
+        t.Errorf("expected a LimitError despite the finally block. got=%v", err)
+    }
+
+    ctx, cancel := context.WithCancel(context.Background())
+    in = New()
+    in.RegisterBuiltin("stop", func(args ...object.Object) object.Object {
+        cancel()
+        return object.NULL
+    })
+    if _, err := in.Eval(ctx, "stop(); "+loop); !errors.Is(err, object.LimitError) {
+        t.Errorf("expected a LimitError after cancelling. got=%v", err)
+    }
+}
+
+func TestInterpreterNameCheck(t *testing.T) {
+    src := `let f = fn(x) { x + missing }; 1`
+
+    // without the check the script only fails if it gets to the name
+    result, err := New().Eval(context.Background(), src)
+    if err != nil || result.Inspect() != "1" {
+        t.Errorf("expected 1. got=%v, %v", result, err)
+    }
+
+    in := New(WithNameCheck())
+    _, err = in.Eval(context.Background(), src)
+    var nameErr *NameCheckError
+    if !errors.As(err, &nameErr) || !errors.Is(err, object.NameError) {
+        t.Fatalf("expected a NameCheckError. got=%v", err)
+    }
+    if len(nameErr.Errors) != 1 || nameErr.Errors[0] != "undefined variable: missing" {
+        t.Errorf("wrong errors. got=%v", nameErr.Errors)
+    }
+
+    // globals from earlier calls and builtins are known
+    in.SetGlobal("missing", &object.Integer{Value: 1})
+    if _, err := in.Eval(context.Background(), `let g = fn(x) { len(x) + missing }; g("ab")`); err != nil {
+        t.Errorf("unexpected error: %s", err)
+    }
+}
+
+func TestInterpreterRunFile(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "script.mk")
+    if err := os.WriteFile(path, []byte("let f = fn(x) { x * x }; f(7)"), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    result, err := New().RunFile(context.Background(), path)
+    if err != nil {
+        t.Fatalf("unexpected error: %s", err)
+    }
+
+    if result.Inspect() != "49" {
+        t.Errorf("wrong result. got=%s", result.Inspect())
+    }
+}
+
+func TestInterpreterRunFileCache(t *testing.T) {
+    dir := t.TempDir()
+    cacheDir := filepath.Join(dir, "cache")
+    path := filepath.Join(dir, "script.mk")
+    if err := os.WriteFile(path, []byte("let f = fn(x) { x * x }; f(7)"), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    run := func(expected string) {
+        t.Helper()
+
+        result, err := New(WithEngine(EngineVM), WithCache(cacheDir)).RunFile(context.Background(), path)
+        if err != nil {
+            t.Fatalf("unexpected error: %s", err)
+        }
+        if result.Inspect() != expected {
+            t.Errorf("wrong result. want=%s, got=%s", expected, result.Inspect())
+        }
+    }
+
+    run("49")
+    cached, err := filepath.Glob(filepath.Join(cacheDir, "*.mkc"))
+    if err != nil || len(cached) != 1 {
+        t.Fatalf("expected one cached script, got %v (%v)", cached, err)
+    }
+
+    // the cached bytecode is run instead of the source
+    var buf bytes.Buffer
+    bytecode, err := compiler.Compile(parser.New(lexer.New("99")).ParseProgram())
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := bytecode.Encode(&buf); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.WriteFile(cached[0], buf.Bytes(), 0644); err != nil {
+        t.Fatal(err)
+    }
+    run("99")
+
+    // damaged files are compiled again and replaced
+    if err := os.WriteFile(cached[0], []byte("junk"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    run("49")
+    run("49")
+
+    // changing the script changes the key
+    if err := os.WriteFile(path, []byte("let f = fn(x) { x * x }; f(8)"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    run("64")
+    if cached, _ := filepath.Glob(filepath.Join(cacheDir, "*.mkc")); len(cached) != 2 {
+        t.Errorf("expected two cached scripts, got %v", cached)
+    }
+
+    // scripts that do not compile are not cached
+    if err := os.WriteFile(path, []byte("let = 1"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    _, err = New(WithEngine(EngineVM), WithCache(cacheDir)).RunFile(context.Background(), path)
+    var parseErr *ParseError
+    if !errors.As(err, &parseErr) {
+        t.Errorf("expected a parse error, got %v", err)
+    }
+    if cached, _ := filepath.Glob(filepath.Join(cacheDir, "*")); len(cached) != 2 {
+        t.Errorf("expected two cached scripts, got %v", cached)
+    }
+}
+
+func TestInterpreterRegisterFunc(t *testing.T) {
+    in := New()
+
+    err := in.RegisterFunc("greet", func(name string, times int) (string, error) {
+        if times < 0 {
+            return "", errors.New("times must not be negative")
+        }
+        return strings.Repeat("hi "+name+" ", times), nil
+    })
+    if err != nil {
+        t.Fatalf("RegisterFunc failed: %s", err)
+    }
+
+    result, err := in.Eval(context.Background(), `greet("bob", 2)`)
+    if err != nil {
+        t.Fatalf("unexpected error: %s", err)
+    }
+    if result.Inspect() != "hi bob hi bob " {
+        t.Errorf("wrong result. got=%q", result.Inspect())
+    }
+
+    _, err = in.Eval(context.Background(), `greet("bob", -1)`)
+    if err == nil || !strings.Contains(err.Error(), "times must not be negative") {
+        t.Errorf("Go error was not returned. got=%v", err)
+    }
+
+    // a panicking Go function fails the script, not the host
+    if err := in.RegisterFunc("at", func(list []int, i int) int { return list[i] }); err != nil {
+        t.Fatalf("RegisterFunc failed: %s", err)
+    }
+    _, err = in.Eval(context.Background(), `try { at([1], 5) } catch (TypeError e) { 0 }`)
+    if !errors.Is(err, object.RuntimeError) || !strings.Contains(err.Error(), "index out of range") {
+        t.Errorf("panic was not turned into a RuntimeError. got=%v", err)
+    }
+
+    if err := in.RegisterFunc("nope", 5); err == nil {
+        t.Errorf("registering a non-function should fail")
+    }
+}
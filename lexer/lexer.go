@@ -2,6 +2,7 @@ package lexer
 
 import (
 	"monkey/token"
+	"strings"
 )
 
 type Lexer struct {
@@ -9,9 +10,24 @@ type Lexer struct {
     position int     // current position in input (pointer to current char)
     readPosition int // points to next character to be parsed
     ch byte          // current character being read
+    line int         // line of the current character
+    tokenLine int    // line of the last token returned
+    comments []Comment
+}
+
+// Comment is a comment running from // to the end of the line. NextToken
+// skips comments, they are only kept for tools like the formatter.
+type Comment struct {
+    Text string      // including the slashes, without the newline
+    Line int
+    Trailing bool    // it follows a token on the same line
 }
 
 func (lex *Lexer) readChar() {
+    if lex.ch == '\n' {
+        lex.line++
+    }
+
     if lex.readPosition >= len(lex.input) {
         lex.ch = 0
     } else {
@@ -35,6 +51,12 @@ func (l *Lexer) NextToken() token.Token {
     var tok token.Token
 
     l.skipWhitespace()
+    for l.ch == '/' && l.peekChar() == '/' {
+        l.readComment()
+        l.skipWhitespace()
+    }
+    line := l.line
+    l.tokenLine = line
 
     switch l.ch {
         // operators
@@ -96,38 +118,52 @@ func (l *Lexer) NextToken() token.Token {
         if isLetter(l.ch) {
             tok.Literal = l.readIdentifier()               // reads rest of the word to identify whether it is a keyword or an identifier
             tok.Type = token.LookupIdentifier(tok.Literal)
+            tok.Line = line
             return tok                                     // return tok here incase of readIdentifier and readNumber so that readChar is not called later
         } else if isDigit(l.ch) {
-            tok.Literal = l.readNumber()
-            tok.Type = token.INT
+            tok.Literal, tok.Type = l.readNumber()
+            tok.Line = line
             return tok
         } else {
             tok = newToken(token.ILLEGAL, l.ch)
         }
     }
 
+    tok.Line = line
     l.readChar()
     return tok
 }
 
+// identifiers start with a letter, digits may follow
 func (l *Lexer) readIdentifier() string {
     position := l.position
 
-    for isLetter(l.ch) {
+    for isLetter(l.ch) || isDigit(l.ch) {
         l.readChar()
     }
 
     return l.input[position : l.position]
 }
 
-func (l *Lexer) readNumber() string {
+// a number with a fractional part like 1.5 is a float, anything else an int
+func (l *Lexer) readNumber() (string, token.TokenType) {
     position := l.position
+    var tokenType token.TokenType = token.INT
 
     for isDigit(l.ch) {
         l.readChar()
     }
 
-    return l.input[position : l.position]
+    if l.ch == '.' && isDigit(l.peekChar()) {
+        tokenType = token.FLOAT
+        l.readChar()
+
+        for isDigit(l.ch) {
+            l.readChar()
+        }
+    }
+
+    return l.input[position : l.position], tokenType
 }
 
 func (l *Lexer) readString() string {
@@ -143,6 +179,21 @@ func (l *Lexer) readString() string {
     return l.input[position : l.position]
 }
 
+// Comments returns the comments skipped so far, in order
+func (l *Lexer) Comments() []Comment {
+    return l.comments
+}
+
+func (l *Lexer) readComment() {
+    position := l.position
+    for l.ch != '\n' && l.ch != 0 {
+        l.readChar()
+    }
+
+    text := strings.TrimRight(l.input[position : l.position], " \t\r")
+    l.comments = append(l.comments, Comment{Text: text, Line: l.line, Trailing: l.tokenLine == l.line})
+}
+
 func (l *Lexer) skipWhitespace() {
     for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' {
         l.readChar()
@@ -153,7 +204,7 @@ func isLetter(ch byte) bool {
     return ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch == '_'
 }
 
-// support for floats, hex, oct and all needed
+// support for hex, oct and all needed
 func isDigit(ch byte) bool {
     return ch >= '0' && ch <= '9'
 }
@@ -163,7 +214,7 @@ func newToken(tokenType token.TokenType, ch byte) token.Token {
 }
 
 func New(input string) *Lexer {
-    l := &Lexer {input: input}
+    l := &Lexer {input: input, line: 1}
     l.readChar()
     return l
 }
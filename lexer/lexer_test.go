@@ -31,6 +31,9 @@ func TestNextToken(t *testing.T) {
 
     [1, 2];
     {"foo": "bar"}
+    3.14 1.x null
+    try catch finally throw
+    _ _1 x2y 3z
     `
 
     tests := []struct {
@@ -130,6 +133,21 @@ func TestNextToken(t *testing.T) {
         {token.STRING, "bar"},
         {token.RBRACE, "}"},
 
+        {token.FLOAT, "3.14"},
+        {token.INT, "1"},
+        {token.ILLEGAL, "."},
+        {token.IDENT, "x"},
+        {token.NULL, "null"},
+        {token.TRY, "try"},
+        {token.CATCH, "catch"},
+        {token.FINALLY, "finally"},
+        {token.THROW, "throw"},
+        {token.IDENT, "_"},
+        {token.IDENT, "_1"},
+        {token.IDENT, "x2y"},
+        {token.INT, "3"},
+        {token.IDENT, "z"},
+
         {token.EOF, ""},
     }
 
@@ -147,3 +165,99 @@ func TestNextToken(t *testing.T) {
         }
     }
 }
+
+// digits may follow the first character of an identifier, but not start one
+func TestIdentifierDigits(t *testing.T) {
+    tests := []struct {
+        input string
+        expected []token.Token
+    }{
+        {"_1", []token.Token{{Type: token.IDENT, Literal: "_1"}}},
+        {"x2y", []token.Token{{Type: token.IDENT, Literal: "x2y"}}},
+        {"x2y+10", []token.Token{{Type: token.IDENT, Literal: "x2y"}, {Type: token.PLUS, Literal: "+"}, {Type: token.INT, Literal: "10"}}},
+        {"let1", []token.Token{{Type: token.IDENT, Literal: "let1"}}},
+        {"2x", []token.Token{{Type: token.INT, Literal: "2"}, {Type: token.IDENT, Literal: "x"}}},
+        {"a1.5", []token.Token{{Type: token.IDENT, Literal: "a1"}, {Type: token.ILLEGAL, Literal: "."}, {Type: token.INT, Literal: "5"}}},
+    }
+
+    for _, tt := range tests {
+        l := New(tt.input)
+        for i, expected := range append(tt.expected, token.Token{Type: token.EOF}) {
+            tok := l.NextToken()
+            if tok.Type != expected.Type || tok.Literal != expected.Literal {
+                t.Errorf("%q: token %d wrong. expected=%s %q, got=%s %q", tt.input, i, expected.Type, expected.Literal, tok.Type, tok.Literal)
+                break
+            }
+        }
+    }
+}
+
+func TestLines(t *testing.T) {
+    input := `let a = 1;
+"two
+lines" a
+
+  fn`
+
+    tests := []struct {
+        expectedLiteral string
+        expectedLine int
+    }{
+        {"let", 1},
+        {"a", 1},
+        {"=", 1},
+        {"1", 1},
+        {";", 1},
+        {"two\nlines", 2},
+        {"a", 3},
+        {"fn", 5},
+        {"", 5},
+    }
+
+    l := New(input)
+
+    for i, tt := range tests {
+        token := l.NextToken()
+
+        if token.Literal != tt.expectedLiteral {
+            t.Fatalf("tests[%d] - wrong literal. Expected: %q but got %q", i, tt.expectedLiteral, token.Literal)
+        }
+
+        if token.Line != tt.expectedLine {
+            t.Fatalf("tests[%d] - wrong line for %q. Expected: %d but got %d", i, token.Literal, tt.expectedLine, token.Line)
+        }
+    }
+}
+
+func TestComments(t *testing.T) {
+    input := `// leading
+let a = 1; // trailing  
+a / 2 // a // b
+//`
+
+    expected := []token.TokenType{token.LET, token.IDENT, token.ASSIGN, token.INT, token.SEMICOLON,
+        token.IDENT, token.SLASH, token.INT, token.EOF}
+
+    l := New(input)
+    for i, tt := range expected {
+        if tok := l.NextToken(); tok.Type != tt {
+            t.Fatalf("tests[%d] - wrong token type. Expected: %q but got %q", i, tt, tok.Type)
+        }
+    }
+
+    comments := []Comment{
+        {"// leading", 1, false},
+        {"// trailing", 2, true},
+        {"// a // b", 3, true},
+        {"//", 4, false},
+    }
+
+    if len(l.Comments()) != len(comments) {
+        t.Fatalf("wrong number of comments. Expected: %d but got %d: %v", len(comments), len(l.Comments()), l.Comments())
+    }
+    for i, c := range comments {
+        if l.Comments()[i] != c {
+            t.Errorf("comments[%d] wrong. Expected: %+v but got %+v", i, c, l.Comments()[i])
+        }
+    }
+}
@@ -0,0 +1,485 @@
+// Package lineedit reads lines from a terminal with editing, history and
+// completion, in the manner of readline. Keys move the cursor like in a
+// shell: the arrow keys, Home and End, Ctrl-A, -E, -B and -F, Ctrl-K, -U and
+// -W delete, Up and Down or Ctrl-P and -N go through the history and Ctrl-R
+// searches it, Tab completes the word before the cursor.
+//
+// When the input is not a terminal, lines are read as they come, so that
+// piped input works unchanged.
+package lineedit
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// ErrInterrupted is returned by ReadLine when Ctrl-C discards the line
+var ErrInterrupted = errors.New("interrupted")
+
+// MaxHistory is how many lines an Editor remembers
+const MaxHistory = 1000
+
+type Editor struct {
+    in *bufio.Reader
+    out io.Writer
+    term *os.File           // the terminal in is read from, nil when not editing
+    history []string        // oldest first
+    historyFile string      // where entered lines are saved, see LoadHistory
+
+    // Complete returns the completions of prefix, the word before the cursor.
+    // A word at the start of the line includes a colon before it, which
+    // commands of the REPL begin with.
+    Complete func(prefix string) []string
+}
+
+// New returns an editor reading keys from in and drawing on out. Lines are
+// only edited if term, the file underlying in, is a terminal.
+func New(in *bufio.Reader, out io.Writer, term *os.File) *Editor {
+    e := &Editor{in: in, out: out}
+    if term != nil && isTerminal(term) {
+        e.term = term
+    }
+    return e
+}
+
+// Editing reports whether lines are edited or read as they come
+func (e *Editor) Editing() bool {
+    return e.term != nil
+}
+
+// ReadLine shows prompt and returns the line entered, without its newline.
+// It returns io.EOF once input runs out, or Ctrl-D is pressed on an empty
+// line, and ErrInterrupted when Ctrl-C is pressed.
+func (e *Editor) ReadLine(prompt string) (string, error) {
+    if e.term == nil {
+        io.WriteString(e.out, prompt)
+
+        line, err := e.in.ReadString('\n')
+        if err != nil && line == "" {
+            return "", err
+        }
+        return strings.TrimRight(line, "\r\n"), nil
+    }
+
+    restore, err := makeRaw(e.term)
+    if err != nil {
+        return "", err
+    }
+    defer restore()
+
+    return e.edit(prompt)
+}
+
+// AddHistory remembers line for the history keys and appends it to the
+// history file, if any. Empty lines and repetitions are skipped.
+func (e *Editor) AddHistory(line string) {
+    if !e.remember(line) || e.historyFile == "" {
+        return
+    }
+
+    f, err := os.OpenFile(e.historyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+    if err != nil {
+        return
+    }
+    defer f.Close()
+    fmt.Fprintln(f, line)
+}
+
+// LoadHistory reads the history saved in path, one line per entry, and makes
+// AddHistory append to it. A missing file is not an error.
+func (e *Editor) LoadHistory(path string) error {
+    e.historyFile = path
+
+    data, err := os.ReadFile(path)
+    if errors.Is(err, os.ErrNotExist) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+
+    lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+    for _, line := range lines {
+        e.remember(line)
+    }
+
+    // keep the file from growing forever
+    if len(lines) > MaxHistory {
+        return os.WriteFile(path, []byte(strings.Join(e.history, "\n") + "\n"), 0600)
+    }
+    return nil
+}
+
+// remember adds line to the history unless it is empty or repeats the last
+// line, reporting whether it did
+func (e *Editor) remember(line string) bool {
+    if strings.TrimSpace(line) == "" || len(e.history) > 0 && e.history[len(e.history)-1] == line {
+        return false
+    }
+
+    e.history = append(e.history, line)
+    if len(e.history) > MaxHistory {
+        e.history = e.history[len(e.history)-MaxHistory:]
+    }
+    return true
+}
+
+// keys other than runes are negative
+type key rune
+
+const (
+    keyUnknown key = -1 - iota
+    keyUp
+    keyDown
+    keyLeft
+    keyRight
+    keyHome
+    keyEnd
+    keyDelete
+)
+
+const (
+    ctrlA key = iota + 1
+    ctrlB
+    ctrlC
+    ctrlD
+    ctrlE
+    ctrlF
+    ctrlG
+    ctrlH
+    tab
+    ctrlJ
+    ctrlK
+    ctrlL
+    enter
+    ctrlN
+    _
+    ctrlP
+    _
+    ctrlR
+    _
+    _
+    ctrlU
+    _
+    ctrlW
+    _
+    _
+    _
+    escape
+    backspace key = 127
+)
+
+// readKey reads a key, decoding the escape sequences sent for special keys
+func (e *Editor) readKey() (key, error) {
+    r, _, err := e.in.ReadRune()
+    if err != nil {
+        return 0, err
+    }
+    if r != rune(escape) {
+        return key(r), nil
+    }
+
+    // a lone escape, not followed by a sequence already sent
+    if e.in.Buffered() == 0 {
+        return escape, nil
+    }
+
+    intro, _ := e.in.ReadByte()
+    if intro != '[' && intro != 'O' {
+        return keyUnknown, nil
+    }
+
+    // parameters, then the final byte
+    var params []byte
+    for {
+        b, err := e.in.ReadByte()
+        if err != nil {
+            return 0, err
+        }
+        if b >= 0x40 && b <= 0x7e {
+            return decodeSequence(string(params), b), nil
+        }
+        params = append(params, b)
+    }
+}
+
+func decodeSequence(params string, final byte) key {
+    switch final {
+    case 'A':
+        return keyUp
+    case 'B':
+        return keyDown
+    case 'C':
+        return keyRight
+    case 'D':
+        return keyLeft
+    case 'H':
+        return keyHome
+    case 'F':
+        return keyEnd
+    case '~':
+        switch params {
+        case "1", "7":
+            return keyHome
+        case "4", "8":
+            return keyEnd
+        case "3":
+            return keyDelete
+        }
+    }
+    return keyUnknown
+}
+
+// edit reads a line key by key, keeping the line on screen up to date
+func (e *Editor) edit(prompt string) (string, error) {
+    var buf []rune
+    pos := 0
+
+    // history[index] is shown, or the line being entered at len(history)
+    index := len(e.history)
+    current := ""
+    lastTab := false
+
+    show := func(line string) {
+        buf = []rune(line)
+        pos = len(buf)
+    }
+
+    e.refresh(prompt, buf, pos)
+
+    for {
+        k, err := e.readKey()
+        if err != nil {
+            return "", err
+        }
+
+        if k == ctrlR {
+            var line string
+            line, k, err = e.search(string(buf))
+            if err != nil {
+                return "", err
+            }
+            show(line)
+            e.refresh(prompt, buf, pos)
+            if k == 0 {
+                continue
+            }
+        }
+
+        completing := false
+
+        switch k {
+        case enter, ctrlJ:
+            io.WriteString(e.out, "\n")
+            return string(buf), nil
+        case ctrlC:
+            io.WriteString(e.out, "^C\n")
+            return "", ErrInterrupted
+        case ctrlD:
+            if len(buf) == 0 {
+                io.WriteString(e.out, "\n")
+                return "", io.EOF
+            }
+            if pos < len(buf) {
+                buf = append(buf[:pos], buf[pos+1:]...)
+            }
+        case keyDelete:
+            if pos < len(buf) {
+                buf = append(buf[:pos], buf[pos+1:]...)
+            }
+        case backspace, ctrlH:
+            if pos > 0 {
+                buf = append(buf[:pos-1], buf[pos:]...)
+                pos--
+            }
+        case keyLeft, ctrlB:
+            if pos > 0 {
+                pos--
+            }
+        case keyRight, ctrlF:
+            if pos < len(buf) {
+                pos++
+            }
+        case keyHome, ctrlA:
+            pos = 0
+        case keyEnd, ctrlE:
+            pos = len(buf)
+        case ctrlK:
+            buf = buf[:pos]
+        case ctrlU:
+            buf = append([]rune{}, buf[pos:]...)
+            pos = 0
+        case ctrlW:
+            start := pos
+            for start > 0 && buf[start-1] == ' ' {
+                start--
+            }
+            for start > 0 && buf[start-1] != ' ' {
+                start--
+            }
+            buf = append(buf[:start], buf[pos:]...)
+            pos = start
+        case ctrlL:
+            io.WriteString(e.out, "\x1b[H\x1b[2J")
+        case keyUp, ctrlP:
+            if index > 0 {
+                if index == len(e.history) {
+                    current = string(buf)
+                }
+                index--
+                show(e.history[index])
+            }
+        case keyDown, ctrlN:
+            if index < len(e.history) {
+                index++
+                if index == len(e.history) {
+                    show(current)
+                } else {
+                    show(e.history[index])
+                }
+            }
+        case tab:
+            completing = true
+            buf, pos = e.complete(buf, pos, lastTab)
+        default:
+            if k >= ' ' {
+                buf = append(buf[:pos], append([]rune{rune(k)}, buf[pos:]...)...)
+                pos++
+            }
+        }
+
+        lastTab = completing
+        e.refresh(prompt, buf, pos)
+    }
+}
+
+// refresh redraws the line and puts the cursor at pos
+func (e *Editor) refresh(prompt string, buf []rune, pos int) {
+    fmt.Fprintf(e.out, "\r%s%s\x1b[K", prompt, string(buf))
+    if back := len(buf) - pos; back > 0 {
+        fmt.Fprintf(e.out, "\x1b[%dD", back)
+    }
+}
+
+// search is Ctrl-R: it finds the newest line in the history containing what
+// is typed, Ctrl-R again finds older ones. It returns the line to continue
+// with, and the key that ended the search for edit to handle, 0 if none.
+// Enter accepts the match as the line, Ctrl-G or Ctrl-C go back to original.
+func (e *Editor) search(original string) (string, key, error) {
+    var query []rune
+    match := len(e.history)   // the index of the line found
+
+    find := func(from int) {
+        for i := from; i >= 0; i-- {
+            if strings.Contains(e.history[i], string(query)) {
+                match = i
+                return
+            }
+        }
+    }
+
+    for {
+        found := ""
+        if match < len(e.history) {
+            found = e.history[match]
+        }
+        fmt.Fprintf(e.out, "\r(reverse-i-search)`%s': %s\x1b[K", string(query), found)
+
+        k, err := e.readKey()
+        if err != nil {
+            return "", 0, err
+        }
+
+        switch {
+        case k == ctrlR:
+            find(match - 1)
+        case k == ctrlG || k == ctrlC:
+            return original, 0, nil
+        case k == backspace || k == ctrlH:
+            if len(query) > 0 {
+                query = query[:len(query)-1]
+                match = len(e.history)
+                find(len(e.history) - 1)
+            }
+        case k >= ' ':
+            query = append(query, rune(k))
+            if match == len(e.history) {
+                find(len(e.history) - 1)
+            } else {
+                find(match)
+            }
+        default:
+            if match == len(e.history) {
+                return original, k, nil
+            }
+            if k == escape {
+                k = 0
+            }
+            return found, k, nil
+        }
+    }
+}
+
+// complete completes the word before pos with the candidates from Complete:
+// a single one is inserted, for several their common prefix. When that adds
+// nothing, a second Tab in a row lists them.
+func (e *Editor) complete(buf []rune, pos int, lastTab bool) ([]rune, int) {
+    start := pos
+    for start > 0 && isWordRune(buf[start-1]) {
+        start--
+    }
+    if start > 0 && buf[start-1] == ':' && strings.TrimSpace(string(buf[:start-1])) == "" {
+        start--
+    }
+    prefix := string(buf[start:pos])
+    if prefix == "" || e.Complete == nil {
+        return buf, pos
+    }
+
+    candidates := []string{}
+    seen := make(map[string]bool)
+    for _, candidate := range e.Complete(prefix) {
+        if strings.HasPrefix(candidate, prefix) && !seen[candidate] {
+            seen[candidate] = true
+            candidates = append(candidates, candidate)
+        }
+    }
+    sort.Strings(candidates)
+
+    if len(candidates) == 0 {
+        io.WriteString(e.out, "\a")
+        return buf, pos
+    }
+
+    completion := commonPrefix(candidates)
+    if len(completion) > len(prefix) {
+        insert := []rune(completion[len(prefix):])
+        buf = append(buf[:pos], append(insert, buf[pos:]...)...)
+        return buf, pos + len(insert)
+    }
+
+    if lastTab && len(candidates) > 1 {
+        fmt.Fprintf(e.out, "\n%s\n", strings.Join(candidates, "  "))
+    }
+    return buf, pos
+}
+
+func commonPrefix(words []string) string {
+    prefix := words[0]
+    for _, word := range words[1:] {
+        for !strings.HasPrefix(word, prefix) {
+            prefix = prefix[:len(prefix)-1]
+        }
+    }
+    return prefix
+}
+
+func isWordRune(r rune) bool {
+    return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
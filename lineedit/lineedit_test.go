@@ -0,0 +1,206 @@
+package lineedit
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testEditor(keys string, history ...string) (*Editor, *bytes.Buffer) {
+    var out bytes.Buffer
+    e := &Editor{in: bufio.NewReader(strings.NewReader(keys)), out: &out, history: history}
+    e.Complete = func(prefix string) []string {
+        return []string{"len", "print", "puts", "push", "puts", ":help"}
+    }
+    return e, &out
+}
+
+func TestEdit(t *testing.T) {
+    tests := []struct {
+        keys string
+        history []string
+        expected string
+    }{
+        {"abc\r", nil, "abc"},
+        {"abc\n", nil, "abc"},
+        {"héllo\r", nil, "héllo"},
+        {"abc\x1b[D\x1b[DX\r", nil, "aXbc"},
+        {"abc\x1b[D\x1b[D\x1b[CX\r", nil, "abXc"},
+        {"abc\x01X\x05Y\r", nil, "XabcY"},
+        {"abc\x1b[HX\x1b[FY\r", nil, "XabcY"},
+        {"abc\x1b[1~X\x1b[4~Y\r", nil, "XabcY"},
+        {"abc\x02\x02\x06X\r", nil, "abXc"},
+        {"abc\x7f\r", nil, "ab"},
+        {"abc\x08\x08\r", nil, "a"},
+        {"\x7fa\r", nil, "a"},
+        {"ab\x1b[D\x1b[3~\r", nil, "a"},
+        {"ab\x01\x04\r", nil, "b"},
+        {"hello big world\x17\r", nil, "hello big "},
+        {"hello big world  \x17\x17\r", nil, "hello "},
+        {"abc\x1b[D\x0b\r", nil, "ab"},
+        {"abc\x1b[D\x15\r", nil, "c"},
+        {"a\x1b[Zb\r", nil, "ab"},
+
+        // history
+        {"\x1b[A\r", []string{"one", "two"}, "two"},
+        {"\x1b[A\x1b[A\x1b[A\r", []string{"one", "two"}, "one"},
+        {"x\x1b[A\x1b[B\r", []string{"one", "two"}, "x"},
+        {"\x10\x10\x0e\r", []string{"one", "two"}, "two"},
+        {"\x1b[A!\r", []string{"one", "two"}, "two!"},
+
+        // reverse search
+        {"\x12let\r", []string{"let a = 1", "puts(a)", "let b = 2"}, "let b = 2"},
+        {"\x12let\x12\r", []string{"let a = 1", "puts(a)", "let b = 2"}, "let a = 1"},
+        {"\x12let a\x7f\x7f\r", []string{"let a = 1", "puts(a)", "let b = 2"}, "let b = 2"},
+        {"\x12put\x1b[D!\r", []string{"let a = 1", "puts(a)", "let b = 2"}, "puts(a!)"},
+        {"q\x12zzz\x07\r", []string{"let a = 1"}, "q"},
+        {"q\x12let\x03\r", []string{"let a = 1"}, "q"},
+        {"q\x12zzz\r", []string{"let a = 1"}, "q"},
+
+        // completion
+        {"le\t(x)\r", nil, "len(x)"},
+        {"pri\t\r", nil, "print"},
+        {"pu\t\r", nil, "pu"},
+        {"x = pus\t\r", nil, "x = push"},
+        {"le\x01\t\r", nil, "le"},
+        {"x(le\x1b[D\t\r", nil, "x(lene"},
+        {"zz\t\r", nil, "zz"},
+        {" :he\t\r", nil, " :help"},
+        {"a :he\t\r", nil, "a :he"},
+    }
+
+    for i, tt := range tests {
+        e, _ := testEditor(tt.keys, tt.history...)
+
+        line, err := e.edit("> ")
+        if err != nil {
+            t.Errorf("tests[%d] - unexpected error: %s", i, err)
+            continue
+        }
+        if line != tt.expected {
+            t.Errorf("tests[%d] - wrong line for %q. expected=%q, got=%q", i, tt.keys, tt.expected, line)
+        }
+    }
+}
+
+func TestEditEndings(t *testing.T) {
+    tests := []struct {
+        keys string
+        expected error
+    }{
+        {"abc\x03", ErrInterrupted},
+        {"\x04", io.EOF},
+        {"abc", io.EOF},
+    }
+
+    for _, tt := range tests {
+        e, _ := testEditor(tt.keys)
+        if _, err := e.edit("> "); !errors.Is(err, tt.expected) {
+            t.Errorf("wrong error for %q. expected=%v, got=%v", tt.keys, tt.expected, err)
+        }
+    }
+}
+
+func TestEditDrawsLine(t *testing.T) {
+    e, out := testEditor("ab\x1b[Dc\r")
+    if _, err := e.edit("> "); err != nil {
+        t.Fatalf("unexpected error: %s", err)
+    }
+
+    // every key redraws the line and moves the cursor back to where it is
+    expected := "\r> \x1b[K" + "\r> a\x1b[K" + "\r> ab\x1b[K" + "\r> ab\x1b[K\x1b[1D" + "\r> acb\x1b[K\x1b[1D" + "\n"
+    if out.String() != expected {
+        t.Errorf("wrong output.\nexpected=%q\ngot=%q", expected, out.String())
+    }
+
+    // a second tab lists the candidates
+    e, out = testEditor("pu\t\t\r")
+    if _, err := e.edit("> "); err != nil {
+        t.Fatalf("unexpected error: %s", err)
+    }
+    if !strings.Contains(out.String(), "\npush  puts\n") {
+        t.Errorf("candidates not listed. got=%q", out.String())
+    }
+}
+
+func TestReadLineWithoutTerminal(t *testing.T) {
+    var out bytes.Buffer
+    e := New(bufio.NewReader(strings.NewReader("one\r\ntwo\nthree")), &out, nil)
+
+    if e.Editing() {
+        t.Fatalf("editing without a terminal")
+    }
+
+    for _, expected := range []string{"one", "two", "three"} {
+        line, err := e.ReadLine("> ")
+        if err != nil || line != expected {
+            t.Errorf("wrong line. expected=%q, got=%q (%v)", expected, line, err)
+        }
+    }
+
+    if _, err := e.ReadLine("> "); err != io.EOF {
+        t.Errorf("expected EOF, got %v", err)
+    }
+    if out.String() != "> > > > " {
+        t.Errorf("wrong prompts. got=%q", out.String())
+    }
+}
+
+func TestHistoryFile(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "history")
+    if err := os.WriteFile(path, []byte("one\ntwo\n"), 0600); err != nil {
+        t.Fatal(err)
+    }
+
+    e, _ := testEditor("")
+    if err := e.LoadHistory(path); err != nil {
+        t.Fatalf("unexpected error: %s", err)
+    }
+
+    e.AddHistory("three")
+    e.AddHistory("three")
+    e.AddHistory("  ")
+
+    if strings.Join(e.history, ",") != "one,two,three" {
+        t.Errorf("wrong history. got=%q", e.history)
+    }
+    if data, _ := os.ReadFile(path); string(data) != "one\ntwo\nthree\n" {
+        t.Errorf("wrong history file. got=%q", data)
+    }
+
+    // long histories are cut down to the latest lines
+    var long strings.Builder
+    for i := 0; i < MaxHistory + 5; i++ {
+        fmt.Fprintf(&long, "line %d\n", i)
+    }
+    if err := os.WriteFile(path, []byte(long.String()), 0600); err != nil {
+        t.Fatal(err)
+    }
+
+    e, _ = testEditor("")
+    if err := e.LoadHistory(path); err != nil {
+        t.Fatalf("unexpected error: %s", err)
+    }
+    data, _ := os.ReadFile(path)
+    lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+    if len(e.history) != MaxHistory || len(lines) != MaxHistory || lines[0] != "line 5" {
+        t.Errorf("history not cut down. got %d lines in memory, %d in the file starting with %q", len(e.history), len(lines), lines[0])
+    }
+
+    // a missing file is created on the first line added
+    e, _ = testEditor("")
+    missing := filepath.Join(t.TempDir(), "new")
+    if err := e.LoadHistory(missing); err != nil {
+        t.Fatalf("unexpected error: %s", err)
+    }
+    e.AddHistory("first")
+    if data, _ := os.ReadFile(missing); string(data) != "first\n" {
+        t.Errorf("wrong history file. got=%q", data)
+    }
+}
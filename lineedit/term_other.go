@@ -0,0 +1,18 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package lineedit
+
+import (
+	"errors"
+	"os"
+)
+
+// lines are only edited on unix terminals, elsewhere they are read as they come
+
+func isTerminal(f *os.File) bool {
+    return false
+}
+
+func makeRaw(f *os.File) (func(), error) {
+    return nil, errors.New("line editing is not supported on this platform")
+}
@@ -0,0 +1,54 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package lineedit
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+func getTermios(f *os.File) (*syscall.Termios, error) {
+    var t syscall.Termios
+    _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), ioctlGetTermios, uintptr(unsafe.Pointer(&t)))
+    if errno != 0 {
+        return nil, errno
+    }
+    return &t, nil
+}
+
+func setTermios(f *os.File, t *syscall.Termios) error {
+    _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), ioctlSetTermios, uintptr(unsafe.Pointer(t)))
+    if errno != 0 {
+        return errno
+    }
+    return nil
+}
+
+func isTerminal(f *os.File) bool {
+    _, err := getTermios(f)
+    return err == nil
+}
+
+// makeRaw hands every key to the editor as it is pressed, without echoing
+// it. Output processing stays on, so that newlines still return the cursor.
+func makeRaw(f *os.File) (func(), error) {
+    old, err := getTermios(f)
+    if err != nil {
+        return nil, err
+    }
+
+    raw := *old
+    raw.Iflag &^= syscall.ICRNL | syscall.INLCR | syscall.IGNCR | syscall.IXON | syscall.ISTRIP
+    raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+    raw.Cc[syscall.VMIN] = 1
+    raw.Cc[syscall.VTIME] = 0
+
+    if err := setTermios(f, &raw); err != nil {
+        return nil, err
+    }
+
+    return func() {
+        setTermios(f, old)
+    }, nil
+}
@@ -0,0 +1,606 @@
+package object
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+var (
+    objectType = reflect.TypeOf((*Object)(nil)).Elem()
+    errorType = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// FromGo converts a Go value to the matching Monkey object:
+//
+//  nil, nil pointers         null
+//  bool                      BOOLEAN
+//  ints, uints               INTEGER
+//  floats                    FLOAT
+//  string, []byte            STRING
+//  slices, arrays            ARRAY
+//  maps                      HASH, with keys sorted
+//  structs                   HASH, see below
+//  funcs                     BUILTIN, see NewBuiltin
+//  Object                    itself
+//
+// Exported struct fields become hash entries in declaration order. A
+// `monkey:"name"` tag renames a field and `monkey:"-"` skips it. Values that
+// contain themselves cannot be converted and are reported as an error.
+func FromGo(v any) (Object, error) {
+    if v == nil {
+        return NULL, nil
+    }
+    // fromValue passes objects on, turning nil pointers to them into null
+    return fromValue(reflect.ValueOf(v), make(map[goRef]bool))
+}
+
+// goRef identifies a pointer, map or slice being converted
+type goRef struct {
+    ptr uintptr
+    typ reflect.Type
+    len int
+}
+
+// fromValue converts v. path holds the references being converted further up,
+// so that cyclic values are reported instead of recursing until the Go stack
+// runs out.
+func fromValue(v reflect.Value, path map[goRef]bool) (Object, error) {
+    if v.Type().Implements(objectType) {
+        if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+            return NULL, nil
+        }
+        return v.Interface().(Object), nil
+    }
+
+    switch v.Kind() {
+    case reflect.Bool:
+        if v.Bool() {
+            return TRUE, nil
+        }
+        return FALSE, nil
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+        return &Integer{Value: v.Int()}, nil
+    case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+        if v.Uint() > math.MaxInt64 {
+            return nil, fmt.Errorf("object: %d overflows INTEGER", v.Uint())
+        }
+        return &Integer{Value: int64(v.Uint())}, nil
+    case reflect.Float32, reflect.Float64:
+        return &Float{Value: v.Float()}, nil
+    case reflect.String:
+        return &String{Value: v.String()}, nil
+    case reflect.Interface:
+        if v.IsNil() {
+            return NULL, nil
+        }
+        return fromValue(v.Elem(), path)
+    case reflect.Ptr:
+        if v.IsNil() {
+            return NULL, nil
+        }
+        ref, err := enter(v, path)
+        if err != nil {
+            return nil, err
+        }
+        defer delete(path, ref)
+        return fromValue(v.Elem(), path)
+    case reflect.Slice:
+        if v.IsNil() {
+            return NULL, nil
+        }
+        if v.Type().Elem().Kind() == reflect.Uint8 {
+            return &String{Value: string(v.Bytes())}, nil
+        }
+        ref, err := enter(v, path)
+        if err != nil {
+            return nil, err
+        }
+        defer delete(path, ref)
+        return fromList(v, path)
+    case reflect.Array:
+        return fromList(v, path)
+    case reflect.Map:
+        if v.IsNil() {
+            return NULL, nil
+        }
+        ref, err := enter(v, path)
+        if err != nil {
+            return nil, err
+        }
+        defer delete(path, ref)
+        return fromMap(v, path)
+    case reflect.Struct:
+        return fromStruct(v, path)
+    case reflect.Func:
+        if v.IsNil() {
+            return NULL, nil
+        }
+        return NewBuiltin("", v.Interface())
+    default:
+        return nil, fmt.Errorf("object: cannot convert %s to a Monkey value", v.Type())
+    }
+}
+
+// enter adds the reference v to path, failing if it is already there
+func enter(v reflect.Value, path map[goRef]bool) (goRef, error) {
+    ref := goRef{ptr: v.Pointer(), typ: v.Type()}
+    if v.Kind() == reflect.Slice {
+        ref.len = v.Len()
+    }
+
+    if path[ref] {
+        return ref, fmt.Errorf("object: cannot convert cyclic value of type %s", v.Type())
+    }
+    path[ref] = true
+
+    return ref, nil
+}
+
+func fromList(v reflect.Value, path map[goRef]bool) (Object, error) {
+    elems := make([]Object, v.Len())
+    for i := range elems {
+        elem, err := fromValue(v.Index(i), path)
+        if err != nil {
+            return nil, err
+        }
+        elems[i] = elem
+    }
+    return &Array{Elements: elems}, nil
+}
+
+func fromMap(v reflect.Value, path map[goRef]bool) (Object, error) {
+    pairs := make([]HashPair, 0, v.Len())
+
+    iter := v.MapRange()
+    for iter.Next() {
+        key, err := fromValue(iter.Key(), path)
+        if err != nil {
+            return nil, err
+        }
+        if _, ok := AsHashable(key); !ok {
+            return nil, fmt.Errorf("object: unusable as hash key: %s", key.Type())
+        }
+
+        value, err := fromValue(iter.Value(), path)
+        if err != nil {
+            return nil, err
+        }
+
+        pairs = append(pairs, HashPair{Key: key, Value: value})
+    }
+
+    // Go maps have no order, sort the keys so the result is deterministic
+    sort.Slice(pairs, func(i, j int) bool {
+        return keyLess(pairs[i].Key, pairs[j].Key)
+    })
+
+    hash := NewHash()
+    for _, pair := range pairs {
+        hash.Set(pair.Key.(Hashable), pair.Value)
+    }
+    return hash, nil
+}
+
+func keyLess(a, b Object) bool {
+    if a.Type() != b.Type() {
+        return a.Type() < b.Type()
+    }
+
+    switch a := a.(type) {
+    case *Integer:
+        return a.Value < b.(*Integer).Value
+    case *String:
+        return a.Value < b.(*String).Value
+    default:
+        return a.Inspect() < b.Inspect()
+    }
+}
+
+func fromStruct(v reflect.Value, path map[goRef]bool) (Object, error) {
+    hash := NewHash()
+
+    t := v.Type()
+    for i := 0; i < t.NumField(); i++ {
+        name, ok := fieldName(t.Field(i))
+        if !ok {
+            continue
+        }
+
+        value, err := fromValue(v.Field(i), path)
+        if err != nil {
+            return nil, fmt.Errorf("object: field %s: %w", t.Field(i).Name, err)
+        }
+
+        hash.Set(&String{Value: name}, value)
+    }
+
+    return hash, nil
+}
+
+// fieldName returns the hash key of a struct field, or false if the field is
+// not converted at all
+func fieldName(field reflect.StructField) (string, bool) {
+    if !field.IsExported() {
+        return "", false
+    }
+
+    tag := field.Tag.Get("monkey")
+    if tag == "-" {
+        return "", false
+    }
+    if tag != "" {
+        return tag, true
+    }
+
+    return field.Name, true
+}
+
+// ToGo stores the Go equivalent of obj in the value pointed to by target,
+// reversing FromGo. Struct fields are matched by their tag or name, ignoring
+// case. When target points to an empty interface, INTEGER becomes int64,
+// FLOAT float64, ARRAY and SET []any and HASH map[string]any (or map[any]any
+// when some key is not a string). A nil obj is an error, use NULL for null.
+func ToGo(obj Object, target any) error {
+    if obj == nil {
+        return errors.New("object: ToGo needs an object, got nil")
+    }
+
+    v := reflect.ValueOf(target)
+    if v.Kind() != reflect.Ptr || v.IsNil() {
+        return errors.New("object: ToGo target must be a non-nil pointer")
+    }
+
+    return toValue(obj, v.Elem())
+}
+
+func toValue(obj Object, v reflect.Value) error {
+    t := v.Type()
+
+    // targets like Object or *Hash take the object itself, but the empty
+    // interface gets a native Go value
+    objValue := reflect.ValueOf(obj)
+    emptyInterface := t.Kind() == reflect.Interface && t.NumMethod() == 0
+    if objValue.Type().AssignableTo(t) && !emptyInterface {
+        v.Set(objValue)
+        return nil
+    }
+
+    if obj.Type() == NULL_OBJ {
+        v.Set(reflect.Zero(t))
+        return nil
+    }
+
+    switch t.Kind() {
+    case reflect.Interface:
+        if t.NumMethod() != 0 {
+            break
+        }
+        native, err := toNative(obj)
+        if err != nil {
+            return err
+        }
+        if native == nil {
+            v.Set(reflect.Zero(t))
+        } else {
+            v.Set(reflect.ValueOf(native))
+        }
+        return nil
+    case reflect.Ptr:
+        elem := reflect.New(t.Elem())
+        if err := toValue(obj, elem.Elem()); err != nil {
+            return err
+        }
+        v.Set(elem)
+        return nil
+    case reflect.Bool:
+        if b, ok := obj.(*Boolean); ok {
+            v.SetBool(b.Value)
+            return nil
+        }
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+        if n, ok := toInt(obj); ok {
+            if v.OverflowInt(n) {
+                return fmt.Errorf("object: %d overflows %s", n, t)
+            }
+            v.SetInt(n)
+            return nil
+        }
+    case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+        if n, ok := toInt(obj); ok {
+            if n < 0 || v.OverflowUint(uint64(n)) {
+                return fmt.Errorf("object: %d overflows %s", n, t)
+            }
+            v.SetUint(uint64(n))
+            return nil
+        }
+    case reflect.Float32, reflect.Float64:
+        switch n := obj.(type) {
+        case *Float:
+            v.SetFloat(n.Value)
+            return nil
+        case *Integer:
+            v.SetFloat(float64(n.Value))
+            return nil
+        }
+    case reflect.String:
+        if s, ok := obj.(*String); ok {
+            v.SetString(s.Value)
+            return nil
+        }
+    case reflect.Slice:
+        if s, ok := obj.(*String); ok && t.Elem().Kind() == reflect.Uint8 {
+            v.SetBytes([]byte(s.Value))
+            return nil
+        }
+        if elems, ok := listElements(obj); ok {
+            slice := reflect.MakeSlice(t, len(elems), len(elems))
+            for i, elem := range elems {
+                if err := toValue(elem, slice.Index(i)); err != nil {
+                    return err
+                }
+            }
+            v.Set(slice)
+            return nil
+        }
+    case reflect.Array:
+        if elems, ok := listElements(obj); ok {
+            if len(elems) != t.Len() {
+                return fmt.Errorf("object: cannot convert ARRAY of length %d to %s", len(elems), t)
+            }
+            for i, elem := range elems {
+                if err := toValue(elem, v.Index(i)); err != nil {
+                    return err
+                }
+            }
+            return nil
+        }
+    case reflect.Map:
+        if hash, ok := obj.(*Hash); ok {
+            m := reflect.MakeMapWithSize(t, hash.Len())
+            for _, pair := range hash.OrderedPairs() {
+                key := reflect.New(t.Key()).Elem()
+                if err := toValue(pair.Key, key); err != nil {
+                    return err
+                }
+                value := reflect.New(t.Elem()).Elem()
+                if err := toValue(pair.Value, value); err != nil {
+                    return err
+                }
+                m.SetMapIndex(key, value)
+            }
+            v.Set(m)
+            return nil
+        }
+    case reflect.Struct:
+        if hash, ok := obj.(*Hash); ok {
+            return toStruct(hash, v)
+        }
+    case reflect.Func:
+        if fn, ok := obj.(*BuiltIn); ok && fn.goFunc.IsValid() && fn.goFunc.Type().AssignableTo(t) {
+            v.Set(fn.goFunc)
+            return nil
+        }
+    }
+
+    return fmt.Errorf("object: cannot convert %s to %s", obj.Type(), t)
+}
+
+func toInt(obj Object) (int64, bool) {
+    switch n := obj.(type) {
+    case *Integer:
+        return n.Value, true
+    case *Float:
+        // only floats without a fractional part, anything else would lose data
+        if n.Value == math.Trunc(n.Value) && n.Value >= math.MinInt64 && n.Value < math.MaxInt64 {
+            return int64(n.Value), true
+        }
+    }
+    return 0, false
+}
+
+func listElements(obj Object) ([]Object, bool) {
+    switch obj := obj.(type) {
+    case *Array:
+        return obj.Elements, true
+    case *Set:
+        return obj.Elements(), true
+    }
+    return nil, false
+}
+
+func toStruct(hash *Hash, v reflect.Value) error {
+    t := v.Type()
+
+    for _, pair := range hash.OrderedPairs() {
+        key, ok := pair.Key.(*String)
+        if !ok {
+            continue
+        }
+
+        for i := 0; i < t.NumField(); i++ {
+            name, ok := fieldName(t.Field(i))
+            if !ok || !strings.EqualFold(name, key.Value) {
+                continue
+            }
+
+            if err := toValue(pair.Value, v.Field(i)); err != nil {
+                return fmt.Errorf("object: field %s: %w", t.Field(i).Name, err)
+            }
+            break
+        }
+    }
+
+    return nil
+}
+
+func toNative(obj Object) (any, error) {
+    switch obj := obj.(type) {
+    case *Null:
+        return nil, nil
+    case *Boolean:
+        return obj.Value, nil
+    case *Integer:
+        return obj.Value, nil
+    case *Float:
+        return obj.Value, nil
+    case *String:
+        return obj.Value, nil
+    case *Array, *Set:
+        elems, _ := listElements(obj)
+        natives := make([]any, len(elems))
+        for i, elem := range elems {
+            native, err := toNative(elem)
+            if err != nil {
+                return nil, err
+            }
+            natives[i] = native
+        }
+        return natives, nil
+    case *Hash:
+        stringKeys := true
+        for _, pair := range obj.order {
+            if pair.Key.Type() != STRING_OBJ {
+                stringKeys = false
+                break
+            }
+        }
+
+        if stringKeys {
+            m := make(map[string]any, obj.Len())
+            for _, pair := range obj.order {
+                value, err := toNative(pair.Value)
+                if err != nil {
+                    return nil, err
+                }
+                m[pair.Key.(*String).Value] = value
+            }
+            return m, nil
+        }
+
+        m := make(map[any]any, obj.Len())
+        for _, pair := range obj.order {
+            key, err := toNative(pair.Key)
+            if err != nil {
+                return nil, err
+            }
+            if !reflect.TypeOf(key).Comparable() {
+                return nil, fmt.Errorf("object: cannot use %s as a Go map key", pair.Key.Type())
+            }
+            value, err := toNative(pair.Value)
+            if err != nil {
+                return nil, err
+            }
+            m[key] = value
+        }
+        return m, nil
+    default:
+        // functions and the like have no Go equivalent, hand them over as is
+        return obj, nil
+    }
+}
+
+// NewBuiltin wraps an arbitrary Go function so that scripts can call it.
+// Arguments are converted with ToGo into the parameter types of fn, and the
+// result with FromGo. fn may return nothing, a value, an error, or a value
+// and an error; a non-nil error is turned into a Monkey error, and so is a
+// panic, as a RuntimeError. name is only used in error messages.
+func NewBuiltin(name string, fn any) (*BuiltIn, error) {
+    v := reflect.ValueOf(fn)
+    if v.Kind() != reflect.Func || v.IsNil() {
+        return nil, fmt.Errorf("object: NewBuiltin needs a function, got %T", fn)
+    }
+
+    t := v.Type()
+    returnsError := t.NumOut() > 0 && t.Out(t.NumOut() - 1) == errorType
+    values := t.NumOut()
+    if returnsError {
+        values--
+    }
+    if values > 1 {
+        return nil, fmt.Errorf("object: NewBuiltin: %s returns too many values", t)
+    }
+
+    if name == "" {
+        name = "builtin"
+    }
+
+    call := func(args ...Object) (result Object) {
+        in, errObj := goArguments(name, t, args)
+        if errObj != nil {
+            return errObj
+        }
+
+        // a bug in fn must not take the host down with it
+        defer func() {
+            if r := recover(); r != nil {
+                result = &Error{Kind: RuntimeError, Message: fmt.Sprintf("`%s` panicked: %v", name, r)}
+            }
+        }()
+
+        out := v.Call(in)
+
+        if returnsError && !out[len(out) - 1].IsNil() {
+            err := out[len(out) - 1].Interface().(error)
+
+            var errObj *Error
+            if errors.As(err, &errObj) {
+                return errObj
+            }
+            return &Error{Kind: RuntimeError, Message: err.Error()}
+        }
+
+        if values == 0 {
+            return NULL
+        }
+
+        result, err := fromValue(out[0], make(map[goRef]bool))
+        if err != nil {
+            return &Error{Kind: TypeError, Message: fmt.Sprintf("result of `%s`: %s", name, err)}
+        }
+        return result
+    }
+
+    return &BuiltIn{Fn: call, goFunc: v}, nil
+}
+
+func goArguments(name string, t reflect.Type, args []Object) ([]reflect.Value, *Error) {
+    want := t.NumIn()
+    if t.IsVariadic() {
+        if len(args) < want - 1 {
+            return nil, &Error{
+                Kind: ArityError,
+                Message: fmt.Sprintf("wrong number of arguments. got=%d, want at least %d", len(args), want - 1),
+            }
+        }
+    } else if len(args) != want {
+        return nil, &Error{
+            Kind: ArityError,
+            Message: fmt.Sprintf("wrong number of arguments. got=%d, want=%d", len(args), want),
+        }
+    }
+
+    in := make([]reflect.Value, len(args))
+    for i, arg := range args {
+        var paramType reflect.Type
+        if t.IsVariadic() && i >= want - 1 {
+            paramType = t.In(want - 1).Elem()
+        } else {
+            paramType = t.In(i)
+        }
+
+        param := reflect.New(paramType).Elem()
+        if err := toValue(arg, param); err != nil {
+            return nil, &Error{
+                Kind: TypeError,
+                Message: fmt.Sprintf("argument %d to `%s`: %s", i + 1, name, strings.TrimPrefix(err.Error(), "object: ")),
+            }
+        }
+        in[i] = param
+    }
+
+    return in, nil
+}
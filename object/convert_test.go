@@ -0,0 +1,234 @@
+package object
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type convertPerson struct {
+    Name string `monkey:"name"`
+    Age int `monkey:"age"`
+    Tags []string
+    Secret string `monkey:"-"`
+    hidden int
+}
+
+type convertNode struct {
+    Value int
+    Next *convertNode
+}
+
+func TestFromGo(t *testing.T) {
+    var nilPointer *int
+    five := 5
+
+    tests := []struct {
+        input any
+        expected string
+    }{
+        {nil, "null"},
+        {nilPointer, "null"},
+        {&five, "5"},
+        {true, "true"},
+        {int8(-3), "-3"},
+        {uint32(7), "7"},
+        {1.5, "1.5"},
+        {float32(2), "2.0"},
+        {"hi", "hi"},
+        {[]byte("bytes"), "bytes"},
+        {[]int{1, 2, 3}, "[1, 2, 3]"},
+        {[2]string{"a", "b"}, "[a, b]"},
+        {[]any{1, "a", nil, []int{2}}, "[1, a, null, [2]]"},
+        {map[string]int{"b": 2, "a": 1, "c": 3}, "{a: 1, b: 2, c: 3}"},
+        {map[int]bool{3: true, 1: false}, "{1: false, 3: true}"},
+        {convertPerson{Name: "ann", Age: 30, Tags: []string{"x"}, Secret: "s", hidden: 1}, "{name: ann, age: 30, Tags: [x]}"},
+        {&Integer{Value: 9}, "9"},
+        {(*Hash)(nil), "null"},
+        {[]*Integer{nil}, "[null]"},
+        {[]Object{&String{Value: "o"}, nil}, "[o, null]"},
+    }
+
+    for _, tt := range tests {
+        obj, err := FromGo(tt.input)
+        if err != nil {
+            t.Errorf("FromGo(%#v) failed: %s", tt.input, err)
+            continue
+        }
+        if obj.Inspect() != tt.expected {
+            t.Errorf("FromGo(%#v) wrong. expected=%q, got=%q", tt.input, tt.expected, obj.Inspect())
+        }
+    }
+
+    if _, err := FromGo(make(chan int)); err == nil {
+        t.Errorf("FromGo(chan) should fail")
+    }
+    if _, err := FromGo(uint64(1 << 63)); err == nil {
+        t.Errorf("FromGo(1 << 63) should overflow")
+    }
+
+    // the same value reached twice is fine as long as it doesn't contain itself
+    shared := &convertNode{Value: 1}
+    obj, err := FromGo([]*convertNode{shared, {Value: 2, Next: shared}})
+    if err != nil {
+        t.Fatalf("FromGo(shared) failed: %s", err)
+    }
+    if obj.Inspect() != "[{Value: 1, Next: null}, {Value: 2, Next: {Value: 1, Next: null}}]" {
+        t.Errorf("FromGo(shared) wrong. got=%q", obj.Inspect())
+    }
+
+    loop := &convertNode{Value: 1}
+    loop.Next = loop
+    cyclicSlice := []any{1, nil}
+    cyclicSlice[1] = cyclicSlice
+    cyclicMap := map[string]any{}
+    cyclicMap["self"] = cyclicMap
+
+    for _, cyclic := range []any{loop, cyclicSlice, cyclicMap} {
+        if _, err := FromGo(cyclic); err == nil || !strings.Contains(err.Error(), "cyclic") {
+            t.Errorf("FromGo(%T) should report a cycle. got=%v", cyclic, err)
+        }
+    }
+}
+
+func TestToGo(t *testing.T) {
+    hash := NewHash()
+    hash.Set(&String{Value: "name"}, &String{Value: "ann"})
+    hash.Set(&String{Value: "AGE"}, &Integer{Value: 30})
+    hash.Set(&String{Value: "tags"}, &Array{Elements: []Object{&String{Value: "x"}}})
+    hash.Set(&String{Value: "unknown"}, TRUE)
+
+    var person convertPerson
+    if err := ToGo(hash, &person); err != nil {
+        t.Fatalf("ToGo(struct) failed: %s", err)
+    }
+    expected := convertPerson{Name: "ann", Age: 30, Tags: []string{"x"}}
+    if !reflect.DeepEqual(person, expected) {
+        t.Errorf("ToGo(struct) wrong. got=%+v", person)
+    }
+
+    var n int
+    if err := ToGo(&Integer{Value: 42}, &n); err != nil || n != 42 {
+        t.Errorf("ToGo(int) wrong. got=%d, %v", n, err)
+    }
+
+    var f float64
+    if err := ToGo(&Integer{Value: 2}, &f); err != nil || f != 2 {
+        t.Errorf("ToGo(float64) wrong. got=%f, %v", f, err)
+    }
+
+    var small int8
+    if err := ToGo(&Integer{Value: 300}, &small); err == nil {
+        t.Errorf("ToGo(int8) should overflow")
+    }
+
+    var anything any
+    if err := ToGo(nil, &anything); err == nil {
+        t.Errorf("ToGo(nil) should fail")
+    }
+
+    if err := ToGo(&Float{Value: 1.5}, &n); err == nil {
+        t.Errorf("ToGo(1.5 -> int) should fail")
+    }
+
+    var s string
+    if err := ToGo(&Integer{Value: 1}, &s); err == nil {
+        t.Errorf("ToGo(INTEGER -> string) should fail")
+    }
+
+    var m map[string]int
+    ages := NewHash()
+    ages.Set(&String{Value: "a"}, &Integer{Value: 1})
+    if err := ToGo(ages, &m); err != nil || m["a"] != 1 {
+        t.Errorf("ToGo(map) wrong. got=%v, %v", m, err)
+    }
+
+    var native any
+    if err := ToGo(hash, &native); err != nil {
+        t.Fatalf("ToGo(any) failed: %s", err)
+    }
+    expectedNative := map[string]any{"name": "ann", "AGE": int64(30), "tags": []any{"x"}, "unknown": true}
+    if !reflect.DeepEqual(native, expectedNative) {
+        t.Errorf("ToGo(any) wrong. got=%#v", native)
+    }
+
+    var obj Object
+    if err := ToGo(hash, &obj); err != nil || obj != hash {
+        t.Errorf("ToGo(Object) should pass the object through. got=%v, %v", obj, err)
+    }
+
+    var p *int
+    if err := ToGo(NULL, &p); err != nil || p != nil {
+        t.Errorf("ToGo(null) should give the zero value. got=%v, %v", p, err)
+    }
+
+    if err := ToGo(NULL, n); err == nil {
+        t.Errorf("ToGo with a non-pointer target should fail")
+    }
+}
+
+func TestNewBuiltin(t *testing.T) {
+    add, err := NewBuiltin("add", func(a, b int) int { return a + b })
+    if err != nil {
+        t.Fatalf("NewBuiltin failed: %s", err)
+    }
+
+    if result := add.Fn(&Integer{Value: 1}, &Integer{Value: 2}); result.Inspect() != "3" {
+        t.Errorf("wrong result. got=%s", result.Inspect())
+    }
+
+    result := add.Fn(&Integer{Value: 1})
+    if !IsErrorKind(result, ArityError) || result.Inspect() != "ERROR: wrong number of arguments. got=1, want=2" {
+        t.Errorf("expected an arity error. got=%s", result.Inspect())
+    }
+
+    result = add.Fn(&Integer{Value: 1}, &String{Value: "x"})
+    if !IsErrorKind(result, TypeError) || result.Inspect() != "ERROR: argument 2 to `add`: cannot convert STRING to int" {
+        t.Errorf("expected a type error. got=%s", result.Inspect())
+    }
+
+    sum, _ := NewBuiltin("sum", func(prefix string, nums ...float64) string {
+        total := 0.0
+        for _, n := range nums {
+            total += n
+        }
+        return prefix + (&Float{Value: total}).Inspect()
+    })
+    if result := sum.Fn(&String{Value: "="}, &Integer{Value: 1}, &Float{Value: 0.5}); result.Inspect() != "=1.5" {
+        t.Errorf("wrong variadic result. got=%s", result.Inspect())
+    }
+    if result := sum.Fn(); !IsErrorKind(result, ArityError) {
+        t.Errorf("expected an arity error. got=%s", result.Inspect())
+    }
+
+    fail, _ := NewBuiltin("fail", func() error { return errors.New("boom") })
+    if result := fail.Fn(); result.Inspect() != "ERROR: boom" {
+        t.Errorf("wrong error. got=%s", result.Inspect())
+    }
+
+    typed, _ := NewBuiltin("typed", func() (int, error) { return 0, &Error{Kind: ValueError, Message: "bad"} })
+    if result := typed.Fn(); !IsErrorKind(result, ValueError) {
+        t.Errorf("*Error returned by a Go function should be kept. got=%s", result.Inspect())
+    }
+
+    at, _ := NewBuiltin("at", func(list []int, i int) int { return list[i] })
+    result = at.Fn(&Array{Elements: []Object{&Integer{Value: 1}}}, &Integer{Value: 5})
+    if !IsErrorKind(result, RuntimeError) || !strings.HasPrefix(result.(*Error).Message, "`at` panicked: ") {
+        t.Errorf("a panic should become a RuntimeError. got=%s", result.Inspect())
+    }
+
+    nothing, _ := NewBuiltin("nothing", func() {})
+    if result := nothing.Fn(); result != NULL {
+        t.Errorf("functions without results should return null. got=%s", result.Inspect())
+    }
+
+    var back func(a, b int) int
+    if err := ToGo(add, &back); err != nil || back(2, 3) != 5 {
+        t.Errorf("ToGo should unwrap builtins made by NewBuiltin. got=%v", err)
+    }
+
+    if _, err := NewBuiltin("x", func() (int, int) { return 0, 0 }); err == nil {
+        t.Errorf("NewBuiltin should reject functions with two results")
+    }
+}
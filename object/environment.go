@@ -1,5 +1,9 @@
 package object
 
+import (
+	"sort"
+)
+
 func NewEnclosedEnvironment(outer *Environment) *Environment {
     env := NewEnvironment()
     env.outer = outer
@@ -14,9 +18,22 @@ func NewEnvironment() *Environment {
     }
 }
 
+// NewFrame creates the environment of a call to a resolved function. Its
+// locals live in size slots, indexed as computed by the resolver, instead of
+// being looked up by name.
+func NewFrame(outer *Environment, size int) *Environment {
+    return &Environment{
+        slots: make([]Object, size),
+        outer: outer,
+        frame: true,
+    }
+}
+
 type Environment struct {
     store map [string]Object
     outer *Environment
+    slots []Object      // locals of a frame, nil until assigned
+    frame bool
 }
 
 func (e *Environment) Get(name string) (Object, bool) {
@@ -29,6 +46,47 @@ func (e *Environment) Get(name string) (Object, bool) {
 }
 
 func (e *Environment) Set(name string, val Object) Object {
+    if e.store == nil {
+        e.store = make(map [string]Object)
+    }
     e.store[name] = val
     return val
 }
+
+// GetSlot returns the local in slot of the frame depth environments up. It
+// reports false if the local has not been assigned yet.
+func (e *Environment) GetSlot(depth, slot int) (Object, bool) {
+    env := e
+    for i := 0; i < depth; i++ {
+        env = env.outer
+    }
+
+    obj := env.slots[slot]
+    return obj, obj != nil
+}
+
+func (e *Environment) SetSlot(slot int, val Object) Object {
+    e.slots[slot] = val
+    return val
+}
+
+// Names returns the names bound in e itself, sorted, leaving out those of the
+// environments it is enclosed in and the slots of frames
+func (e *Environment) Names() []string {
+    names := make([]string, 0, len(e.store))
+    for name := range e.store {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// Outer returns the environment e was enclosed in, nil for the outermost one
+func (e *Environment) Outer() *Environment {
+    return e.outer
+}
+
+// IsFrame reports whether e was created by NewFrame
+func (e *Environment) IsFrame() bool {
+    return e.frame
+}
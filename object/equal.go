@@ -0,0 +1,127 @@
+package object
+
+// Equal reports whether a and b are structurally equal. Scalars compare by
+// value (integers and floats numerically), arrays element by element, hashes
+// pair by pair regardless of insertion order and sets by membership. Two
+// functions are equal when they come from the same function literal and close
+// over the same environment. Builtins are only equal to themselves.
+//
+// Self-referencing arrays and hashes are handled by assuming that any pair of
+// containers already being compared further up is equal.
+func Equal(a, b Object) bool {
+    return equal(a, b, nil)
+}
+
+type visit struct {
+    a, b Object
+}
+
+func equal(a, b Object, seen map[visit]bool) bool {
+    if a == b {
+        return true
+    }
+
+    switch a := a.(type) {
+    case *Integer:
+        switch b := b.(type) {
+        case *Integer:
+            return a.Value == b.Value
+        case *Float:
+            return float64(a.Value) == b.Value
+        }
+        return false
+    case *Float:
+        switch b := b.(type) {
+        case *Float:
+            return a.Value == b.Value
+        case *Integer:
+            return a.Value == float64(b.Value)
+        }
+        return false
+    case *String:
+        b, ok := b.(*String)
+        return ok && a.Value == b.Value
+    case *Boolean:
+        b, ok := b.(*Boolean)
+        return ok && a.Value == b.Value
+    case *Null:
+        _, ok := b.(*Null)
+        return ok
+    case *Error:
+        b, ok := b.(*Error)
+        return ok && a.ErrorKind() == b.ErrorKind() && a.Message == b.Message
+    case *ReturnValue:
+        b, ok := b.(*ReturnValue)
+        return ok && equal(a.Value, b.Value, seen)
+    case *Function:
+        b, ok := b.(*Function)
+        return ok && a.Body == b.Body && a.Env == b.Env
+    case *Closure:
+        b, ok := b.(*Closure)
+        return ok && a.Fn == b.Fn && a.Env == b.Env
+    case *Array:
+        b, ok := b.(*Array)
+        if !ok || len(a.Elements) != len(b.Elements) {
+            return false
+        }
+
+        seen, done := markSeen(seen, a, b)
+        if done {
+            return true
+        }
+
+        for i := range a.Elements {
+            if !equal(a.Elements[i], b.Elements[i], seen) {
+                return false
+            }
+        }
+        return true
+    case *Hash:
+        b, ok := b.(*Hash)
+        if !ok || a.Len() != b.Len() {
+            return false
+        }
+
+        seen, done := markSeen(seen, a, b)
+        if done {
+            return true
+        }
+
+        for _, pair := range a.order {
+            value, ok := b.lookup(pair.Key.(Hashable))
+            if !ok || !equal(pair.Value, value, seen) {
+                return false
+            }
+        }
+        return true
+    case *Set:
+        // members are hashable so membership already compares them structurally
+        b, ok := b.(*Set)
+        if !ok || a.Len() != b.Len() {
+            return false
+        }
+
+        for _, elem := range a.Elements() {
+            if !b.Has(elem.(Hashable)) {
+                return false
+            }
+        }
+        return true
+    default:
+        return false
+    }
+}
+
+func markSeen(seen map[visit]bool, a, b Object) (map[visit]bool, bool) {
+    if seen == nil {
+        seen = make(map[visit]bool)
+    }
+
+    v := visit{a, b}
+    if seen[v] {
+        return seen, true
+    }
+    seen[v] = true
+
+    return seen, false
+}
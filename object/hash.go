@@ -0,0 +1,233 @@
+package object
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"strings"
+)
+
+// HashKey only decides which bucket a key lands in. Two different keys may
+// share a HashKey, so lookups always confirm with Equal
+type HashKey struct {
+    Type ObjectType
+    Value uint64
+}
+
+type HashPair struct {
+    Key Object
+    Value Object
+}
+
+// AsHashable reports whether obj can be used as a hash key. Numbers, strings
+// and booleans always can, arrays only if all of their elements can and hashes
+// only once they have been frozen
+func AsHashable(obj Object) (Hashable, bool) {
+    if !isHashable(obj) {
+        return nil, false
+    }
+    return obj.(Hashable), true
+}
+
+func isHashable(obj Object) bool {
+    switch obj := obj.(type) {
+    case *Integer, *Float, *String, *Boolean:
+        return true
+    case *Array:
+        for _, elem := range obj.Elements {
+            if !isHashable(elem) {
+                return false
+            }
+        }
+        return true
+    case *Hash:
+        if !obj.frozen {
+            return false
+        }
+        for _, pair := range obj.order {
+            if !isHashable(pair.Value) {
+                return false
+            }
+        }
+        return true
+    default:
+        return false
+    }
+}
+
+func writeHashKey(buf *bytes.Buffer, key HashKey) {
+    buf.WriteString(string(key.Type))
+    binary.Write(buf, binary.LittleEndian, key.Value)
+}
+
+// like the hash key of a frozen Hash, this assumes the array passed AsHashable
+func (ao *Array) HashKey() HashKey {
+    var buf bytes.Buffer
+    for _, elem := range ao.Elements {
+        writeHashKey(&buf, elem.(Hashable).HashKey())
+    }
+
+    h := fnv.New64a()
+    h.Write(buf.Bytes())
+
+    return HashKey {
+        Type: ao.Type(),
+        Value: h.Sum64(),
+    }
+}
+
+// Hash remembers the order in which keys were first inserted, so iterating
+// over it (and printing it) gives the same result on every run
+type Hash struct {
+    buckets map[HashKey][]*HashPair
+    order []*HashPair
+    frozen bool
+}
+
+func NewHash() *Hash {
+    return &Hash{buckets: make(map[HashKey][]*HashPair)}
+}
+
+func (h *Hash) Type() ObjectType {
+    return HASH_OBJ
+}
+
+func (h *Hash) Inspect() string {
+    var out bytes.Buffer
+
+    pairs := []string{}
+    for _, pair := range h.order {
+        pairs = append(pairs, fmt.Sprintf("%s: %s", pair.Key.Inspect(), pair.Value.Inspect()))
+    }
+
+    out.WriteString("{")
+    out.WriteString(strings.Join(pairs, ", "))
+    out.WriteString("}")
+
+    return out.String()
+}
+
+func (h *Hash) Equals(other Object) bool {
+    return Equal(h, other)
+}
+
+// the key of a frozen hash does not depend on the order of its pairs since
+// two hashes holding the same pairs are equal
+func (h *Hash) HashKey() HashKey {
+    var sum uint64
+    for _, pair := range h.order {
+        var buf bytes.Buffer
+        writeHashKey(&buf, pair.Key.(Hashable).HashKey())
+        writeHashKey(&buf, pair.Value.(Hashable).HashKey())
+
+        f := fnv.New64a()
+        f.Write(buf.Bytes())
+        sum += f.Sum64()
+    }
+
+    return HashKey {
+        Type: h.Type(),
+        Value: sum,
+    }
+}
+
+func (h *Hash) Len() int {
+    return len(h.order)
+}
+
+func (h *Hash) Get(key Hashable) (Object, bool) {
+    return h.lookup(key)
+}
+
+func (h *Hash) lookup(key Hashable) (Object, bool) {
+    for _, pair := range h.buckets[key.HashKey()] {
+        if Equal(pair.Key, key) {
+            return pair.Value, true
+        }
+    }
+    return nil, false
+}
+
+// overwriting an existing key keeps its original position
+func (h *Hash) Set(key Hashable, value Object) {
+    if h.frozen {
+        panic("object: Set on frozen hash")
+    }
+
+    if h.buckets == nil {
+        h.buckets = make(map[HashKey][]*HashPair)
+    }
+
+    hashed := key.HashKey()
+    for _, pair := range h.buckets[hashed] {
+        if Equal(pair.Key, key) {
+            pair.Value = value
+            return
+        }
+    }
+
+    pair := &HashPair{Key: key, Value: value}
+    h.buckets[hashed] = append(h.buckets[hashed], pair)
+    h.order = append(h.order, pair)
+}
+
+func (h *Hash) Delete(key Hashable) bool {
+    if h.frozen {
+        panic("object: Delete on frozen hash")
+    }
+
+    hashed := key.HashKey()
+    bucket := h.buckets[hashed]
+
+    for i, pair := range bucket {
+        if !Equal(pair.Key, key) {
+            continue
+        }
+
+        if len(bucket) == 1 {
+            delete(h.buckets, hashed)
+        } else {
+            h.buckets[hashed] = append(bucket[:i:i], bucket[i + 1:]...)
+        }
+
+        for j, p := range h.order {
+            if p == pair {
+                h.order = append(h.order[:j:j], h.order[j + 1:]...)
+                break
+            }
+        }
+
+        return true
+    }
+
+    return false
+}
+
+// Freeze makes the hash read-only so that it can itself be used as a key
+func (h *Hash) Freeze() {
+    h.frozen = true
+}
+
+func (h *Hash) Frozen() bool {
+    return h.frozen
+}
+
+// Copy returns a shallow, unfrozen copy of the hash
+func (h *Hash) Copy() *Hash {
+    hash := NewHash()
+    for _, pair := range h.order {
+        hash.Set(pair.Key.(Hashable), pair.Value)
+    }
+    return hash
+}
+
+// OrderedPairs returns the pairs of the hash in insertion order
+func (h *Hash) OrderedPairs() []HashPair {
+    pairs := make([]HashPair, len(h.order))
+    for i, pair := range h.order {
+        pairs[i] = *pair
+    }
+
+    return pairs
+}
@@ -4,7 +4,11 @@ import (
 	"bytes"
 	"fmt"
     "hash/fnv"
+	"math"
 	"monkey/ast"
+	"monkey/code"
+	"reflect"
+	"strconv"
 	"strings"
 )
 
@@ -14,6 +18,7 @@ type BuiltInFunction func(args ...Object) Object
 
 const (
     INTEGER_OBJ = "INTEGER"
+    FLOAT_OBJ = "FLOAT"
     BOOLEAN_OBJ = "BOOLEAN"
     NULL_OBJ = "NULL"
     RETURN_VALUE_OBJ = "RETURN_VALUE"
@@ -23,19 +28,73 @@ const (
     BUILTIN_OBJ = "BUILTIN"
     ARRAY_OBJ = "ARRAY"
     HASH_OBJ = "HASH"
+    SET_OBJ = "SET"
+    COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION"
+)
+
+// shared instances, the evaluator compares null and booleans by pointer
+var (
+    NULL = &Null{}
+    TRUE = &Boolean{Value: true}
+    FALSE = &Boolean{Value: false}
 )
 
 type Object interface {
     Type() ObjectType
     Inspect() string
+    Equals(other Object) bool   // structural equality, see Equal
 }
 
 type Hashable interface {
+    Object
     HashKey() HashKey
 }
 
+// ErrorKind classifies errors so that scripts can catch them selectively and
+// Go code can test for them with errors.Is(err, object.TypeError). Indexing
+// out of range evaluates to null, like a missing hash key, rather than failing,
+// so there is no kind for it.
+type ErrorKind string
+
+const (
+    RuntimeError ErrorKind = "RuntimeError"            // anything not covered below
+    TypeError ErrorKind = "TypeError"                  // operands or arguments of the wrong type
+    NameError ErrorKind = "NameError"                  // unknown identifiers
+    ArityError ErrorKind = "ArityError"                // calls with the wrong number of arguments
+    ValueError ErrorKind = "ValueError"                // right type, unusable value e.g. int("abc")
+    ZeroDivisionError ErrorKind = "ZeroDivisionError"
+    UserError ErrorKind = "UserError"                  // values thrown by scripts
+    RecursionError ErrorKind = "RecursionError"        // too many nested calls
+    LimitError ErrorKind = "LimitError"                // execution limits exceeded, cannot be caught
+)
+
+func (k ErrorKind) Error() string {
+    return string(k)
+}
+
+var errorKinds = map[ErrorKind]bool{
+    RuntimeError: true,
+    TypeError: true,
+    NameError: true,
+    ArityError: true,
+    ValueError: true,
+    ZeroDivisionError: true,
+    UserError: true,
+    RecursionError: true,
+    LimitError: true,
+}
+
+// Valid reports whether k is one of the kinds above
+func (k ErrorKind) Valid() bool {
+    return errorKinds[k]
+}
+
 type Error struct {
+    Kind ErrorKind      // empty is treated as RuntimeError
     Message string
+    Value Object        // what was thrown by a throw statement, nil for runtime errors
+    Stack []string      // the functions the error went through, innermost first
+    Line int            // where the vm raised it, 0 if unknown
 }
 
 func (e *Error) Type() ObjectType {
@@ -46,6 +105,38 @@ func (e *Error) Inspect() string {
     return "ERROR: " + e.Message
 }
 
+func (e *Error) ErrorKind() ErrorKind {
+    if e.Kind == "" {
+        return RuntimeError
+    }
+    return e.Kind
+}
+
+// Error makes *Error usable as a Go error, prefixed with its line if known
+func (e *Error) Error() string {
+    msg := string(e.ErrorKind()) + ": " + e.Message
+    if e.Line != 0 {
+        return fmt.Sprintf("line %d: %s", e.Line, msg)
+    }
+    return msg
+}
+
+// Is lets errors.Is match an *Error against its ErrorKind
+func (e *Error) Is(target error) bool {
+    kind, ok := target.(ErrorKind)
+    return ok && kind == e.ErrorKind()
+}
+
+// IsErrorKind reports whether obj is an error of the given kind
+func IsErrorKind(obj Object, kind ErrorKind) bool {
+    errObj, ok := obj.(*Error)
+    return ok && errObj.ErrorKind() == kind
+}
+
+func (e *Error) Equals(other Object) bool {
+    return Equal(e, other)
+}
+
 type Integer struct {
     Value int64
 }
@@ -58,6 +149,10 @@ func (i *Integer) Inspect() string {
     return fmt.Sprintf("%d", i.Value)
 }
 
+func (i *Integer) Equals(other Object) bool {
+    return Equal(i, other)
+}
+
 func (i *Integer) HashKey() HashKey {
     return HashKey {
         Type: i.Type(),
@@ -65,6 +160,41 @@ func (i *Integer) HashKey() HashKey {
     }
 }
 
+type Float struct {
+    Value float64
+}
+
+func (f *Float) Type() ObjectType {
+    return FLOAT_OBJ
+}
+
+// always keeps a decimal point so that 1.0 doesn't print like the integer 1
+func (f *Float) Inspect() string {
+    s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+    if !strings.ContainsAny(s, ".eIN") {
+        s += ".0"
+    }
+    return s
+}
+
+func (f *Float) Equals(other Object) bool {
+    return Equal(f, other)
+}
+
+// whole floats share the hash key of the equal integer, since 1 == 1.0 they
+// have to find the same entry. NaN is never equal to anything, so a NaN key
+// can be stored but not looked up again.
+func (f *Float) HashKey() HashKey {
+    if f.Value == math.Trunc(f.Value) && f.Value >= math.MinInt64 && f.Value < math.MaxInt64 {
+        return (&Integer{Value: int64(f.Value)}).HashKey()
+    }
+
+    return HashKey {
+        Type: f.Type(),
+        Value: math.Float64bits(f.Value),
+    }
+}
+
 type Boolean struct {
     Value bool
 }
@@ -77,6 +207,10 @@ func (b *Boolean) Inspect() string {
     return fmt.Sprintf("%t", b.Value)
 }
 
+func (b *Boolean) Equals(other Object) bool {
+    return Equal(b, other)
+}
+
 func (b *Boolean) HashKey() HashKey {
     var value uint64
 
@@ -104,6 +238,10 @@ func (s *String) Inspect() string {
     return s.Value
 }
 
+func (s *String) Equals(other Object) bool {
+    return Equal(s, other)
+}
+
 func (s *String) HashKey() HashKey {
     h := fnv.New64a()
     h.Write([]byte(s.Value))
@@ -124,6 +262,10 @@ func (n *Null) Inspect() string {
     return "null"
 }
 
+func (n *Null) Equals(other Object) bool {
+    return Equal(n, other)
+}
+
 type ReturnValue struct {
     Value Object
 }
@@ -136,10 +278,17 @@ func (rv *ReturnValue) Inspect() string {
     return rv.Value.Inspect()
 }
 
+func (rv *ReturnValue) Equals(other Object) bool {
+    return Equal(rv, other)
+}
+
 type Function struct {
+    Name string         // empty for anonymous functions
     Parameters []*ast.Identifier
     Body *ast.BlockStatement
     Env *Environment
+    Resolved bool       // calls get a frame of Slots slots, see NewFrame
+    Slots int
 }
 
 func (f *Function) Type() ObjectType {
@@ -164,8 +313,55 @@ func (f *Function) Inspect() string {
     return out.String()
 }
 
+func (f *Function) Equals(other Object) bool {
+    return Equal(f, other)
+}
+
+// CompiledFunction is a function literal compiled to bytecode. Its frame has
+// NumLocals slots, the parameters first, like the frame of a resolved Function.
+type CompiledFunction struct {
+    Instructions code.Instructions
+    SourceMap code.SourceMap
+    Constants []Object  // the constant pool of the program it was compiled in
+    NumLocals int
+    NumParameters int
+    Name string         // empty for anonymous functions
+}
+
+func (cf *CompiledFunction) Type() ObjectType {
+    return COMPILED_FUNCTION_OBJ
+}
+
+func (cf *CompiledFunction) Inspect() string {
+    return fmt.Sprintf("CompiledFunction[%p]", cf)
+}
+
+func (cf *CompiledFunction) Equals(other Object) bool {
+    return Equal(cf, other)
+}
+
+// Closure is the function value the vm makes of a CompiledFunction, closing
+// over the environment it was created in. Scripts see it as a FUNCTION.
+type Closure struct {
+    Fn *CompiledFunction
+    Env *Environment
+}
+
+func (c *Closure) Type() ObjectType {
+    return FUNCTION_OBJ
+}
+
+func (c *Closure) Inspect() string {
+    return fmt.Sprintf("Closure[%p]", c)
+}
+
+func (c *Closure) Equals(other Object) bool {
+    return Equal(c, other)
+}
+
 type BuiltIn struct {
     Fn BuiltInFunction
+    goFunc reflect.Value    // the wrapped Go function for builtins made by NewBuiltin
 }
 
 func (b *BuiltIn) Type() ObjectType {
@@ -176,6 +372,10 @@ func (b *BuiltIn) Inspect() string {
     return "builtin function"
 }
 
+func (b *BuiltIn) Equals(other Object) bool {
+    return Equal(b, other)
+}
+
 type Array struct {
     Elements []Object
 }
@@ -199,35 +399,6 @@ func (ao *Array) Inspect() string {
     return out.String()
 }
 
-type HashKey struct {
-    Type ObjectType
-    Value uint64
-}
-
-type HashPair struct {
-    Key Object
-    Value Object
-}
-
-type Hash struct {
-    Pairs map[HashKey]HashPair
-}
-
-func (h *Hash) Type() ObjectType {
-    return HASH_OBJ
-}
-
-func (h *Hash) Inspect() string {
-    var out bytes.Buffer
-
-    pairs := []string{}
-    for _, pair := range h.Pairs {
-        pairs = append(pairs, fmt.Sprintf("%s: %s", pair.Key.Inspect(), pair.Value.Inspect()))
-    }
-    
-    out.WriteString("{")
-    out.WriteString(strings.Join(pairs, ", "))
-    out.WriteString("}")
-
-    return out.String()
+func (ao *Array) Equals(other Object) bool {
+    return Equal(ao, other)
 }
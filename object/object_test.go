@@ -1,6 +1,7 @@
 package object
 
 import (
+    "monkey/ast"
     "testing"
 )
 
@@ -22,3 +23,190 @@ func TestStringHashKey(t *testing.T) {
         t.Errorf("strings with different content have same hash keys")
     }
 }
+
+// collidingKey always hashes to the same bucket and is only equal to itself
+type collidingKey struct {
+    name string
+}
+
+func (c *collidingKey) Type() ObjectType { return "COLLIDING" }
+func (c *collidingKey) Inspect() string { return c.name }
+func (c *collidingKey) HashKey() HashKey { return HashKey{Type: "COLLIDING", Value: 42} }
+func (c *collidingKey) Equals(other Object) bool { return c == other }
+
+func TestHashBucketsCollidingKeys(t *testing.T) {
+    a := &collidingKey{name: "a"}
+    b := &collidingKey{name: "b"}
+
+    hash := NewHash()
+    hash.Set(a, &Integer{Value: 1})
+    hash.Set(b, &Integer{Value: 2})
+
+    if hash.Len() != 2 {
+        t.Fatalf("colliding keys overwrote each other. got len=%d", hash.Len())
+    }
+
+    for key, expected := range map[*collidingKey]int64{a: 1, b: 2} {
+        value, ok := hash.Get(key)
+        if !ok {
+            t.Fatalf("no value for key %s", key.name)
+        }
+        if value.(*Integer).Value != expected {
+            t.Errorf("wrong value for key %s. got=%d, want=%d", key.name, value.(*Integer).Value, expected)
+        }
+    }
+
+    hash.Delete(a)
+    if _, ok := hash.Get(b); !ok || hash.Len() != 1 {
+        t.Errorf("deleting one colliding key removed the other")
+    }
+}
+
+func TestFloatHashKey(t *testing.T) {
+    if (&Float{Value: 2.0}).HashKey() != (&Integer{Value: 2}).HashKey() {
+        t.Errorf("whole floats must share the hash key of the equal integer")
+    }
+    if (&Float{Value: -0.0}).HashKey() != (&Integer{Value: 0}).HashKey() {
+        t.Errorf("-0.0 must share the hash key of 0")
+    }
+    if (&Float{Value: 2.5}).HashKey() == (&Float{Value: 3.5}).HashKey() {
+        t.Errorf("different floats have the same hash key")
+    }
+
+    hash := NewHash()
+    hash.Set(&Integer{Value: 1}, &String{Value: "int"})
+    hash.Set(&Float{Value: 1.0}, &String{Value: "float"})
+    hash.Set(&Float{Value: 1.5}, &String{Value: "half"})
+
+    if hash.Len() != 2 {
+        t.Errorf("1 and 1.0 should be the same key. got %d pairs", hash.Len())
+    }
+    if value, ok := hash.Get(&Integer{Value: 1}); !ok || value.Inspect() != "float" {
+        t.Errorf("lookup of 1 wrong. got=%v", value)
+    }
+    if value, ok := hash.Get(&Float{Value: 1.5}); !ok || value.Inspect() != "half" {
+        t.Errorf("lookup of 1.5 wrong. got=%v", value)
+    }
+}
+
+func TestCompositeHashKeys(t *testing.T) {
+    array := func(elems ...Object) *Array { return &Array{Elements: elems} }
+
+    if _, ok := AsHashable(array(&Integer{Value: 1}, &String{Value: "a"})); !ok {
+        t.Errorf("array of integers and strings is not hashable")
+    }
+
+    if _, ok := AsHashable(array(&Function{})); ok {
+        t.Errorf("array holding a function is hashable")
+    }
+
+    inner := NewHash()
+    inner.Set(&String{Value: "a"}, &Integer{Value: 1})
+    if _, ok := AsHashable(inner); ok {
+        t.Errorf("unfrozen hash is hashable")
+    }
+
+    inner.Freeze()
+    if _, ok := AsHashable(inner); !ok {
+        t.Errorf("frozen hash is not hashable")
+    }
+
+    hash := NewHash()
+    hash.Set(array(&Integer{Value: 1}, &Integer{Value: 2}), &String{Value: "array"})
+    hash.Set(inner, &String{Value: "hash"})
+
+    value, ok := hash.Get(array(&Integer{Value: 1}, &Integer{Value: 2}))
+    if !ok || value.Inspect() != "array" {
+        t.Errorf("lookup with an equal array failed. got=%v", value)
+    }
+
+    if _, ok := hash.Get(array(&Integer{Value: 2}, &Integer{Value: 1})); ok {
+        t.Errorf("lookup with a reordered array succeeded")
+    }
+
+    other := NewHash()
+    other.Set(&String{Value: "a"}, &Integer{Value: 1})
+    other.Freeze()
+    value, ok = hash.Get(other)
+    if !ok || value.Inspect() != "hash" {
+        t.Errorf("lookup with an equal frozen hash failed. got=%v", value)
+    }
+}
+
+func TestEqual(t *testing.T) {
+    array := func(elems ...Object) *Array { return &Array{Elements: elems} }
+    hash := func(pairs ...Object) *Hash {
+        h := NewHash()
+        for i := 0; i < len(pairs); i += 2 {
+            h.Set(pairs[i].(Hashable), pairs[i + 1])
+        }
+        return h
+    }
+    one, two := &Integer{Value: 1}, &Integer{Value: 2}
+    a, b := &String{Value: "a"}, &String{Value: "b"}
+
+    tests := []struct {
+        left, right Object
+        expected bool
+    }{
+        {one, &Integer{Value: 1}, true},
+        {one, two, false},
+        {one, a, false},
+        {a, &String{Value: "a"}, true},
+        {&Null{}, &Null{}, true},
+        {array(one, a), array(&Integer{Value: 1}, &String{Value: "a"}), true},
+        {array(one, a), array(a, one), false},
+        {array(one), array(one, one), false},
+        {array(array(one)), array(array(one)), true},
+        {hash(a, one, b, two), hash(b, two, a, one), true},
+        {hash(a, one), hash(a, two), false},
+        {hash(a, one), hash(b, one), false},
+        {hash(a, array(one)), hash(a, array(one)), true},
+    }
+
+    for i, tt := range tests {
+        if got := tt.left.Equals(tt.right); got != tt.expected {
+            t.Errorf("test %d: %s == %s is %t, want %t", i, tt.left.Inspect(), tt.right.Inspect(), got, tt.expected)
+        }
+    }
+}
+
+func TestEqualFunctions(t *testing.T) {
+    body := &ast.BlockStatement{}
+    env := NewEnvironment()
+
+    f := &Function{Body: body, Env: env}
+    if !f.Equals(&Function{Body: body, Env: env}) {
+        t.Errorf("closures of the same literal over the same env are not equal")
+    }
+    if f.Equals(&Function{Body: body, Env: NewEnvironment()}) {
+        t.Errorf("closures over different envs are equal")
+    }
+    if f.Equals(&Function{Body: &ast.BlockStatement{}, Env: env}) {
+        t.Errorf("closures of different literals are equal")
+    }
+}
+
+func TestEqualSelfReferencing(t *testing.T) {
+    left := &Array{}
+    left.Elements = []Object{&Integer{Value: 1}, left}
+    right := &Array{}
+    right.Elements = []Object{&Integer{Value: 1}, right}
+
+    if !left.Equals(right) {
+        t.Errorf("identical self-referencing arrays are not equal")
+    }
+
+    other := &Array{}
+    other.Elements = []Object{&Integer{Value: 2}, other}
+    if left.Equals(other) {
+        t.Errorf("different self-referencing arrays are equal")
+    }
+
+    h1, h2 := NewHash(), NewHash()
+    h1.Set(&String{Value: "self"}, h1)
+    h2.Set(&String{Value: "self"}, h2)
+    if !h1.Equals(h2) {
+        t.Errorf("identical self-referencing hashes are not equal")
+    }
+}
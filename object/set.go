@@ -0,0 +1,103 @@
+package object
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Set holds unique hashable values in insertion order. It is backed by a Hash
+// whose keys map to themselves, so membership follows the same rules as hash
+// keys.
+type Set struct {
+    elements Hash
+}
+
+func NewSet() *Set {
+    return &Set{}
+}
+
+func (s *Set) Type() ObjectType {
+    return SET_OBJ
+}
+
+// Inspect prints the set the way it would be constructed
+func (s *Set) Inspect() string {
+    var out bytes.Buffer
+
+    elems := []string{}
+    for _, elem := range s.Elements() {
+        elems = append(elems, elem.Inspect())
+    }
+
+    out.WriteString("set([")
+    out.WriteString(strings.Join(elems, ", "))
+    out.WriteString("])")
+
+    return out.String()
+}
+
+func (s *Set) Equals(other Object) bool {
+    return Equal(s, other)
+}
+
+func (s *Set) Len() int {
+    return s.elements.Len()
+}
+
+func (s *Set) Has(elem Hashable) bool {
+    _, ok := s.elements.Get(elem)
+    return ok
+}
+
+func (s *Set) Add(elem Hashable) {
+    s.elements.Set(elem, elem)
+}
+
+func (s *Set) Remove(elem Hashable) bool {
+    return s.elements.Delete(elem)
+}
+
+// Elements returns the members of the set in insertion order
+func (s *Set) Elements() []Object {
+    elems := make([]Object, len(s.elements.order))
+    for i, pair := range s.elements.order {
+        elems[i] = pair.Key
+    }
+    return elems
+}
+
+func (s *Set) Copy() *Set {
+    set := NewSet()
+    for _, elem := range s.Elements() {
+        set.Add(elem.(Hashable))
+    }
+    return set
+}
+
+func (s *Set) Union(other *Set) *Set {
+    set := s.Copy()
+    for _, elem := range other.Elements() {
+        set.Add(elem.(Hashable))
+    }
+    return set
+}
+
+func (s *Set) Intersection(other *Set) *Set {
+    set := NewSet()
+    for _, elem := range s.Elements() {
+        if other.Has(elem.(Hashable)) {
+            set.Add(elem.(Hashable))
+        }
+    }
+    return set
+}
+
+func (s *Set) Difference(other *Set) *Set {
+    set := NewSet()
+    for _, elem := range s.Elements() {
+        if !other.Has(elem.(Hashable)) {
+            set.Add(elem.(Hashable))
+        }
+    }
+    return set
+}
@@ -0,0 +1,292 @@
+// Package optimizer rewrites parsed programs into equivalent ones that do less
+// work when run. It computes what can be known before running: arithmetic,
+// comparisons and string concatenation of literals, if expressions whose
+// condition is a literal and variables bound once to a literal. The results
+// and errors of the program stay the same, only the steps counted against
+// a step budget go down.
+package optimizer
+
+import (
+	"monkey/ast"
+	"monkey/evaluator"
+	"monkey/object"
+	"monkey/token"
+)
+
+// Optimize rewrites program in place and returns it. Run it before the
+// resolver, see resolver.Resolve, which has to see the rewritten program.
+//
+// Operations are folded with the evaluator's own semantics, and only when
+// they succeed, so that 1 / 0 still fails when it runs. Variables are only
+// replaced by their value where they cannot have been bound to anything else:
+// after their let statement at the top level of the program or a function,
+// provided nothing else in that scope binds the name. Globals are not
+// replaced inside functions, since a later program may bind them again.
+func Optimize(program *ast.Program) *ast.Program {
+    o := &optimizer{constants: make(map[string]*constant)}
+    o.body(program.Statements, bindings(program.Statements, nil), false)
+    return program
+}
+
+type optimizer struct {
+    constants map[string]*constant  // the variables known to hold a literal, by name
+}
+
+type constant struct {
+    value ast.Expression            // a literal
+    local bool                      // bound in a function rather than globally
+}
+
+// body optimizes the statements of the program or of a function, bound
+// counting how often each name is bound in its scope
+func (o *optimizer) body(statements []ast.Statement, bound map[string]int, local bool) {
+    for _, statement := range statements {
+        o.statement(statement)
+
+        let, ok := statement.(*ast.LetStatement)
+        if !ok || bound[let.Name.Value] != 1 {
+            continue
+        }
+
+        // strings are left alone: every evaluation of a string literal
+        // allocates, looking up a variable does not
+        if _, ok := let.Value.(*ast.StringLiteral); !ok && isLiteral(let.Value) {
+            o.constants[let.Name.Value] = &constant{value: let.Value, local: local}
+        }
+    }
+}
+
+func (o *optimizer) statement(statement ast.Statement) {
+    switch statement := statement.(type) {
+    case *ast.LetStatement:
+        statement.Value = o.expression(statement.Value)
+    case *ast.ReturnStatement:
+        statement.ReturnValue = o.expression(statement.ReturnValue)
+    case *ast.ThrowStatement:
+        statement.Value = o.expression(statement.Value)
+    case *ast.ExpressionStatement:
+        statement.Expression = o.expression(statement.Expression)
+    case *ast.BlockStatement:
+        o.block(statement)
+    }
+}
+
+func (o *optimizer) block(block *ast.BlockStatement) {
+    if block == nil {
+        return
+    }
+
+    for _, statement := range block.Statements {
+        o.statement(statement)
+    }
+}
+
+// expression returns the optimized form of node
+func (o *optimizer) expression(node ast.Expression) ast.Expression {
+    switch node := node.(type) {
+    case *ast.Identifier:
+        if c, ok := o.constants[node.Value]; ok {
+            return literal(value(c.value), node.Token.Line)
+        }
+    case *ast.PrefixExpression:
+        node.Right = o.expression(node.Right)
+        if isLiteral(node.Right) {
+            return fold(node, evaluator.PrefixOperation(node.Operator, value(node.Right)))
+        }
+    case *ast.InfixExpression:
+        node.Left = o.expression(node.Left)
+        node.Right = o.expression(node.Right)
+        if isLiteral(node.Left) && isLiteral(node.Right) {
+            return fold(node, evaluator.InfixOperation(node.Operator, value(node.Left), value(node.Right)))
+        }
+    case *ast.IfExpression:
+        node.Condition = o.expression(node.Condition)
+        o.block(node.Consequence)
+        o.block(node.Alternative)
+        if isLiteral(node.Condition) {
+            return prune(node)
+        }
+    case *ast.TryExpression:
+        o.block(node.Block)
+        for _, clause := range node.Catches {
+            o.block(clause.Body)
+        }
+        o.block(node.Finally)
+    case *ast.FunctionLiteral:
+        o.function(node)
+    case *ast.CallExpression:
+        node.Function = o.expression(node.Function)
+        for i, arg := range node.Arguments {
+            node.Arguments[i] = o.expression(arg)
+        }
+    case *ast.ArrayLiteral:
+        for i, elem := range node.Elements {
+            node.Elements[i] = o.expression(elem)
+        }
+    case *ast.IndexExpression:
+        node.Left = o.expression(node.Left)
+        node.Index = o.expression(node.Index)
+    case *ast.HashLiteral:
+        for i, pair := range node.Pairs {
+            node.Pairs[i] = ast.HashPair{Key: o.expression(pair.Key), Value: o.expression(pair.Value)}
+        }
+    }
+
+    return node
+}
+
+func (o *optimizer) function(fl *ast.FunctionLiteral) {
+    bound := bindings(fl.Body.Statements, fl.Parameters)
+
+    // locals of enclosing functions keep their value in every call, unless
+    // this function binds the name itself
+    outer := o.constants
+    o.constants = make(map[string]*constant)
+    for name, c := range outer {
+        if c.local && bound[name] == 0 {
+            o.constants[name] = c
+        }
+    }
+
+    o.body(fl.Body.Statements, bound, true)
+    o.constants = outer
+}
+
+// fold replaces node by the literal for result, unless computing it failed:
+// then node is kept to fail when it runs
+func fold(node ast.Expression, result object.Object) ast.Expression {
+    if folded := literal(result, line(node)); folded != nil {
+        return folded
+    }
+    return node
+}
+
+// prune replaces an if expression whose condition is a literal by the branch
+// taken. Branches binding variables are kept, even when never taken, since
+// their lets make the names local to the whole function.
+func prune(node *ast.IfExpression) ast.Expression {
+    taken, dropped := node.Consequence, node.Alternative
+    if !evaluator.IsTruthy(value(node.Condition)) {
+        taken, dropped = dropped, taken
+    }
+
+    if declares(dropped) {
+        return node
+    }
+
+    if taken == nil {
+        return &ast.NullLiteral{Token: token.Token{Type: token.NULL, Literal: "null", Line: node.Token.Line}}
+    }
+
+    // a block of a single expression has the value of the expression
+    if len(taken.Statements) == 1 {
+        if statement, ok := taken.Statements[0].(*ast.ExpressionStatement); ok {
+            return statement.Expression
+        }
+    }
+
+    node.Condition = literal(object.TRUE, node.Token.Line)
+    node.Consequence = taken
+    node.Alternative = nil
+    return node
+}
+
+func isLiteral(node ast.Expression) bool {
+    return value(node) != nil
+}
+
+// value returns the object a literal evaluates to, nil for other nodes
+func value(node ast.Expression) object.Object {
+    switch node := node.(type) {
+    case *ast.IntegerLiteral:
+        return &object.Integer{Value: node.Value}
+    case *ast.FloatLiteral:
+        return &object.Float{Value: node.Value}
+    case *ast.StringLiteral:
+        return &object.String{Value: node.Value}
+    case *ast.Boolean:
+        if node.Value {
+            return object.TRUE
+        }
+        return object.FALSE
+    case *ast.NullLiteral:
+        return object.NULL
+    }
+    return nil
+}
+
+// literal returns the literal evaluating to obj, nil if there is none
+func literal(obj object.Object, line int) ast.Expression {
+    switch obj := obj.(type) {
+    case *object.Integer:
+        return &ast.IntegerLiteral{Token: token.Token{Type: token.INT, Literal: obj.Inspect(), Line: line}, Value: obj.Value}
+    case *object.Float:
+        return &ast.FloatLiteral{Token: token.Token{Type: token.FLOAT, Literal: obj.Inspect(), Line: line}, Value: obj.Value}
+    case *object.String:
+        return &ast.StringLiteral{Token: token.Token{Type: token.STRING, Literal: obj.Value, Line: line}, Value: obj.Value}
+    case *object.Boolean:
+        if obj.Value {
+            return &ast.Boolean{Token: token.Token{Type: token.TRUE, Literal: "true", Line: line}, Value: true}
+        }
+        return &ast.Boolean{Token: token.Token{Type: token.FALSE, Literal: "false", Line: line}, Value: false}
+    case *object.Null:
+        return &ast.NullLiteral{Token: token.Token{Type: token.NULL, Literal: "null", Line: line}}
+    }
+    return nil
+}
+
+func line(node ast.Expression) int {
+    switch node := node.(type) {
+    case *ast.PrefixExpression:
+        return node.Token.Line
+    case *ast.InfixExpression:
+        return node.Token.Line
+    }
+    return 0
+}
+
+// bindings counts how often each name is bound in the scope of a program or
+// function: by its parameters, its let statements and its catch clauses,
+// without entering nested functions
+func bindings(statements []ast.Statement, params []*ast.Identifier) map[string]int {
+    bound := make(map[string]int)
+    for _, param := range params {
+        bound[param.Value]++
+    }
+
+    for _, statement := range statements {
+        bind(statement, func(name *ast.Identifier) {
+            bound[name.Value]++
+        })
+    }
+
+    return bound
+}
+
+// declares reports whether block binds any variable
+func declares(block *ast.BlockStatement) bool {
+    found := false
+    if block != nil {
+        bind(block, func(*ast.Identifier) {
+            found = true
+        })
+    }
+    return found
+}
+
+// bind calls found for every name bound in node outside of nested functions
+func bind(node ast.Node, found func(*ast.Identifier)) {
+    ast.Inspect(node, func(node ast.Node) bool {
+        switch node := node.(type) {
+        case *ast.FunctionLiteral:
+            return false
+        case *ast.LetStatement:
+            found(node.Name)
+        case *ast.CatchClause:
+            if node.Parameter != nil {
+                found(node.Parameter)
+            }
+        }
+        return true
+    })
+}
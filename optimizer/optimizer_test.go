@@ -0,0 +1,114 @@
+package optimizer
+
+import (
+	"monkey/ast"
+	"monkey/enginetest"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/resolver"
+	"testing"
+)
+
+func testOptimize(t *testing.T, input string) *ast.Program {
+    t.Helper()
+
+    p := parser.New(lexer.New(input))
+    program := p.ParseProgram()
+    if len(p.Errors()) != 0 {
+        t.Fatalf("parser errors for %q: %v", input, p.Errors())
+    }
+
+    return Optimize(program)
+}
+
+func TestFolding(t *testing.T) {
+    tests := []struct {
+        input string
+        expected string
+    }{
+        {`2 * 60 * 60`, `7200`},
+        {`-5 + 2`, `-3`},
+        {`2.5 * 2`, `5.0`},
+        {`!true`, `false`},
+        {`1 < 2 == true`, `true`},
+        {`"a" + "b" + "c"`, `abc`},
+        {`x * (2 * 3)`, `(x * 6)`},
+        {`len("x" + "y")`, `len(xy)`},
+        // failing operations fail when they run
+        {`1 / 0`, `(1 / 0)`},
+        {`1 + "a"`, `(1 + a)`},
+    }
+
+    for _, tt := range tests {
+        if actual := testOptimize(t, tt.input).String(); actual != tt.expected {
+            t.Errorf("%s: wrong program. want=%q, got=%q", tt.input, tt.expected, actual)
+        }
+    }
+}
+
+func TestPruning(t *testing.T) {
+    tests := []struct {
+        input string
+        expected string
+    }{
+        {`if (1 < 2) { a } else { b }`, `a`},
+        {`if (null) { a } else { b }`, `b`},
+        {`if (false) { a }`, `null`},
+        {`if (0) { a } else { b; c }`, `a`},
+        {`if (false) { a } else { b; c }`, `if true bc`},
+        {`if (x) { 1 + 1 }`, `if x 2`},
+        // lets make names local to the whole function even when not run
+        {`if (false) { let y = 1; y }`, `if false let y = 1;y`},
+        {`if (true) { a } else { let y = 1; y }`, `if true aelse let y = 1;y`},
+    }
+
+    for _, tt := range tests {
+        if actual := testOptimize(t, tt.input).String(); actual != tt.expected {
+            t.Errorf("%s: wrong program. want=%q, got=%q", tt.input, tt.expected, actual)
+        }
+    }
+}
+
+func TestInlining(t *testing.T) {
+    tests := []struct {
+        input string
+        expected string
+    }{
+        {`let k = 60; k * k`, `let k = 60;3600`},
+        {`let a = 1; let b = a + 1; [a, b, {a: b}[a]]`, `let a = 1;let b = 2;[1, 2, ({1: 2}[1])]`},
+        {`let f = fn(x) { let k = 2; let g = fn(y) { y * k }; g(x) * k }`, `let f = fn(x)let k = 2;let g = fn(y)(y * 2);(g(x) * 2);`},
+        {`let f = fn() { let k = 1; try { k } catch (e) { k } }`, `let f = fn()let k = 1;try 1 catch (e) 1;`},
+        // before the let the name may be something else
+        {`k; let k = 1;`, `klet k = 1;`},
+        {`let f = fn() { k; let k = 1; }`, `let f = fn()klet k = 1;;`},
+        // names bound more than once
+        {`let k = 1; let k = 2; k`, `let k = 1;let k = 2;k`},
+        {`let f = fn() { let k = 1; try { k } catch (k) { k } }`, `let f = fn()let k = 1;try k catch (k) k;`},
+        {`let f = fn() { let k = 1; if (x) { let k = 2 }; k }`, `let f = fn()let k = 1;if x let k = 2;k;`},
+        {`let f = fn() { let k = 1; let g = fn(k) { k }; k }`, `let f = fn()let k = 1;let g = fn(k)k;1;`},
+        // later programs may bind globals again
+        {`let k = 1; let f = fn() { k }`, `let k = 1;let f = fn()k;`},
+        // values that are not literals, or allocate when evaluated
+        {`let x = 1 / 0; x`, `let x = (1 / 0);x`},
+        {`let s = "a"; s + "b"`, `let s = a;(s + b)`},
+    }
+
+    for _, tt := range tests {
+        if actual := testOptimize(t, tt.input).String(); actual != tt.expected {
+            t.Errorf("%s: wrong program. want=%q, got=%q", tt.input, tt.expected, actual)
+        }
+    }
+}
+
+// optimized programs give the same results and errors
+func TestCorpus(t *testing.T) {
+    enginetest.Run(t, func(input string) object.Object {
+        program := testOptimize(t, input)
+        env := object.NewEnvironment()
+        resolver.Resolve(program, env)
+
+        return evaluator.Eval(program, env)
+    })
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"monkey/ast"
 	"monkey/lexer"
+	"monkey/object"
 	"monkey/token"
 	"strconv"
 )
@@ -57,6 +58,8 @@ func New(l *lexer.Lexer) *Parser {
     p.prefixParseFns = make(map [token.TokenType]prefixParseFn)
     p.registerPrefix(token.IDENT, p.parseIdentifier)
     p.registerPrefix(token.INT, p.parserIntegerLiteral)
+    p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
+    p.registerPrefix(token.NULL, p.parseNullLiteral)
     p.registerPrefix(token.BANG, p.parsePrefixExpression)
     p.registerPrefix(token.MINUS, p.parsePrefixExpression)
     p.registerPrefix(token.TRUE, p.parseBoolean)
@@ -67,6 +70,7 @@ func New(l *lexer.Lexer) *Parser {
     p.registerPrefix(token.STRING, p.parseStringLiteral)
     p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
     p.registerPrefix(token.LBRACE, p.parseHashLiteral)
+    p.registerPrefix(token.TRY, p.parseTryExpression)
 
     p.infixParseFns = make(map [token.TokenType]infixParseFn)
     p.registerInfix(token.PLUS, p.parseInfixExpression)
@@ -120,18 +124,21 @@ func (p *Parser) expectPeek(t token.TokenType) bool {
     }
 }
 
-func (p *Parser) peekPrecedence() int {
-    if p, ok := precedences[p.peekToken.Type]; ok {
+// Precedence returns how tightly the infix operator of type t binds, LOWEST
+// for tokens that are no operator
+func Precedence(t token.TokenType) int {
+    if p, ok := precedences[t]; ok {
         return p
     }
     return LOWEST
 }
 
+func (p *Parser) peekPrecedence() int {
+    return Precedence(p.peekToken.Type)
+}
+
 func (p *Parser) currentPrecedence() int {
-    if p, ok := precedences[p.currentToken.Type]; ok {
-        return p
-    }
-    return LOWEST
+    return Precedence(p.currentToken.Type)
 }
 
 func (p *Parser) registerPrefix(tokenType token.TokenType, fn prefixParseFn) {
@@ -164,6 +171,25 @@ func (p *Parser) parserIntegerLiteral() ast.Expression {
     return literal
 }
 
+func (p *Parser) parseFloatLiteral() ast.Expression {
+    literal := &ast.FloatLiteral{Token: p.currentToken}
+
+    value, err := strconv.ParseFloat(p.currentToken.Literal, 64)
+    if err != nil {
+        msg := fmt.Sprintf("could not parse %q as float...", p.currentToken.Literal)
+        p.errors = append(p.errors, msg)
+        return nil
+    }
+
+    literal.Value = value
+
+    return literal
+}
+
+func (p *Parser) parseNullLiteral() ast.Expression {
+    return &ast.NullLiteral{Token: p.currentToken}
+}
+
 func (p *Parser) parseBoolean() ast.Expression {
     return &ast.Boolean{
         Token: p.currentToken,
@@ -188,7 +214,7 @@ func (p *Parser) parseArrayLiteral() ast.Expression {
 
 func (p *Parser) parseHashLiteral() ast.Expression {
     hash := &ast.HashLiteral{Token: p.currentToken}
-    hash.Pairs = make(map [ast.Expression]ast.Expression)
+    hash.Pairs = []ast.HashPair{}
 
     for !p.peekTokenIs(token.RBRACE) {
         p.nextToken()
@@ -202,7 +228,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 
         value := p.parseExpression(LOWEST)
 
-        hash.Pairs[key] = value
+        hash.Pairs = append(hash.Pairs, ast.HashPair{Key: key, Value: value})
 
         if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
             return nil
@@ -237,6 +263,11 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 
     statement.Value = p.parseExpression(LOWEST)
 
+    // name the function after its binding so that error stacks can refer to it
+    if fl, ok := statement.Value.(*ast.FunctionLiteral); ok {
+        fl.Name = statement.Name.Value
+    }
+
     if p.peekTokenIs(token.SEMICOLON) {
         p.nextToken()
     }
@@ -258,6 +289,20 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
     return statement
 }
 
+func (p *Parser) parseThrowStatement() *ast.ThrowStatement {
+    statement := &ast.ThrowStatement{Token: p.currentToken}
+
+    p.nextToken()
+
+    statement.Value = p.parseExpression(LOWEST)
+
+    if p.peekTokenIs(token.SEMICOLON) {
+        p.nextToken()
+    }
+
+    return statement
+}
+
 func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
     stmt := &ast.ExpressionStatement {Token: p.currentToken}
 
@@ -352,6 +397,88 @@ func (p *Parser) parseIfExpression() ast.Expression {
     return expression
 }
 
+func (p *Parser) parseTryExpression() ast.Expression {
+    expression := &ast.TryExpression{
+        Token: p.currentToken,
+    }
+
+    if !p.expectPeek(token.LBRACE) {
+        return nil
+    }
+
+    expression.Block = p.parseBlockStatement()
+
+    for p.peekTokenIs(token.CATCH) {
+        p.nextToken()
+
+        clause := p.parseCatchClause()
+        if clause == nil {
+            return nil
+        }
+        expression.Catches = append(expression.Catches, clause)
+    }
+
+    if p.peekTokenIs(token.FINALLY) {
+        p.nextToken()
+
+        if !p.expectPeek(token.LBRACE) {
+            return nil
+        }
+
+        expression.Finally = p.parseBlockStatement()
+    }
+
+    if len(expression.Catches) == 0 && expression.Finally == nil {
+        msg := fmt.Sprintf("Expected catch or finally after try block, got %s instead...", p.peekToken.Type)
+        p.errors = append(p.errors, msg)
+        return nil
+    }
+
+    return expression
+}
+
+func (p *Parser) parseCatchClause() *ast.CatchClause {
+    clause := &ast.CatchClause{
+        Token: p.currentToken,
+    }
+
+    // the error binding is optional, and may be preceded by the kind of
+    // errors to catch as in `catch (TypeError e)`
+    if p.peekTokenIs(token.LPAREN) {
+        p.nextToken()
+
+        if !p.expectPeek(token.IDENT) {
+            return nil
+        }
+
+        clause.Parameter = &ast.Identifier{Token: p.currentToken, Value: p.currentToken.Literal}
+
+        if p.peekTokenIs(token.IDENT) {
+            p.nextToken()
+            clause.Kind = clause.Parameter
+            clause.Parameter = &ast.Identifier{Token: p.currentToken, Value: p.currentToken.Literal}
+
+            // a misspelt kind would silently never match
+            if !object.ErrorKind(clause.Kind.Value).Valid() {
+                msg := fmt.Sprintf("Unknown error kind %s in catch clause", clause.Kind.Value)
+                p.errors = append(p.errors, msg)
+            }
+        }
+
+        if !p.expectPeek(token.RPAREN) {
+            return nil
+        }
+    }
+
+    if !p.expectPeek(token.LBRACE) {
+        return nil
+    }
+
+    clause.Body = p.parseBlockStatement()
+
+    return clause
+}
+
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
     block := &ast.BlockStatement{
         Token: p.currentToken,
@@ -367,6 +494,7 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
         }
         p.nextToken()
     }
+    block.End = p.currentToken
 
     return block
 }
@@ -399,10 +527,42 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
     }
 
     literal.Body = p.parseBlockStatement()
+    markTailCalls(literal.Body, true)
 
     return literal
 }
 
+// markTailCalls flags the calls whose value becomes the result of the function
+// they are in, so the evaluator can run them without nesting: the last
+// expression of the body and returned values, also inside if branches. When
+// last is false, only the returns of block count, since its value is unused.
+// try blocks are skipped because their catch and finally clauses still have
+// to run after the call.
+func markTailCalls(block *ast.BlockStatement, last bool) {
+    if block == nil {
+        return
+    }
+
+    for i, statement := range block.Statements {
+        switch statement := statement.(type) {
+        case *ast.ReturnStatement:
+            markTailExpression(statement.ReturnValue, true)
+        case *ast.ExpressionStatement:
+            markTailExpression(statement.Expression, last && i == len(block.Statements)-1)
+        }
+    }
+}
+
+func markTailExpression(exp ast.Expression, tail bool) {
+    switch exp := exp.(type) {
+    case *ast.CallExpression:
+        exp.Tail = tail
+    case *ast.IfExpression:
+        markTailCalls(exp.Consequence, tail)
+        markTailCalls(exp.Alternative, tail)
+    }
+}
+
 func (p *Parser) parseFunctionParameters() []*ast.Identifier {
     identifiers := []*ast.Identifier{}
 
@@ -508,6 +668,8 @@ func (p *Parser) parseStatement() ast.Statement {
             return p.parseLetStatement()
         case token.RETURN:
             return p.parseReturnStatement()
+        case token.THROW:
+            return p.parseThrowStatement()
     default:
         return p.parseExpressionStatement()
     }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"monkey/ast"
 	"monkey/lexer"
+	"monkey/token"
 	"testing"
 )
 
@@ -16,6 +17,7 @@ func TestLetStatements(t *testing.T) {
         {"let x = 5;", "x", 5},
         {"let y = true;", "y", true},
         {"let foobar = y;", "foobar", "y"},
+        {"let x2y = _1;", "x2y", "_1"},
     }
 
     for _, tt := range tests {
@@ -163,6 +165,43 @@ func TestIntegerLiteralExpression(t *testing.T) {
 
 }
 
+func TestFloatLiteralExpression(t *testing.T) {
+    input := "3.25;"
+
+    l := lexer.New(input)
+    p := New(l)
+    program := p.ParseProgram()
+    checkParserErrors(t, p)
+
+    stmt := program.Statements[0].(*ast.ExpressionStatement)
+    literal, ok := stmt.Expression.(*ast.FloatLiteral)
+    if !ok {
+        t.Fatalf("exp is not of type *ast.FloatLiteral. Got %T instead.", stmt.Expression)
+    }
+
+    if literal.Value != 3.25 {
+        t.Errorf("literal.Value is not %f. Got %f instead.", 3.25, literal.Value)
+    }
+}
+
+func TestNullLiteralExpression(t *testing.T) {
+    input := "let x = null;"
+
+    l := lexer.New(input)
+    p := New(l)
+    program := p.ParseProgram()
+    checkParserErrors(t, p)
+
+    stmt := program.Statements[0].(*ast.LetStatement)
+    if _, ok := stmt.Value.(*ast.NullLiteral); !ok {
+        t.Fatalf("exp is not of type *ast.NullLiteral. Got %T instead.", stmt.Value)
+    }
+
+    if program.String() != "let x = null;" {
+        t.Errorf("program.String() wrong. got=%q", program.String())
+    }
+}
+
 func TestPrefixParsingExpressions(t *testing.T) {
     prefixTests := [] struct{
         input string
@@ -523,6 +562,22 @@ func TestIfElseExpression(t *testing.T) {
 	}
 }
 
+func TestBlockStatementEnd(t *testing.T) {
+    input := "if (x) {\n  x\n} else {\n\n}"
+
+    p := New(lexer.New(input))
+    program := p.ParseProgram()
+    checkParserErrors(t, p)
+
+    exp := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.IfExpression)
+    if exp.Consequence.End.Type != token.RBRACE || exp.Consequence.End.Line != 3 {
+        t.Errorf("wrong end of consequence. got=%+v", exp.Consequence.End)
+    }
+    if exp.Alternative.End.Line != 5 {
+        t.Errorf("wrong end of alternative. got=%+v", exp.Alternative.End)
+    }
+}
+
 func TestFunctionLiteralParsing(t *testing.T) {
     input := `fn(x, y) { x + y; }`
 
@@ -735,14 +790,44 @@ func TestParsingHashLiteralsStringKeys(t *testing.T) {
         "three": 3,
     }
 
-    for key, value := range hash.Pairs {
-        literal, ok := key.(*ast.StringLiteral)
+    for _, pair := range hash.Pairs {
+        literal, ok := pair.Key.(*ast.StringLiteral)
         if !ok {
-            t.Errorf("key is not ast.StringLiteral. got=%T", key)
+            t.Errorf("key is not ast.StringLiteral. got=%T", pair.Key)
         }
 
         expectedValue := expected[literal.String()]
-        testIntegerLiteral(t, value, expectedValue)
+        testIntegerLiteral(t, pair.Value, expectedValue)
+    }
+}
+
+func TestParsingHashLiteralsKeepsSourceOrder(t *testing.T) {
+    input := `{"b": 1, "a": 2, 3: 3, true: 4}`
+
+    l := lexer.New(input)
+    p := New(l)
+    program := p.ParseProgram()
+    checkParserErrors(t, p)
+
+    stmt := program.Statements[0].(*ast.ExpressionStatement)
+    hash, ok := stmt.Expression.(*ast.HashLiteral)
+    if !ok {
+        t.Fatalf("exp is not ast.HashLiteral. got=%T", stmt.Expression)
+    }
+
+    expectedKeys := []string{"b", "a", "3", "true"}
+    if len(hash.Pairs) != len(expectedKeys) {
+        t.Fatalf("hash.Pairs has wrong length. got=%d", len(hash.Pairs))
+    }
+
+    for i, pair := range hash.Pairs {
+        if pair.Key.String() != expectedKeys[i] {
+            t.Errorf("pair %d has wrong key. expected=%q, got=%q", i, expectedKeys[i], pair.Key.String())
+        }
+    }
+
+    if hash.String() != "{b: 1, a: 2, 3: 3, true: 4}" {
+        t.Errorf("hash.String() wrong. got=%q", hash.String())
     }
 }
 
@@ -795,10 +880,10 @@ func TestParshingHashLiteralsWithExpressions(t *testing.T) {
         },
     }
 
-    for key, value := range hash.Pairs {
-        literal, ok := key.(*ast.StringLiteral)
+    for _, pair := range hash.Pairs {
+        literal, ok := pair.Key.(*ast.StringLiteral)
         if !ok {
-            t.Errorf("key is not ast.StringLiteral. got=%T", key)
+            t.Errorf("key is not ast.StringLiteral. got=%T", pair.Key)
             continue
         }
 
@@ -808,6 +893,147 @@ func TestParshingHashLiteralsWithExpressions(t *testing.T) {
             continue
         }
 
-        testFunc(value)
+        testFunc(pair.Value)
+    }
+}
+
+func TestTryExpressionParsing(t *testing.T) {
+    tests := []struct {
+        input string
+        expected string
+    }{
+        {`try { x } catch (e) { y }`, "try x catch (e) y"},
+        {`try { x } finally { z }`, "try x finally z"},
+        {`try { x } catch { y } finally { z }`, "try x catch y finally z"},
+        {`let r = try { f(1) } catch (err) { err["message"] };`, "let r = try f(1) catch (err) (err[message]);"},
+        {`try { x } catch (TypeError e) { y } catch (e) { z }`, "try x catch (TypeError e) y catch (e) z"},
+    }
+
+    for _, tt := range tests {
+        l := lexer.New(tt.input)
+        p := New(l)
+        program := p.ParseProgram()
+        checkParserErrors(t, p)
+
+        if program.String() != tt.expected {
+            t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+        }
+    }
+}
+
+func TestTryExpressionErrors(t *testing.T) {
+    tests := []string{
+        `try { x }`,
+        `try { x } catch (1) { y }`,
+        `try { x } catch (e { y }`,
+        `try { x } catch (TypeErorr e) { y }`,
+    }
+
+    for _, input := range tests {
+        l := lexer.New(input)
+        p := New(l)
+        p.ParseProgram()
+
+        if len(p.Errors()) == 0 {
+            t.Errorf("expected parser errors for %q", input)
+        }
+    }
+}
+
+func TestThrowStatement(t *testing.T) {
+    input := `throw "oops";`
+
+    l := lexer.New(input)
+    p := New(l)
+    program := p.ParseProgram()
+    checkParserErrors(t, p)
+
+    stmt, ok := program.Statements[0].(*ast.ThrowStatement)
+    if !ok {
+        t.Fatalf("stmt not *ast.ThrowStatement. got=%T", program.Statements[0])
+    }
+
+    if stmt.Value.String() != "oops" {
+        t.Errorf("stmt.Value wrong. got=%q", stmt.Value.String())
+    }
+}
+
+func TestFunctionLiteralWithName(t *testing.T) {
+    input := `let myFunction = fn() { };`
+
+    l := lexer.New(input)
+    p := New(l)
+    program := p.ParseProgram()
+    checkParserErrors(t, p)
+
+    stmt := program.Statements[0].(*ast.LetStatement)
+    function, ok := stmt.Value.(*ast.FunctionLiteral)
+    if !ok {
+        t.Fatalf("stmt.Value is not ast.FunctionLiteral. got=%T", stmt.Value)
+    }
+
+    if function.Name != "myFunction" {
+        t.Errorf("function literal name wrong. want 'myFunction', got=%q", function.Name)
+    }
+}
+
+func TestTailCallMarking(t *testing.T) {
+    input := `
+    fn(n) {
+        a();
+        if (n) { return b(); }
+        let x = c();
+        try { d() } catch (e) { e() };
+        if (n) { f() } else { g() + h() }
+    }
+    `
+    expected := map[string]bool{"a": false, "b": true, "c": false, "d": false, "e": false, "f": true, "g": false, "h": false}
+
+    program := New(lexer.New(input)).ParseProgram()
+    calls := map[string]bool{}
+    collectCalls(program.Statements[0].(*ast.ExpressionStatement).Expression, calls)
+
+    for name, tail := range expected {
+        got, ok := calls[name]
+        if !ok {
+            t.Errorf("call to %s not found", name)
+            continue
+        }
+        if got != tail {
+            t.Errorf("call to %s: Tail=%t, want %t", name, got, tail)
+        }
+    }
+}
+
+// collectCalls records the Tail flag of every call to a named function in node
+func collectCalls(node ast.Node, calls map[string]bool) {
+    switch node := node.(type) {
+    case *ast.FunctionLiteral:
+        collectCalls(node.Body, calls)
+    case *ast.BlockStatement:
+        for _, statement := range node.Statements {
+            collectCalls(statement, calls)
+        }
+    case *ast.ExpressionStatement:
+        collectCalls(node.Expression, calls)
+    case *ast.ReturnStatement:
+        collectCalls(node.ReturnValue, calls)
+    case *ast.LetStatement:
+        collectCalls(node.Value, calls)
+    case *ast.IfExpression:
+        collectCalls(node.Consequence, calls)
+        if node.Alternative != nil {
+            collectCalls(node.Alternative, calls)
+        }
+    case *ast.TryExpression:
+        collectCalls(node.Block, calls)
+        for _, clause := range node.Catches {
+            collectCalls(clause.Body, calls)
+        }
+    case *ast.InfixExpression:
+        collectCalls(node.Left, calls)
+        collectCalls(node.Right, calls)
+    case *ast.CallExpression:
+        calls[node.Function.String()] = node.Tail
     }
 }
@@ -0,0 +1,238 @@
+package repl
+
+import (
+	"fmt"
+	"io"
+	"monkey/ast"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/token"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// commands control the session rather than being evaluated. They start with
+// a colon and take the rest of the line as their argument.
+type command struct {
+    name string
+    usage string
+    help string
+    run func(s *session, arg string)
+    files bool                  // reads or writes files, so not offered to remote sessions
+}
+
+var commands = []command{
+    {"env", "", "list the bindings of the session", (*session).listBindings, false},
+    {"reset", "", "forget all bindings and inputs", (*session).reset, false},
+    {"load", "file", "evaluate the contents of file", (*session).load, true},
+    {"save", "file", "write the inputs evaluated without errors to file", (*session).save, true},
+    {"tokens", "expr", "show the tokens the lexer makes of expr", (*session).tokens, false},
+    {"ast", "expr", "show the syntax tree the parser makes of expr", (*session).ast, false},
+    {"time", "expr", "evaluate expr and show how long it took", (*session).time, false},
+    {"type", "expr", "evaluate expr and show the type of its value", (*session).typeOf, false},
+    {"color", "on|off", "turn colouring results by type on or off", (*session).setColor, false},
+    {"help", "", "show this help", nil, false},
+}
+
+// commands returns the commands offered to the session
+func (s *session) commands() []command {
+    if !s.remote {
+        return commands
+    }
+
+    var offered []command
+    for _, c := range commands {
+        if !c.files {
+            offered = append(offered, c)
+        }
+    }
+    return offered
+}
+
+func isCommand(line string) bool {
+    return strings.HasPrefix(strings.TrimSpace(line), ":")
+}
+
+// command runs the command on line
+func (s *session) command(line string) {
+    name, arg, _ := strings.Cut(strings.TrimSpace(line)[1:], " ")
+    arg = strings.TrimSpace(arg)
+
+    if name == "help" {
+        s.help()
+        return
+    }
+
+    for _, c := range s.commands() {
+        if c.name != name {
+            continue
+        }
+        if c.usage != "" && arg == "" {
+            fmt.Fprintf(s.out, "usage: :%s %s\n", c.name, c.usage)
+            return
+        }
+        c.run(s, arg)
+        return
+    }
+
+    fmt.Fprintf(s.out, "unknown command :%s, :help lists the commands\n", name)
+}
+
+func (s *session) help() {
+    for _, c := range s.commands() {
+        fmt.Fprintf(s.out, "  %-14s %s\n", strings.TrimSpace(":" + c.name + " " + c.usage), c.help)
+    }
+}
+
+func (s *session) listBindings(string) {
+    for _, name := range s.env.Names() {
+        if isResult(name) {
+            continue
+        }
+        val, _ := s.env.Get(name)
+        fmt.Fprintf(s.out, "%s = %s\n", name, s.printer.print(val))
+    }
+}
+
+func (s *session) reset(string) {
+    s.env = object.NewEnclosedEnvironment(s.builtins)
+    s.inputs = nil
+    s.results = nil
+    io.WriteString(s.out, "session reset\n")
+}
+
+func (s *session) load(path string) {
+    src, err := os.ReadFile(path)
+    if err != nil {
+        fmt.Fprintln(s.out, err)
+        return
+    }
+    s.eval(string(src))
+}
+
+func (s *session) save(path string) {
+    var src strings.Builder
+    for _, input := range s.inputs {
+        src.WriteString(strings.TrimRight(input, "\n") + "\n")
+    }
+
+    if err := os.WriteFile(path, []byte(src.String()), 0644); err != nil {
+        fmt.Fprintln(s.out, err)
+        return
+    }
+    fmt.Fprintf(s.out, "saved %d inputs to %s\n", len(s.inputs), path)
+}
+
+func (s *session) tokens(src string) {
+    l := lexer.New(src)
+    for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+        fmt.Fprintf(s.out, "%-9s %s\n", tok.Type, tok.Literal)
+    }
+}
+
+func (s *session) ast(src string) {
+    p := parser.New(lexer.New(src))
+    program := p.ParseProgram()
+    if len(p.Errors()) != 0 {
+        printParserErrors(s.out, p.Errors())
+        return
+    }
+    dumpNode(s.out, "", reflect.ValueOf(program), 0)
+}
+
+func (s *session) time(src string) {
+    start := time.Now()
+    result, ok := s.run(src)
+    elapsed := time.Since(start)
+
+    if !ok {
+        return
+    }
+    if result != nil {
+        s.show(result)
+    }
+    fmt.Fprintf(s.out, "took %s\n", elapsed)
+}
+
+func (s *session) typeOf(src string) {
+    result, ok := s.run(src)
+    if !ok {
+        return
+    }
+    if result == nil {
+        result = object.NULL
+    }
+    fmt.Fprintln(s.out, result.Type())
+}
+
+func (s *session) setColor(arg string) {
+    switch arg {
+    case "on":
+        s.printer.color = true
+    case "off":
+        s.printer.color = false
+    default:
+        io.WriteString(s.out, "usage: :color on|off\n")
+    }
+}
+
+var nodeType = reflect.TypeOf((*ast.Node)(nil)).Elem()
+
+// dumpNode writes the node in v as a line naming its type and its fields
+// holding plain values, followed by its children, indented. Tokens are left
+// out, as are fields not set.
+func dumpNode(out io.Writer, label string, v reflect.Value, depth int) {
+    for v.Kind() == reflect.Interface || v.Kind() == reflect.Pointer {
+        if v.IsNil() {
+            return
+        }
+        v = v.Elem()
+    }
+
+    fmt.Fprintf(out, "%s%s%s", strings.Repeat("  ", depth), label, v.Type().Name())
+
+    type child struct {
+        label string
+        v reflect.Value
+    }
+    var children []child
+
+    for i := 0; i < v.NumField(); i++ {
+        field, f := v.Type().Field(i), v.Field(i)
+        if field.Type == reflect.TypeOf(token.Token{}) {
+            continue
+        }
+        // only missing nodes are left out, zero values like false are shown
+        switch f.Kind() {
+        case reflect.Pointer, reflect.Interface:
+            if f.IsNil() {
+                continue
+            }
+        case reflect.Slice:
+            if f.Len() == 0 {
+                continue
+            }
+        }
+
+        switch {
+        case f.Kind() == reflect.Slice:
+            for j := 0; j < f.Len(); j++ {
+                children = append(children, child{fmt.Sprintf("%s[%d]: ", field.Name, j), f.Index(j)})
+            }
+        case field.Type.Implements(nodeType) || f.Kind() == reflect.Pointer || f.Kind() == reflect.Struct:
+            children = append(children, child{field.Name + ": ", f})
+        case f.Kind() == reflect.String:
+            fmt.Fprintf(out, " %s=%q", field.Name, f.String())
+        default:
+            fmt.Fprintf(out, " %s=%v", field.Name, f.Interface())
+        }
+    }
+    io.WriteString(out, "\n")
+
+    for _, c := range children {
+        dumpNode(out, c.label, c.v, depth+1)
+    }
+}
@@ -0,0 +1,105 @@
+package repl
+
+import (
+	"monkey/object"
+	"strings"
+)
+
+const MAX_WIDTH = 80    // columns a result may take before it is split over lines
+const MAX_DEPTH = 6     // how deep nested arrays and hashes are shown
+
+// printer formats the results the REPL shows. Unlike Inspect, strings are
+// quoted, and arrays, sets and hashes too wide for a line put each element on
+// a line of its own, indented by their nesting. Nesting deeper than depth is
+// left out as [...].
+type printer struct {
+    width int
+    depth int
+    color bool              // mark values with ANSI colours by their type
+}
+
+// the SGR parameters of the colour for each type, types left out are plain
+var colors = map[object.ObjectType]string{
+    object.INTEGER_OBJ: "36",
+    object.FLOAT_OBJ: "36",
+    object.STRING_OBJ: "32",
+    object.BOOLEAN_OBJ: "33",
+    object.NULL_OBJ: "90",
+    object.ERROR_OBJ: "31",
+    object.FUNCTION_OBJ: "35",
+    object.COMPILED_FUNCTION_OBJ: "35",
+    object.BUILTIN_OBJ: "35",
+}
+
+func (p *printer) print(obj object.Object) string {
+    return p.format(obj, "", 0)
+}
+
+// format returns obj as shown at nesting level, with lines after the first
+// starting with indent
+func (p *printer) format(obj object.Object, indent string, level int) string {
+    var open, close string
+    var elems []object.Object
+    var pairs []object.HashPair
+
+    switch obj := obj.(type) {
+    case *object.Array:
+        open, close, elems = "[", "]", obj.Elements
+    case *object.Set:
+        open, close, elems = "set([", "])", obj.Elements()
+    case *object.Hash:
+        open, close, pairs = "{", "}", obj.OrderedPairs()
+    default:
+        return p.scalar(obj)
+    }
+
+    if len(elems) + len(pairs) == 0 {
+        return open + close
+    }
+    if level >= p.depth {
+        return open + "..." + close
+    }
+
+    inner := indent + "  "
+    items := []string{}
+    for _, elem := range elems {
+        items = append(items, p.format(elem, inner, level+1))
+    }
+    for _, pair := range pairs {
+        items = append(items, p.format(pair.Key, inner, level+1) + ": " + p.format(pair.Value, inner, level+1))
+    }
+
+    line := open + strings.Join(items, ", ") + close
+    if !strings.Contains(line, "\n") && len(indent) + visibleLen(line) <= p.width {
+        return line
+    }
+    return open + "\n" + inner + strings.Join(items, ",\n" + inner) + "\n" + indent + close
+}
+
+func (p *printer) scalar(obj object.Object) string {
+    s := obj.Inspect()
+    if str, ok := obj.(*object.String); ok {
+        // strings cannot contain quotes, so this reads back as the same string
+        s = "\"" + str.Value + "\""
+    }
+
+    if color, ok := colors[obj.Type()]; ok && p.color {
+        return "\x1b[" + color + "m" + s + "\x1b[0m"
+    }
+    return s
+}
+
+// visibleLen is the length of s on screen, without the colour escapes
+func visibleLen(s string) int {
+    n := 0
+    for i := 0; i < len(s); i++ {
+        if s[i] == '\x1b' {
+            for i < len(s) && s[i] != 'm' {
+                i++
+            }
+            continue
+        }
+        n++
+    }
+    return n
+}
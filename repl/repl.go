@@ -1,51 +1,285 @@
 package repl
 
 import (
-	"bufio"
+	"context"
 	"fmt"
 	"io"
 	"monkey/evaluator"
 	"monkey/lexer"
+	"monkey/lineedit"
 	"monkey/object"
 	"monkey/parser"
+	"monkey/resolver"
+	"monkey/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 )
 
 const PROMPT = "MONKE->> "
+const CONTINUATION_PROMPT = "    ...> "  // asks for the rest of incomplete input
+const HISTORY_FILE = ".monkey_history"  // in the home directory
+const RESULTS = 9                       // how many results _1 and on keep
 
+// Start reads lines from in and evaluates them until in runs out. Input that
+// is not complete yet, see incomplete, is continued on the following lines,
+// until it is or an empty line is entered. Everything, including the output
+// of the I/O builtins, is written to out and input reads from the same reader
+// as the REPL itself.
+//
+// When in is a terminal, lines can be edited, see package lineedit, with Tab
+// completing keywords and the names bound in the session. Lines entered are
+// kept in HISTORY_FILE.
+//
+// Results are shown by a pretty-printer, coloured by type in a terminal unless
+// NO_COLOR is set. The last result is bound to _ and _1, the one before to _2
+// and so on up to RESULTS.
 func Start(in io.Reader, out io.Writer) {
-    scanner := bufio.NewScanner(in)
-    env := object.NewEnvironment()
+    stdio := evaluator.NewIO(in, out, out)
+    s := newSession(stdio, out, nil)
+
+    term, _ := in.(*os.File)
+    editor := lineedit.New(stdio.Stdin, out, term)
+    if editor.Editing() {
+        s.printer.color = os.Getenv("NO_COLOR") == ""
+        if home, err := os.UserHomeDir(); err == nil {
+            editor.LoadHistory(filepath.Join(home, HISTORY_FILE))
+        }
+    }
+
+    s.loop(editor)
+}
+
+// loop reads input with editor and evaluates it, or runs it if it is a
+// command, until input runs out
+func (s *session) loop(editor *lineedit.Editor) {
+    editor.Complete = func(prefix string) []string {
+        return completions(prefix, s.env, s.commands())
+    }
 
     for {
-        fmt.Printf(PROMPT)
-        scanned := scanner.Scan() // will scan an entire line of text as token
-        if !scanned {
-            // error while scanning token or EOF
+        src, err := readInput(editor)
+        if err == lineedit.ErrInterrupted {
+            continue
+        }
+        if err != nil {
+            // error while reading or EOF
             return
         }
 
-        line := scanner.Text() // returns most recent token (line in this case) generated by call to Scan()
-        l := lexer.New(line)
-        p := parser.New(l)
+        if isCommand(src) {
+            s.command(src)
+        } else {
+            s.eval(src)
+        }
+    }
+}
 
-        program := p.ParseProgram()
-        if len(p.Errors()) != 0 {
-            printParserErrors(out, p.Errors())
-            continue
+// session is what the REPL remembers between inputs
+type session struct {
+    out io.Writer
+    builtins *object.Environment
+    env *object.Environment
+    inputs []string             // evaluated without errors, for :save
+    results []object.Object     // newest first, bound to _1 and on
+    printer printer
+    lock sync.Locker            // held while evaluating, if not nil
+    remote bool                 // served to a connection, which must not get at files
+    ctx context.Context         // evaluations stop once it is done
+    limits evaluator.Limits     // bound each evaluation
+    timeout time.Duration       // bounds each evaluation, unless zero
+}
+
+// newSession returns a session whose builtins use stdio. Its environment is
+// enclosed in globals, unless that is nil.
+func newSession(stdio *evaluator.IO, out io.Writer, globals *object.Environment) *session {
+    builtins := object.NewEnclosedEnvironment(globals)
+    for name, builtin := range evaluator.NewBuiltins(stdio) {
+        builtins.Set(name, builtin)
+    }
+
+    return &session{
+        out: out,
+        builtins: builtins,
+        env: object.NewEnclosedEnvironment(builtins),
+        printer: printer{width: MAX_WIDTH, depth: MAX_DEPTH},
+        ctx: context.Background(),
+    }
+}
+
+// eval evaluates src in the session and prints the result
+func (s *session) eval(src string) {
+    if result, _ := s.run(src); result != nil {
+        s.show(result)
+    }
+}
+
+func (s *session) show(result object.Object) {
+    io.WriteString(s.out, s.printer.print(result))
+    io.WriteString(s.out, "\n")
+}
+
+// run evaluates src in the session, remembering it and its result unless it
+// fails. It reports false if src does not parse, after printing the errors.
+func (s *session) run(src string) (object.Object, bool) {
+    p := parser.New(lexer.New(src))
+    program := p.ParseProgram()
+    if len(p.Errors()) != 0 {
+        printParserErrors(s.out, p.Errors())
+        return nil, false
+    }
+
+    // resolving looks at the environment, which may be shared
+    if s.lock != nil {
+        s.lock.Lock()
+        defer s.lock.Unlock()
+    }
+
+    // undefined names may still be defined by later lines
+    resolver.Resolve(program, s.env)
+
+    ctx := s.ctx
+    if s.timeout > 0 {
+        var cancel context.CancelFunc
+        ctx, cancel = context.WithTimeout(ctx, s.timeout)
+        defer cancel()
+    }
+    result := evaluator.EvalContext(ctx, program, s.env, s.limits)
+    if _, ok := result.(*object.Error); !ok {
+        s.inputs = append(s.inputs, src)
+        s.remember(result)
+    }
+    return result, true
+}
+
+// remember binds result to _ and _1, moving the earlier results to _2 and on.
+// Statements without a value and null are not results worth keeping.
+func (s *session) remember(result object.Object) {
+    if result == nil || result == object.NULL {
+        return
+    }
+
+    s.results = append([]object.Object{result}, s.results...)
+    if len(s.results) > RESULTS {
+        s.results = s.results[:RESULTS]
+    }
+
+    s.env.Set("_", result)
+    for i, r := range s.results {
+        s.env.Set(fmt.Sprintf("_%d", i+1), r)
+    }
+}
+
+// isResult reports whether name is one of the names results are bound to
+func isResult(name string) bool {
+    return name == "_" || len(name) > 1 && name[0] == '_' && strings.Trim(name[1:], "0123456789") == ""
+}
+
+// readInput reads lines until they form complete input. It returns io.EOF
+// if input ran out before anything was read.
+func readInput(editor *lineedit.Editor) (string, error) {
+    var src strings.Builder
+    prompt := PROMPT
+
+    for {
+        line, err := editor.ReadLine(prompt)
+        if err == io.EOF && src.Len() > 0 {
+            return src.String(), nil
+        }
+        if err != nil {
+            return "", err
         }
 
-        // for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
-        //     fmt.Printf("%+v\n", tok)
-        // }
+        // commands take a single line
+        if src.Len() == 0 && isCommand(line) {
+            editor.AddHistory(line)
+            return line, nil
+        }
+
+        // an empty line gives up on completing the input, the parser
+        // reports what is missing
+        if src.Len() > 0 && strings.TrimSpace(line) == "" {
+            return src.String(), nil
+        }
 
-        evaluated := evaluator.Eval(program, env)
-        if evaluated != nil {
-            io.WriteString(out, evaluated.Inspect())
-            io.WriteString(out, "\n")
+        editor.AddHistory(line)
+        src.WriteString(line + "\n")
+        if !incomplete(src.String()) {
+            return src.String(), nil
         }
+        prompt = CONTINUATION_PROMPT
     }
 }
 
+// completions returns the keywords and the names bound in env or the
+// environments it is enclosed in that start with prefix, or the names of
+// commands if prefix starts with a colon
+func completions(prefix string, env *object.Environment, commands []command) []string {
+    var names []string
+    if strings.HasPrefix(prefix, ":") {
+        for _, c := range commands {
+            names = append(names, ":" + c.name)
+        }
+    } else {
+        names = token.Keywords()
+        for ; env != nil; env = env.Outer() {
+            names = append(names, env.Names()...)
+        }
+    }
+
+    matching := []string{}
+    for _, name := range names {
+        if strings.HasPrefix(name, prefix) {
+            matching = append(matching, name)
+        }
+    }
+    return matching
+}
+
+// incomplete reports whether src needs more lines to make sense: it has
+// brackets or a string left open, or ends in an operator or a keyword that
+// has to be followed by something
+func incomplete(src string) bool {
+    l := lexer.New(src)
+    depth := 0
+    var last token.Token
+
+    for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+        switch tok.Type {
+        case token.LPAREN, token.LBRACE, token.LBRACKET:
+            depth++
+        case token.RPAREN, token.RBRACE, token.RBRACKET:
+            depth--
+        }
+        last = tok
+    }
+
+    if depth > 0 {
+        return true
+    }
+
+    // strings cannot contain quotes, so an odd number of them outside the
+    // comments the lexer skipped leaves one open
+    quotes := strings.Count(src, "\"")
+    for _, comment := range l.Comments() {
+        quotes -= strings.Count(comment.Text, "\"")
+    }
+    if quotes % 2 == 1 {
+        return true
+    }
+
+    switch last.Type {
+    case token.ASSIGN, token.PLUS, token.MINUS, token.BANG, token.ASTERISK, token.SLASH,
+        token.LT, token.GT, token.EQ, token.NOT_EQ, token.COMMA, token.COLON,
+        token.LET, token.FUNCTION, token.IF, token.ELSE, token.TRY, token.CATCH, token.FINALLY, token.THROW:
+        return true
+    }
+
+    return false
+}
+
 func printParserErrors(out io.Writer, errors []string) {
 	io.WriteString(out, "Woops! We ran into some monkey business here!\n")
 	io.WriteString(out, " parser errors:\n")
@@ -0,0 +1,243 @@
+package repl
+
+import (
+	"bytes"
+	"io"
+	"monkey/evaluator"
+	"monkey/object"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStartWritesToOut(t *testing.T) {
+    in := strings.NewReader("puts(1 + 2)\nprint(\"a\", 1)\nlet name = input(\"name? \")\nAda\neprint(\"hi\", name)\n5 * 5\nmonkey\n")
+    var out bytes.Buffer
+
+    Start(in, &out)
+
+    expected := PROMPT + "3\nnull\n" +
+        PROMPT + "a 1\nnull\n" +
+        PROMPT + "name? " +
+        PROMPT + "hi Ada\nnull\n" +
+        PROMPT + "25\n" +
+        PROMPT + "ERROR: identifier not found: monkey\n" +
+        PROMPT
+    if out.String() != expected {
+        t.Errorf("wrong output.\nexpected=%q\ngot=%q", expected, out.String())
+    }
+}
+
+func TestIncomplete(t *testing.T) {
+    tests := []struct {
+        input string
+        expected bool
+    }{
+        {"1 + 2\n", false},
+        {"let add = fn(a, b) {\n", true},
+        {"let add = fn(a, b) {\n a + b\n}\n", false},
+        {"puts(1,\n", true},
+        {"[1, 2\n", true},
+        {"{\"a\": 1}\n", false},
+        {"\"unterminated\n", true},
+        {"\"two\nlines\"\n", false},
+        {"1 +\n", true},
+        {"let x =\n", true},
+        {"if (x) { 1 } else\n", true},
+        {"1 == \n", true},
+        {"a)\n", false},
+        {"\n", false},
+        // quotes and brackets in comments do not count
+        {"let x = 1 // a \"quote\n", false},
+        {"let f = fn() { 1 } // open {\n", false},
+        {"let f = fn() { // \"open\n", true},
+        {"let s = \"// not a comment\n", true},
+        {"1 + // more\n", true},
+    }
+
+    for _, tt := range tests {
+        if actual := incomplete(tt.input); actual != tt.expected {
+            t.Errorf("incomplete(%q) wrong. expected=%t, got=%t", tt.input, tt.expected, actual)
+        }
+    }
+}
+
+func TestStartMultiLineInput(t *testing.T) {
+    in := strings.NewReader("let add = fn(a, b) {\n  a +\n  b\n}\nadd(1,\n2)\nlet x = (1 +\n\nx\n")
+    var out bytes.Buffer
+
+    Start(in, &out)
+
+    expected := PROMPT + CONTINUATION_PROMPT + CONTINUATION_PROMPT + CONTINUATION_PROMPT +
+        PROMPT + CONTINUATION_PROMPT + "3\n" +
+        PROMPT + CONTINUATION_PROMPT + "Woops! We ran into some monkey business here!\n parser errors:\n"
+    if !strings.HasPrefix(out.String(), expected) {
+        t.Errorf("wrong output.\nexpected prefix=%q\ngot=%q", expected, out.String())
+    }
+
+    // the empty line ended the broken input, the next line is read afresh
+    if !strings.HasSuffix(out.String(), PROMPT + "ERROR: identifier not found: x\n" + PROMPT) {
+        t.Errorf("wrong output after giving up on input. got=%q", out.String())
+    }
+}
+
+func TestCompletions(t *testing.T) {
+    builtins := object.NewEnvironment()
+    builtins.Set("puts", &object.BuiltIn{})
+    builtins.Set("push", &object.BuiltIn{})
+    env := object.NewEnclosedEnvironment(builtins)
+    env.Set("pushed", &object.Integer{Value: 1})
+    env.Set("total", &object.Integer{Value: 2})
+
+    tests := []struct {
+        prefix string
+        expected []string
+    }{
+        {"pu", []string{"pushed", "push", "puts"}},
+        {"t", []string{"throw", "true", "try", "total"}},
+        {"fn", []string{"fn"}},
+        {"zz", []string{}},
+        {":t", []string{":tokens", ":time", ":type"}},
+    }
+
+    for _, tt := range tests {
+        actual := completions(tt.prefix, env, commands)
+        if strings.Join(actual, " ") != strings.Join(tt.expected, " ") {
+            t.Errorf("wrong completions for %q. expected=%q, got=%q", tt.prefix, tt.expected, actual)
+        }
+    }
+}
+
+func TestCommands(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "session.mk")
+
+    tests := []struct {
+        input string
+        expected []string
+    }{
+        {"let a = 2\nlet b = a * 3\n:env\n", []string{"a = 2\nb = 6\n"}},
+        // bindings are shown like results
+        {"let s = \"hi\"\nlet h = {\"k\": s}\n:env\n", []string{"h = {\"k\": \"hi\"}\ns = \"hi\"\n"}},
+        {"let a = 2\n:reset\n:env\na\n", []string{"session reset\n" + PROMPT + PROMPT + "ERROR: identifier not found: a\n"}},
+        {":type 1.5\n:type \"s\"\n:type let x = 1\n", []string{"FLOAT\n", "STRING\n", "NULL\n"}},
+        {":tokens let x = 1;\n", []string{"LET       let\nIDENT     x\n=         =\nINT       1\n;         ;\n"}},
+        {":ast -a + f(1)\n", []string{`Program
+  Statements[0]: ExpressionStatement
+    Expression: InfixExpression Operator="+"
+      Left: PrefixExpression Operator="-"
+        Right: Identifier Value="a" Local=false Depth=0 Slot=0
+      Right: CallExpression Tail=false
+        Function: Identifier Value="f" Local=false Depth=0 Slot=0
+        Arguments[0]: IntegerLiteral Value=1
+`}},
+        {":ast 0; false; \"\"\n", []string{`Program
+  Statements[0]: ExpressionStatement
+    Expression: IntegerLiteral Value=0
+  Statements[1]: ExpressionStatement
+    Expression: Boolean Value=false
+  Statements[2]: ExpressionStatement
+    Expression: StringLiteral Value=""
+`}},
+        {":ast let\n", []string{"parser errors:"}},
+        {":time 6 * 7\n", []string{"42\ntook "}},
+        {"let a = 1\nlet f = fn(x) {\n  x + a\n}\nf(1) / 0\n:save " + path + "\n:reset\n:load " + path + "\nf(41)\n",
+            []string{"saved 2 inputs to " + path + "\n", PROMPT + "42\n"}},
+        {":load\n", []string{"usage: :load file\n"}},
+        {":load " + filepath.Join(path, "missing") + "\n", []string{"not a directory"}},
+        {":what\n", []string{"unknown command :what, :help lists the commands\n"}},
+        {":help\n", []string{"  :load file     evaluate the contents of file\n"}},
+    }
+
+    for _, tt := range tests {
+        var out bytes.Buffer
+        Start(strings.NewReader(tt.input), &out)
+
+        for _, expected := range tt.expected {
+            if !strings.Contains(out.String(), expected) {
+                t.Errorf("output for %q does not contain %q. got=%q", tt.input, expected, out.String())
+            }
+        }
+    }
+
+    saved, err := os.ReadFile(path)
+    if err != nil || string(saved) != "let a = 1\nlet f = fn(x) {\n  x + a\n}\n" {
+        t.Errorf("wrong session saved. got=%q (%v)", saved, err)
+    }
+}
+
+func TestPrinter(t *testing.T) {
+    tests := []struct {
+        input string
+        width int
+        depth int
+        expected string
+    }{
+        {`"a b"`, 80, 6, `"a b"`},
+        {`[1, "two", [3.5, true, null]]`, 80, 6, `[1, "two", [3.5, true, null]]`},
+        {`{"a": [1, 2], "b": {}}`, 80, 6, `{"a": [1, 2], "b": {}}`},
+        {`[[1, [2]], []]`, 80, 1, `[[...], []]`},
+        {`set([1, 2])`, 80, 0, `set([...])`},
+        {`{"name": "monkey", "tags": ["a", "b"]}`, 20, 6, `{
+  "name": "monkey",
+  "tags": ["a", "b"]
+}`},
+        {`[{"a": [1, 2, 3]}, 4]`, 8, 6, `[
+  {
+    "a": [
+      1,
+      2,
+      3
+    ]
+  },
+  4
+]`},
+    }
+
+    for _, tt := range tests {
+        s := newSession(evaluator.NewIO(strings.NewReader(""), io.Discard, io.Discard), io.Discard, nil)
+        result, _ := s.run(tt.input)
+
+        p := printer{width: tt.width, depth: tt.depth}
+        if got := p.print(result); got != tt.expected {
+            t.Errorf("wrong output for %s.\nexpected=%s\ngot=%s", tt.input, tt.expected, got)
+        }
+    }
+}
+
+func TestPrinterColor(t *testing.T) {
+    p := printer{width: 20, depth: 6, color: true}
+    hash := object.NewHash()
+    hash.Set(&object.String{Value: "n"}, &object.Integer{Value: 1})
+    hash.Set(&object.String{Value: "b"}, object.TRUE)
+
+    // the escapes do not count against the width
+    expected := "{\x1b[32m\"n\"\x1b[0m: \x1b[36m1\x1b[0m, \x1b[32m\"b\"\x1b[0m: \x1b[33mtrue\x1b[0m}"
+    if got := p.print(hash); got != expected {
+        t.Errorf("wrong output.\nexpected=%q\ngot=%q", expected, got)
+    }
+}
+
+func TestResults(t *testing.T) {
+    tests := []struct {
+        input string
+        expected string
+    }{
+        {"1\n2\n_ + _2\n", "3\n"},
+        {"1\n2\n3\n[_1, _2, _3]\n", "[3, 2, 1]\n"},
+        {"\"a\"\nlet x = 1\nputs(x)\n_\n", "\"a\"\n"},
+        {"1\n1 / 0\n_\n", "division by zero: 1 / 0\n" + PROMPT + "1\n"},
+        {"1\n:reset\n_\n", "identifier not found: _\n"},
+        {"5\n:env\n", PROMPT + "5\n" + PROMPT},
+        {"[1]\n:color on\n_\n:color off\n_\n:color blue\n", "[\x1b[36m1\x1b[0m]\n" + PROMPT + PROMPT + "[1]\n" + PROMPT + "usage: :color on|off\n"},
+    }
+
+    for _, tt := range tests {
+        var out bytes.Buffer
+        Start(strings.NewReader(tt.input), &out)
+
+        if !strings.HasSuffix(out.String(), tt.expected + PROMPT) {
+            t.Errorf("output for %q does not end in %q. got=%q", tt.input, tt.expected + PROMPT, out.String())
+        }
+    }
+}
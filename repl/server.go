@@ -0,0 +1,128 @@
+package repl
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"io"
+	"monkey/evaluator"
+	"monkey/lineedit"
+	"monkey/object"
+	"net"
+	"sync"
+	"time"
+)
+
+// Server serves the REPL to the connections of a listener, so that a program
+// embedding Monkey can be attached to and its state looked at while it runs.
+// Each connection gets a session of its own, with the commands of Start that
+// do not touch files but without line editing, and its own builtins writing
+// to the connection.
+//
+//  srv := &repl.Server{Token: token, Globals: interpreter.Env()}
+//  go srv.ListenAndServe("unix", "/run/service/monkey.sock")
+type Server struct {
+    // Token, unless empty, has to be entered on a connection before anything
+    // else, otherwise it is closed. It may only be empty on Unix sockets and
+    // loopback addresses.
+    Token string
+
+    // Globals, unless nil, is the environment sessions are enclosed in. They
+    // see its bindings, and can change the values bound, but their own let
+    // statements stay in the session. Only one session evaluates at a time,
+    // the program owning Globals has to keep from changing them meanwhile.
+    Globals *object.Environment
+
+    // Limits bound every input a session evaluates, and Timeout how long it
+    // may take, TIMEOUT if zero. Evaluations also stop once writing to their
+    // connection fails because the client went away.
+    Limits evaluator.Limits
+    Timeout time.Duration
+
+    mu sync.Mutex           // held by the session evaluating, see Globals
+}
+
+// ErrNoToken is returned by Serve for a listener reachable from other hosts
+// when the server has no Token
+var ErrNoToken = errors.New("repl: serving on a non-loopback address needs a token")
+
+const TIMEOUT = 10 * time.Second   // for each input of a remote session, see Server.Timeout
+
+// ListenAndServe listens on address of network, "tcp" or "unix", and serves
+// the connections, see Serve
+func (srv *Server) ListenAndServe(network, address string) error {
+    l, err := net.Listen(network, address)
+    if err != nil {
+        return err
+    }
+    defer l.Close()
+
+    return srv.Serve(l)
+}
+
+// Serve accepts connections on l and serves each in a goroutine of its own
+// until accepting fails, which closing l makes it do. It returns that error.
+// Sessions already running go on until their connection is closed.
+func (srv *Server) Serve(l net.Listener) error {
+    if addr, ok := l.Addr().(*net.TCPAddr); ok && srv.Token == "" && !addr.IP.IsLoopback() {
+        return ErrNoToken
+    }
+
+    for {
+        conn, err := l.Accept()
+        if err != nil {
+            return err
+        }
+        go srv.serve(conn)
+    }
+}
+
+func (srv *Server) serve(conn net.Conn) {
+    defer conn.Close()
+
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    out := &connWriter{conn: conn, cancel: cancel}
+
+    stdio := evaluator.NewIO(conn, out, out)
+    editor := lineedit.New(stdio.Stdin, out, nil)
+
+    if srv.Token != "" {
+        token, err := editor.ReadLine("token: ")
+        if err != nil {
+            return
+        }
+        if subtle.ConstantTimeCompare([]byte(token), []byte(srv.Token)) != 1 {
+            io.WriteString(out, "wrong token\n")
+            return
+        }
+    }
+
+    s := newSession(stdio, out, srv.Globals)
+    s.remote = true
+    s.ctx = ctx
+    s.limits = srv.Limits
+    s.timeout = srv.Timeout
+    if s.timeout == 0 {
+        s.timeout = TIMEOUT
+    }
+    if srv.Globals != nil {
+        s.lock = &srv.mu
+    }
+    s.loop(editor)
+}
+
+// connWriter writes to a connection and cancels the evaluations of its
+// session once that fails, since nobody is there to see the result
+type connWriter struct {
+    conn net.Conn
+    cancel context.CancelFunc
+}
+
+func (w *connWriter) Write(p []byte) (int, error) {
+    n, err := w.conn.Write(p)
+    if err != nil {
+        w.cancel()
+    }
+    return n, err
+}
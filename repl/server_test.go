@@ -0,0 +1,180 @@
+package repl
+
+import (
+	"io"
+	"monkey/evaluator"
+	"monkey/object"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serve starts srv on a listener of network and returns a function sending
+// input on a new connection and returning all it got back
+func serve(t *testing.T, srv *Server, network, address string) func(input string) string {
+    l, err := net.Listen(network, address)
+    if err != nil {
+        t.Fatalf("listen: %v", err)
+    }
+    t.Cleanup(func() { l.Close() })
+    go srv.Serve(l)
+
+    return func(input string) string {
+        conn, err := net.Dial(l.Addr().Network(), l.Addr().String())
+        if err != nil {
+            t.Fatalf("dial: %v", err)
+        }
+        defer conn.Close()
+
+        io.WriteString(conn, input)
+        conn.(interface{ CloseWrite() error }).CloseWrite()
+
+        out, err := io.ReadAll(conn)
+        if err != nil {
+            t.Fatalf("read: %v", err)
+        }
+        return string(out)
+    }
+}
+
+func TestServerSessions(t *testing.T) {
+    send := serve(t, &Server{}, "tcp", "127.0.0.1:0")
+
+    tests := []struct {
+        input string
+        expected string
+    }{
+        {"let a = 2\na * 21\nputs(\"hi\")\n", PROMPT + PROMPT + "42\n" + PROMPT + "hi\nnull\n" + PROMPT},
+        // each connection starts afresh
+        {"a\n", PROMPT + "ERROR: identifier not found: a\n" + PROMPT},
+        {"let x = input()\nmonkey\nx\n", PROMPT + PROMPT + "\"monkey\"\n" + PROMPT},
+        {"let b = [\n3]\n:env\n", PROMPT + CONTINUATION_PROMPT + PROMPT + "b = [3]\n" + PROMPT},
+        {":type 1\n", PROMPT + "INTEGER\n" + PROMPT},
+    }
+
+    for _, tt := range tests {
+        if got := send(tt.input); got != tt.expected {
+            t.Errorf("wrong output for %q.\nexpected=%q\ngot=%q", tt.input, tt.expected, got)
+        }
+    }
+}
+
+func TestServerGlobals(t *testing.T) {
+    globals := object.NewEnvironment()
+    globals.Set("count", &object.Integer{Value: 41})
+    globals.Set("state", &object.Array{})
+
+    send := serve(t, &Server{Globals: globals}, "unix", filepath.Join(t.TempDir(), "repl.sock"))
+
+    expected := PROMPT + "42\n" + PROMPT + PROMPT + "[1]\n" + PROMPT
+    if got := send("count + 1\nlet mine = 1\npush(state, mine)\n"); got != expected {
+        t.Errorf("wrong output.\nexpected=%q\ngot=%q", expected, got)
+    }
+
+    if _, ok := globals.Get("mine"); ok {
+        t.Errorf("let of a session bound in the globals")
+    }
+    if got := send("state\n:reset\ncount\n"); got != PROMPT + "[]\n" + PROMPT + "session reset\n" + PROMPT + "41\n" + PROMPT {
+        t.Errorf("wrong output after reset. got=%q", got)
+    }
+}
+
+func TestServerToken(t *testing.T) {
+    send := serve(t, &Server{Token: "secret"}, "tcp", "127.0.0.1:0")
+
+    if got := send("guess\n1 + 1\n"); got != "token: wrong token\n" {
+        t.Errorf("wrong output for a wrong token. got=%q", got)
+    }
+    if got := send(""); got != "token: " {
+        t.Errorf("wrong output without a token. got=%q", got)
+    }
+    if got := send("secret\n1 + 1\n"); got != "token: " + PROMPT + "2\n" + PROMPT {
+        t.Errorf("wrong output for the right token. got=%q", got)
+    }
+}
+
+func TestServerFiles(t *testing.T) {
+    send := serve(t, &Server{}, "tcp", "127.0.0.1:0")
+
+    secret := filepath.Join(t.TempDir(), "secret")
+    if err := os.WriteFile(secret, []byte("let leaked = 1"), 0600); err != nil {
+        t.Fatal(err)
+    }
+    written := filepath.Join(t.TempDir(), "written")
+
+    expected := PROMPT + "1\n" +
+        PROMPT + "unknown command :save, :help lists the commands\n" +
+        PROMPT + "unknown command :load, :help lists the commands\n" +
+        PROMPT + "ERROR: identifier not found: leaked\n" + PROMPT
+    if got := send("1\n:save " + written + "\n:load " + secret + "\nleaked\n"); got != expected {
+        t.Errorf("wrong output.\nexpected=%q\ngot=%q", expected, got)
+    }
+    if _, err := os.Stat(written); !os.IsNotExist(err) {
+        t.Errorf("remote session wrote a file: %v", err)
+    }
+
+    if got := send(":help\n"); strings.Contains(got, ":load") || strings.Contains(got, ":save") {
+        t.Errorf("help offers file commands. got=%q", got)
+    }
+}
+
+func TestServerNeedsToken(t *testing.T) {
+    l, err := net.Listen("tcp", "0.0.0.0:0")
+    if err != nil {
+        t.Fatalf("listen: %v", err)
+    }
+    defer l.Close()
+
+    if err := (&Server{}).Serve(l); err != ErrNoToken {
+        t.Errorf("expected ErrNoToken serving all interfaces without a token. got=%v", err)
+    }
+}
+
+func TestServerLimits(t *testing.T) {
+    loop := "let f = fn() { f() }; f()\n"
+
+    send := serve(t, &Server{Globals: object.NewEnvironment(), Limits: evaluator.Limits{MaxSteps: 10000}}, "tcp", "127.0.0.1:0")
+    expected := PROMPT + "ERROR: step budget of 10000 exceeded\n" + PROMPT + "1\n" + PROMPT
+    if got := send(loop + "1\n"); got != expected {
+        t.Errorf("wrong output with a step budget.\nexpected=%q\ngot=%q", expected, got)
+    }
+
+    send = serve(t, &Server{Timeout: 10 * time.Millisecond}, "tcp", "127.0.0.1:0")
+    expected = PROMPT + "ERROR: evaluation stopped: context deadline exceeded\n" + PROMPT
+    if got := send(loop); got != expected {
+        t.Errorf("wrong output with a timeout.\nexpected=%q\ngot=%q", expected, got)
+    }
+}
+
+// a client going away stops its evaluation, which would keep the others from
+// getting at the globals otherwise
+func TestServerDisconnect(t *testing.T) {
+    srv := &Server{Globals: object.NewEnvironment(), Timeout: time.Hour}
+    address := filepath.Join(t.TempDir(), "repl.sock")
+    send := serve(t, srv, "unix", address)
+
+    conn, err := net.Dial("unix", address)
+    if err != nil {
+        t.Fatalf("dial: %v", err)
+    }
+    io.WriteString(conn, "let f = fn() { puts(1); f() }; f()\n")
+    // wait for the loop to start before going away
+    if _, err := io.ReadFull(conn, make([]byte, len(PROMPT) + 2)); err != nil {
+        t.Fatalf("read: %v", err)
+    }
+    conn.Close()
+
+    done := make(chan string)
+    go func() { done <- send("1\n") }()
+    select {
+    case got := <-done:
+        if got != PROMPT + "1\n" + PROMPT {
+            t.Errorf("wrong output. got=%q", got)
+        }
+    case <-time.After(5 * time.Second):
+        t.Fatalf("evaluation of a closed connection still holds the globals")
+    }
+}
@@ -0,0 +1,263 @@
+// Package resolver works out statically where each variable of a parsed
+// program lives. Locals of functions are given slots in the frame of their
+// function, which lets the evaluator store them in arrays instead of looking
+// them up by name through a chain of maps. Globals and builtins are still
+// looked up by name, since scripts and embedders may define them at any time.
+package resolver
+
+import (
+	"fmt"
+	"monkey/ast"
+	"monkey/object"
+)
+
+// Resolve marks every function literal of program as resolved and every
+// identifier naming a local with its depth and slot, see ast.Identifier.
+//
+// A variable declared anywhere in a function, including inside if blocks, is
+// local to the whole function, so using it before its let statement has run
+// is an error even when a global of the same name exists. catch blocks have
+// their own scope for the error and the variables declared in them.
+//
+// Resolve returns a message for each name that is neither a local, declared
+// at the top level of program nor defined in env, which may be nil. Those
+// are only errors if the name is still undefined when the code runs.
+func Resolve(program *ast.Program, env *object.Environment) []string {
+    r := &resolver{
+        globals: make(map[string]bool),
+        reported: make(map[string]bool),
+        env: env,
+    }
+
+    for _, statement := range program.Statements {
+        collectLets(statement, true, func(name *ast.Identifier) {
+            r.globals[name.Value] = true
+        })
+    }
+
+    r.resolve(program)
+
+    return r.errors
+}
+
+type resolver struct {
+    functions []*function       // innermost last
+    globals map[string]bool     // names declared outside of functions
+    reported map[string]bool
+    env *object.Environment
+    errors []string
+}
+
+type function struct {
+    scopes []map[string]int     // innermost last
+    slots int
+}
+
+func (r *resolver) resolve(node ast.Node) {
+    switch node := node.(type) {
+    case *ast.Program:
+        for _, statement := range node.Statements {
+            r.resolve(statement)
+        }
+    case *ast.BlockStatement:
+        for _, statement := range node.Statements {
+            r.resolve(statement)
+        }
+    case *ast.LetStatement:
+        r.resolve(node.Value)
+        r.lookup(node.Name)
+    case *ast.ReturnStatement:
+        r.resolve(node.ReturnValue)
+    case *ast.ThrowStatement:
+        r.resolve(node.Value)
+    case *ast.ExpressionStatement:
+        r.resolve(node.Expression)
+    case *ast.Identifier:
+        r.lookup(node)
+    case *ast.PrefixExpression:
+        r.resolve(node.Right)
+    case *ast.InfixExpression:
+        r.resolve(node.Left)
+        r.resolve(node.Right)
+    case *ast.IfExpression:
+        r.resolve(node.Condition)
+        r.resolve(node.Consequence)
+        if node.Alternative != nil {
+            r.resolve(node.Alternative)
+        }
+    case *ast.TryExpression:
+        r.resolve(node.Block)
+        for _, clause := range node.Catches {
+            r.resolveCatch(clause)
+        }
+        if node.Finally != nil {
+            r.resolve(node.Finally)
+        }
+    case *ast.FunctionLiteral:
+        r.resolveFunction(node)
+    case *ast.CallExpression:
+        r.resolve(node.Function)
+        for _, arg := range node.Arguments {
+            r.resolve(arg)
+        }
+    case *ast.ArrayLiteral:
+        for _, elem := range node.Elements {
+            r.resolve(elem)
+        }
+    case *ast.IndexExpression:
+        r.resolve(node.Left)
+        r.resolve(node.Index)
+    case *ast.HashLiteral:
+        for _, pair := range node.Pairs {
+            r.resolve(pair.Key)
+            r.resolve(pair.Value)
+        }
+    }
+}
+
+func (r *resolver) resolveFunction(fl *ast.FunctionLiteral) {
+    fn := &function{}
+    r.functions = append(r.functions, fn)
+    fn.scopes = append(fn.scopes, make(map[string]int))
+
+    // parameters take the first slots, in order
+    for _, param := range fl.Parameters {
+        fn.scopes[0][param.Value] = fn.slots
+        fn.slots++
+        r.lookup(param)
+    }
+
+    collectLets(fl.Body, false, r.declare)
+    r.resolve(fl.Body)
+
+    fl.Resolved = true
+    fl.Slots = fn.slots
+    r.functions = r.functions[:len(r.functions)-1]
+}
+
+func (r *resolver) resolveCatch(clause *ast.CatchClause) {
+    // outside of functions the evaluator gives catch blocks their own
+    // environment, and the names in it are looked up by name like globals
+    if len(r.functions) == 0 {
+        r.resolve(clause.Body)
+        return
+    }
+
+    fn := r.functions[len(r.functions)-1]
+    fn.scopes = append(fn.scopes, make(map[string]int))
+
+    if clause.Parameter != nil {
+        r.declare(clause.Parameter)
+        r.lookup(clause.Parameter)
+    }
+    collectLets(clause.Body, false, r.declare)
+    r.resolve(clause.Body)
+
+    fn.scopes = fn.scopes[:len(fn.scopes)-1]
+}
+
+// declare gives name a slot in the innermost scope, unless it already has one
+// there: declaring a variable twice reuses its slot
+func (r *resolver) declare(name *ast.Identifier) {
+    fn := r.functions[len(r.functions)-1]
+    scope := fn.scopes[len(fn.scopes)-1]
+
+    if _, ok := scope[name.Value]; !ok {
+        scope[name.Value] = fn.slots
+        fn.slots++
+    }
+}
+
+func (r *resolver) lookup(ident *ast.Identifier) {
+    for i := len(r.functions) - 1; i >= 0; i-- {
+        scopes := r.functions[i].scopes
+        for j := len(scopes) - 1; j >= 0; j-- {
+            if slot, ok := scopes[j][ident.Value]; ok {
+                ident.Local = true
+                ident.Depth = len(r.functions) - 1 - i
+                ident.Slot = slot
+                return
+            }
+        }
+    }
+
+    ident.Local = false
+    if r.globals[ident.Value] || r.reported[ident.Value] {
+        return
+    }
+    if r.env != nil {
+        if _, ok := r.env.Get(ident.Value); ok {
+            return
+        }
+    }
+
+    r.reported[ident.Value] = true
+    r.errors = append(r.errors, fmt.Sprintf("undefined variable: %s", ident.Value))
+}
+
+// collectLets calls declare for the names bound by let statements in node,
+// without entering function literals, which are scopes of their own, nor
+// catch blocks, unless intoCatch is set. The parameters of catch blocks
+// count as declared when their blocks are entered.
+func collectLets(node ast.Node, intoCatch bool, declare func(*ast.Identifier)) {
+    collect := func(node ast.Node) {
+        collectLets(node, intoCatch, declare)
+    }
+
+    switch node := node.(type) {
+    case *ast.BlockStatement:
+        for _, statement := range node.Statements {
+            collect(statement)
+        }
+    case *ast.LetStatement:
+        declare(node.Name)
+        collect(node.Value)
+    case *ast.ReturnStatement:
+        collect(node.ReturnValue)
+    case *ast.ThrowStatement:
+        collect(node.Value)
+    case *ast.ExpressionStatement:
+        collect(node.Expression)
+    case *ast.PrefixExpression:
+        collect(node.Right)
+    case *ast.InfixExpression:
+        collect(node.Left)
+        collect(node.Right)
+    case *ast.IfExpression:
+        collect(node.Condition)
+        collect(node.Consequence)
+        if node.Alternative != nil {
+            collect(node.Alternative)
+        }
+    case *ast.TryExpression:
+        collect(node.Block)
+        if intoCatch {
+            for _, clause := range node.Catches {
+                if clause.Parameter != nil {
+                    declare(clause.Parameter)
+                }
+                collect(clause.Body)
+            }
+        }
+        if node.Finally != nil {
+            collect(node.Finally)
+        }
+    case *ast.CallExpression:
+        collect(node.Function)
+        for _, arg := range node.Arguments {
+            collect(arg)
+        }
+    case *ast.ArrayLiteral:
+        for _, elem := range node.Elements {
+            collect(elem)
+        }
+    case *ast.IndexExpression:
+        collect(node.Left)
+        collect(node.Index)
+    case *ast.HashLiteral:
+        for _, pair := range node.Pairs {
+            collect(pair.Key)
+            collect(pair.Value)
+        }
+    }
+}
@@ -0,0 +1,145 @@
+package resolver
+
+import (
+	"monkey/ast"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"testing"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+    p := parser.New(lexer.New(input))
+    program := p.ParseProgram()
+    if len(p.Errors()) != 0 {
+        t.Fatalf("parser errors: %v", p.Errors())
+    }
+    return program
+}
+
+// identifiers lists the identifiers of a tree of infix expressions, left to right
+func identifiers(exp ast.Expression) []*ast.Identifier {
+    switch exp := exp.(type) {
+    case *ast.Identifier:
+        return []*ast.Identifier{exp}
+    case *ast.InfixExpression:
+        return append(identifiers(exp.Left), identifiers(exp.Right)...)
+    default:
+        return nil
+    }
+}
+
+func TestResolveSlots(t *testing.T) {
+    input := `
+    let g = 1;
+    let f = fn(a, b) {
+        let c = a + b;
+        let inner = fn(x) { x + c + a + g + b };
+        inner
+    };
+    `
+    program := parse(t, input)
+    if errs := Resolve(program, nil); len(errs) != 0 {
+        t.Fatalf("unexpected errors: %v", errs)
+    }
+
+    outer := program.Statements[1].(*ast.LetStatement).Value.(*ast.FunctionLiteral)
+    if !outer.Resolved || outer.Slots != 4 {
+        t.Errorf("outer function wrong. Resolved=%t, Slots=%d", outer.Resolved, outer.Slots)
+    }
+
+    let := outer.Body.Statements[1].(*ast.LetStatement)
+    if !let.Name.Local || let.Name.Depth != 0 || let.Name.Slot != 3 {
+        t.Errorf("inner is not in slot 3. got %+v", let.Name)
+    }
+
+    inner := let.Value.(*ast.FunctionLiteral)
+    if !inner.Resolved || inner.Slots != 1 {
+        t.Errorf("inner function wrong. Resolved=%t, Slots=%d", inner.Resolved, inner.Slots)
+    }
+
+    tests := []struct {
+        name string
+        local bool
+        depth int
+        slot int
+    }{
+        {"x", true, 0, 0},
+        {"c", true, 1, 2},
+        {"a", true, 1, 0},
+        {"g", false, 0, 0},
+        {"b", true, 1, 1},
+    }
+
+    idents := identifiers(inner.Body.Statements[0].(*ast.ExpressionStatement).Expression)
+    if len(idents) != len(tests) {
+        t.Fatalf("wrong number of identifiers. got=%d", len(idents))
+    }
+
+    for i, tt := range tests {
+        ident := idents[i]
+        if ident.Value != tt.name || ident.Local != tt.local || ident.Depth != tt.depth || ident.Slot != tt.slot {
+            t.Errorf("identifier %d wrong. expected %s local=%t depth=%d slot=%d, got %s local=%t depth=%d slot=%d",
+                i, tt.name, tt.local, tt.depth, tt.slot, ident.Value, ident.Local, ident.Depth, ident.Slot)
+        }
+    }
+}
+
+func TestResolveScopes(t *testing.T) {
+    input := `
+    fn(e) {
+        if (e) { let late = 1 };
+        try { 1 } catch (e) { let inside = e; inside };
+        e + late
+    }
+    `
+    program := parse(t, input)
+    Resolve(program, nil)
+
+    fl := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+    // e and late in the function, e and inside in the catch block
+    if fl.Slots != 4 {
+        t.Errorf("wrong number of slots. got=%d", fl.Slots)
+    }
+
+    clause := fl.Body.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.TryExpression).Catches[0]
+    if clause.Parameter.Slot == 0 {
+        t.Errorf("catch parameter shares the slot of the function parameter")
+    }
+
+    idents := identifiers(fl.Body.Statements[2].(*ast.ExpressionStatement).Expression)
+    if idents[0].Slot != 0 || idents[1].Slot != 1 {
+        t.Errorf("e and late should be in slots 0 and 1. got %d and %d", idents[0].Slot, idents[1].Slot)
+    }
+}
+
+func TestResolveUndefined(t *testing.T) {
+    env := object.NewEnvironment()
+    env.Set("known", object.NULL)
+
+    tests := []struct {
+        input string
+        expected []string
+    }{
+        {`let a = 1; a + known`, nil},
+        {`let f = fn() { later() }; let later = fn() { 1 }`, nil},
+        {`if (true) { let nested = 1 }; nested`, nil},
+        {`try { 1 } catch (e) { e }`, nil},
+        {`fn(x) { x + y + z + y }`, []string{"undefined variable: y", "undefined variable: z"}},
+        {`fn() { try { 1 } catch (e) { 2 }; e }`, []string{"undefined variable: e"}},
+        {`fn() { let local = 1 }; local`, []string{"undefined variable: local"}},
+    }
+
+    for _, tt := range tests {
+        errs := Resolve(parse(t, tt.input), env)
+        if len(errs) != len(tt.expected) {
+            t.Errorf("%s: wrong errors. expected=%v, got=%v", tt.input, tt.expected, errs)
+            continue
+        }
+        for i := range errs {
+            if errs[i] != tt.expected[i] {
+                t.Errorf("%s: wrong error. expected=%q, got=%q", tt.input, tt.expected[i], errs[i])
+            }
+        }
+    }
+}
@@ -1,5 +1,9 @@
 package token
 
+import (
+	"sort"
+)
+
 const (
     ILLEGAL = "ILLEGAL"
     EOF = "EOF"
@@ -7,6 +11,7 @@ const (
     // identifiers and literals
     IDENT = "IDENT"
     INT = "INT"
+    FLOAT = "FLOAT"
     STRING = "STRING"
 
     // operators
@@ -41,6 +46,11 @@ const (
     IF = "IF"
     ELSE = "ELSE"
     RETURN = "RETURN"
+    NULL = "NULL"
+    TRY = "TRY"
+    CATCH = "CATCH"
+    FINALLY = "FINALLY"
+    THROW = "THROW"
 )
 
 // can this be an enum???
@@ -49,6 +59,7 @@ type TokenType string
 type Token struct {
     Type TokenType
     Literal string // value of the token
+    Line int       // where the token starts, counting from 1
 }
 
 var keywords = map[string]TokenType {
@@ -59,6 +70,11 @@ var keywords = map[string]TokenType {
     "if": IF,
     "else": ELSE,
     "return": RETURN,
+    "null": NULL,
+    "try": TRY,
+    "catch": CATCH,
+    "finally": FINALLY,
+    "throw": THROW,
 }
 
 func LookupIdentifier(ident string) TokenType {
@@ -67,3 +83,13 @@ func LookupIdentifier(ident string) TokenType {
     }
     return IDENT
 }
+
+// Keywords returns the reserved words, sorted
+func Keywords() []string {
+    words := make([]string, 0, len(keywords))
+    for word := range keywords {
+        words = append(words, word)
+    }
+    sort.Strings(words)
+    return words
+}
@@ -0,0 +1,514 @@
+// Package vm runs the bytecode produced by the compiler on a stack machine.
+// It computes exactly what the evaluator would for the same program: values,
+// builtins, operators and errors all come from the object and evaluator
+// packages, and the vm only replaces walking the tree.
+package vm
+
+import (
+	"context"
+	"fmt"
+	"monkey/code"
+	"monkey/compiler"
+	"monkey/evaluator"
+	"monkey/object"
+)
+
+var (
+    NULL = object.NULL
+    TRUE = object.TRUE
+    FALSE = object.FALSE
+)
+
+// Run runs bytecode with env as its global scope until it completes and
+// returns the value of the program or the error it stopped with
+func Run(bytecode *compiler.Bytecode, env *object.Environment) object.Object {
+    return RunContext(context.Background(), bytecode, env, evaluator.Limits{})
+}
+
+// RunContext runs bytecode like Run, but gives up with a LimitError once ctx
+// is done or the program goes over limits. Every instruction counts as a step.
+func RunContext(ctx context.Context, bytecode *compiler.Bytecode, env *object.Environment, limits evaluator.Limits) object.Object {
+    main := &object.Closure{
+        Fn: &object.CompiledFunction{
+            Instructions: bytecode.Instructions,
+            SourceMap: bytecode.SourceMap,
+            Constants: bytecode.Constants,
+        },
+        Env: env,
+    }
+
+    vm := &vm{
+        Meter: evaluator.NewMeter(ctx, limits),
+        stack: make([]object.Object, 0, 256),
+    }
+    vm.frames = append(vm.frames, newFrame(main, env, 0))
+
+    return vm.run()
+}
+
+// vm holds the state of a single call to Run
+type vm struct {
+    *evaluator.Meter
+    stack []object.Object
+    frames []frame              // the main program first
+    result object.Object        // set once the main program returns
+}
+
+type frame struct {
+    cl *object.Closure
+    ip int                      // the next instruction to run
+    env *object.Environment     // holds the locals in its slots
+    base int                    // the height of the stack when the frame was entered
+    handlers []handler          // enclosing try expressions, innermost last
+}
+
+func newFrame(cl *object.Closure, env *object.Environment, base int) frame {
+    return frame{cl: cl, env: env, base: base}
+}
+
+// handler is set up by a try expression. Errors raised while it is on top go
+// to its catch clauses, or its finally block once they have been entered.
+type handler struct {
+    catches int                 // code.NoTarget once the catch clauses are running
+    finally int
+    height int                  // the stack is cut back to this height
+    env *object.Environment     // and the scope restored to this one
+}
+
+// completion is what waits on the stack while a finally block runs
+type completion struct {
+    kind completionKind
+    value object.Object
+}
+
+type completionKind int
+
+const (
+    completeNormally completionKind = iota
+    completeReturn
+    completeError
+)
+
+func (c *completion) Type() object.ObjectType {
+    return "COMPLETION"
+}
+
+func (c *completion) Inspect() string {
+    return c.value.Inspect()
+}
+
+func (c *completion) Equals(other object.Object) bool {
+    return c == other
+}
+
+var infixOperators = [...]string{
+    code.OpAdd: "+",
+    code.OpSub: "-",
+    code.OpMul: "*",
+    code.OpDiv: "/",
+    code.OpEqual: "==",
+    code.OpNotEqual: "!=",
+    code.OpLessThan: "<",
+    code.OpGreaterThan: ">",
+}
+
+func (vm *vm) run() object.Object {
+    for vm.result == nil {
+        if err := vm.Step(); err != nil {
+            vm.raise(err)
+            continue
+        }
+
+        // pointers into frames only last until the next call grows it
+        f := &vm.frames[len(vm.frames)-1]
+        ins := f.cl.Fn.Instructions
+        op := code.Opcode(ins[f.ip])
+        f.ip++
+
+        if err := vm.execute(f, op, ins); err != nil {
+            vm.raise(err)
+        }
+    }
+
+    return vm.result
+}
+
+// execute runs the instruction op whose operands start at f.ip. Errors are
+// returned to be raised by the caller.
+func (vm *vm) execute(f *frame, op code.Opcode, ins code.Instructions) *object.Error {
+    switch op {
+    case code.OpConstant:
+        index := code.ReadUint16(ins[f.ip:])
+        f.ip += 2
+        return vm.push(vm.allocate(f.cl.Fn.Constants[index]))
+
+    case code.OpPop:
+        vm.pop()
+    case code.OpTrue:
+        vm.push(TRUE)
+    case code.OpFalse:
+        vm.push(FALSE)
+    case code.OpNull:
+        vm.push(NULL)
+
+    case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpEqual, code.OpNotEqual, code.OpLessThan, code.OpGreaterThan:
+        right := vm.pop()
+        left := vm.pop()
+        if result, ok := integerOperation(op, left, right); ok {
+            return vm.push(result)
+        }
+        return vm.push(vm.allocate(evaluator.InfixOperation(infixOperators[op], left, right)))
+    case code.OpMinus:
+        return vm.push(evaluator.PrefixOperation("-", vm.pop()))
+    case code.OpBang:
+        return vm.push(evaluator.PrefixOperation("!", vm.pop()))
+
+    case code.OpJump:
+        f.ip = int(code.ReadUint32(ins[f.ip:]))
+    case code.OpJumpNotTruthy:
+        target := int(code.ReadUint32(ins[f.ip:]))
+        f.ip += 4
+        if !evaluator.IsTruthy(vm.pop()) {
+            f.ip = target
+        }
+
+    case code.OpGetGlobal:
+        name := f.name(code.ReadUint16(ins[f.ip:]))
+        f.ip += 2
+        if val, ok := f.env.Get(name); ok {
+            return vm.push(val)
+        }
+        if builtin, ok := evaluator.Builtin(name); ok {
+            return vm.push(builtin)
+        }
+        return newError(object.NameError, "identifier not found: %s", name)
+    case code.OpSetGlobal:
+        name := f.name(code.ReadUint16(ins[f.ip:]))
+        f.ip += 2
+        f.env.Set(name, vm.pop())
+    case code.OpGetLocal:
+        depth := int(ins[f.ip])
+        slot := int(code.ReadUint16(ins[f.ip+1:]))
+        name := code.ReadUint16(ins[f.ip+3:])
+        f.ip += 5
+        if val, ok := f.env.GetSlot(depth, slot); ok {
+            return vm.push(val)
+        }
+        return newError(object.NameError, "identifier not found: %s", f.name(name))
+    case code.OpSetLocal:
+        slot := int(code.ReadUint16(ins[f.ip:]))
+        f.ip += 2
+        f.env.SetSlot(slot, vm.pop())
+
+    case code.OpArray:
+        n := int(code.ReadUint16(ins[f.ip:]))
+        f.ip += 2
+        elements := make([]object.Object, n)
+        copy(elements, vm.stack[len(vm.stack)-n:])
+        vm.stack = vm.stack[:len(vm.stack)-n]
+        return vm.push(vm.allocate(&object.Array{Elements: elements}))
+    case code.OpHash:
+        n := int(code.ReadUint16(ins[f.ip:]))
+        f.ip += 2
+        return vm.push(vm.allocate(vm.buildHash(n)))
+    case code.OpIndex:
+        index := vm.pop()
+        left := vm.pop()
+        return vm.push(evaluator.IndexOperation(left, index))
+
+    case code.OpClosure:
+        index := code.ReadUint16(ins[f.ip:])
+        f.ip += 2
+        fn := f.cl.Fn.Constants[index].(*object.CompiledFunction)
+        vm.push(&object.Closure{Fn: fn, Env: f.env})
+    case code.OpCall, code.OpTailCall:
+        argc := int(ins[f.ip])
+        f.ip++
+        return vm.call(argc, op == code.OpTailCall)
+    case code.OpReturnValue:
+        vm.returnValue(vm.pop())
+    case code.OpThrow:
+        return evaluator.ThrownError(vm.pop())
+
+    case code.OpSetupTry:
+        catches := int(code.ReadUint32(ins[f.ip:]))
+        finally := int(code.ReadUint32(ins[f.ip+4:]))
+        f.ip += 8
+        f.handlers = append(f.handlers, handler{
+            catches: catches,
+            finally: finally,
+            height: len(vm.stack),
+            env: f.env,
+        })
+    case code.OpPopHandler:
+        f.handlers = f.handlers[:len(f.handlers)-1]
+    case code.OpCatch:
+        kind := code.ReadUint16(ins[f.ip:])
+        next := int(code.ReadUint32(ins[f.ip+2:]))
+        f.ip += 6
+        errObj := vm.stack[len(vm.stack)-1].(*object.Error)
+        name := ""
+        if kind != code.NoConstant {
+            name = f.name(kind)
+        }
+        if !evaluator.Catches(name, errObj) {
+            f.ip = next
+            return nil
+        }
+        vm.stack[len(vm.stack)-1] = evaluator.ErrorHash(errObj)
+    case code.OpRethrow:
+        return vm.pop().(*object.Error)
+    case code.OpEnterFinally:
+        vm.push(&completion{kind: completeNormally, value: vm.pop()})
+    case code.OpEndFinally:
+        c := vm.pop().(*completion)
+        switch c.kind {
+        case completeReturn:
+            vm.returnValue(c.value)
+        case completeError:
+            return c.value.(*object.Error)
+        default:
+            vm.push(c.value)
+        }
+    case code.OpEnterScope:
+        f.env = object.NewEnclosedEnvironment(f.env)
+    case code.OpLeaveScope:
+        f.env = f.env.Outer()
+
+    default:
+        return newError(object.RuntimeError, "unknown opcode %d", op)
+    }
+
+    return nil
+}
+
+// integerOperation is a shortcut for the most common operations, on two
+// integers. Everything else, division included for its error on zero, is left
+// to evaluator.InfixOperation.
+func integerOperation(op code.Opcode, left, right object.Object) (object.Object, bool) {
+    l, ok := left.(*object.Integer)
+    if !ok {
+        return nil, false
+    }
+    r, ok := right.(*object.Integer)
+    if !ok {
+        return nil, false
+    }
+
+    switch op {
+    case code.OpAdd:
+        return &object.Integer{Value: l.Value + r.Value}, true
+    case code.OpSub:
+        return &object.Integer{Value: l.Value - r.Value}, true
+    case code.OpMul:
+        return &object.Integer{Value: l.Value * r.Value}, true
+    case code.OpEqual:
+        return nativeBool(l.Value == r.Value), true
+    case code.OpNotEqual:
+        return nativeBool(l.Value != r.Value), true
+    case code.OpLessThan:
+        return nativeBool(l.Value < r.Value), true
+    case code.OpGreaterThan:
+        return nativeBool(l.Value > r.Value), true
+    default:
+        return nil, false
+    }
+}
+
+func nativeBool(val bool) *object.Boolean {
+    if val {
+        return TRUE
+    }
+    return FALSE
+}
+
+// push pushes obj unless it is an error, which is returned to be raised
+func (vm *vm) push(obj object.Object) *object.Error {
+    if errObj, ok := obj.(*object.Error); ok {
+        return errObj
+    }
+
+    vm.stack = append(vm.stack, obj)
+    return nil
+}
+
+func (vm *vm) pop() object.Object {
+    obj := vm.stack[len(vm.stack)-1]
+    vm.stack = vm.stack[:len(vm.stack)-1]
+    return obj
+}
+
+func (vm *vm) allocate(obj object.Object) object.Object {
+    if _, ok := obj.(*object.Error); ok {
+        return obj
+    }
+    return vm.Allocate(obj)
+}
+
+func (f *frame) name(index uint16) string {
+    return f.cl.Fn.Constants[index].(*object.String).Value
+}
+
+func (vm *vm) buildHash(n int) object.Object {
+    pairs := vm.stack[len(vm.stack)-2*n:]
+    vm.stack = vm.stack[:len(vm.stack)-2*n]
+
+    hash := object.NewHash()
+    for i := 0; i < len(pairs); i += 2 {
+        key, ok := object.AsHashable(pairs[i])
+        if !ok {
+            return newError(object.TypeError, "unusable as hash key: %s", pairs[i].Type())
+        }
+        hash.Set(key, pairs[i+1])
+    }
+
+    return hash
+}
+
+// call calls the function below the argc arguments on top of the stack. A
+// tail call replaces the current frame instead of adding one, so it does not
+// count towards the depth limit.
+func (vm *vm) call(argc int, tail bool) *object.Error {
+    callee := vm.stack[len(vm.stack)-1-argc]
+    args := vm.stack[len(vm.stack)-argc:]
+
+    switch fn := callee.(type) {
+    case *object.Closure:
+        if argc != fn.Fn.NumParameters {
+            return newError(object.ArityError, "wrong number of arguments. got=%d, want=%d", argc, fn.Fn.NumParameters)
+        }
+
+        env := object.NewFrame(fn.Env, fn.Fn.NumLocals)
+        for i, arg := range args {
+            env.SetSlot(i, arg)
+        }
+
+        if tail {
+            base := vm.frames[len(vm.frames)-1].base
+            vm.stack = vm.stack[:base]
+            vm.frames[len(vm.frames)-1] = newFrame(fn, env, base)
+            return nil
+        }
+
+        // the main program is not a call
+        if len(vm.frames) > vm.MaxDepth() {
+            return newError(object.RecursionError, "maximum recursion depth exceeded")
+        }
+
+        vm.stack = vm.stack[:len(vm.stack)-1-argc]
+        vm.frames = append(vm.frames, newFrame(fn, env, len(vm.stack)))
+        return nil
+
+    case *object.BuiltIn:
+        // builtins may keep their arguments, which must not alias the stack
+        result := fn.Fn(append([]object.Object(nil), args...)...)
+        vm.stack = vm.stack[:len(vm.stack)-1-argc]
+        return vm.push(vm.allocate(result))
+
+    default:
+        return newError(object.TypeError, "not a function: %s", callee.Type())
+    }
+}
+
+// returnValue returns val from the current frame, running the finally blocks
+// of the try expressions it leaves first
+func (vm *vm) returnValue(val object.Object) {
+    f := &vm.frames[len(vm.frames)-1]
+
+    for len(f.handlers) > 0 {
+        h := f.handlers[len(f.handlers)-1]
+        f.handlers = f.handlers[:len(f.handlers)-1]
+
+        if h.finally != code.NoTarget {
+            vm.enterFinally(f, h, &completion{kind: completeReturn, value: val})
+            return
+        }
+    }
+
+    vm.popFrame()
+    if len(vm.frames) == 0 {
+        vm.result = val
+        return
+    }
+    vm.stack = append(vm.stack, val)
+}
+
+// raise unwinds to the innermost try expression that handles errObj. A
+// LimitError skips catch clauses and finally blocks alike and stops the
+// program.
+func (vm *vm) raise(errObj *object.Error) {
+    limit := errObj.ErrorKind() == object.LimitError
+
+    // the line is where the error was first raised, not where it was rethrown
+    if errObj.Line == 0 {
+        f := &vm.frames[len(vm.frames)-1]
+        errObj.Line = f.cl.Fn.SourceMap.Line(f.ip - 1)
+    }
+
+    for len(vm.frames) > 0 {
+        f := &vm.frames[len(vm.frames)-1]
+
+        for !limit && len(f.handlers) > 0 {
+            h := &f.handlers[len(f.handlers)-1]
+
+            switch {
+            case h.catches != code.NoTarget:
+                catches := h.catches
+                vm.stack = vm.stack[:h.height]
+                f.env = h.env
+                if h.finally != code.NoTarget {
+                    // errors raised by the catch clauses go to the finally block
+                    h.catches = code.NoTarget
+                } else {
+                    f.handlers = f.handlers[:len(f.handlers)-1]
+                }
+                vm.stack = append(vm.stack, errObj)
+                f.ip = catches
+                return
+            case h.finally != code.NoTarget:
+                handler := *h
+                f.handlers = f.handlers[:len(f.handlers)-1]
+                vm.enterFinally(f, handler, &completion{kind: completeError, value: errObj})
+                return
+            default:
+                f.handlers = f.handlers[:len(f.handlers)-1]
+            }
+        }
+
+        if len(vm.frames) == 1 {
+            vm.frames = nil
+            vm.result = errObj
+            return
+        }
+
+        errObj.Stack = append(errObj.Stack, functionName(f.cl.Fn))
+        vm.popFrame()
+    }
+}
+
+func (vm *vm) enterFinally(f *frame, h handler, c *completion) {
+    vm.stack = append(vm.stack[:h.height], c)
+    f.env = h.env
+    f.ip = h.finally
+}
+
+func (vm *vm) popFrame() {
+    last := len(vm.frames) - 1
+    vm.stack = vm.stack[:vm.frames[last].base]
+    vm.frames[last] = frame{}
+    vm.frames = vm.frames[:last]
+}
+
+func functionName(fn *object.CompiledFunction) string {
+    if fn.Name == "" {
+        return "<anonymous>"
+    }
+    return fn.Name
+}
+
+func newError(kind object.ErrorKind, format string, a ...interface{}) *object.Error {
+    return &object.Error{
+        Kind: kind,
+        Message: fmt.Sprintf(format, a...),
+    }
+}
@@ -0,0 +1,219 @@
+package vm
+
+import (
+	"bytes"
+	"context"
+	"monkey/compiler"
+	"monkey/enginetest"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"testing"
+	"time"
+)
+
+func testRun(t *testing.T, input string) object.Object {
+    return testRunWithLimits(t, context.Background(), input, evaluator.Limits{})
+}
+
+func testRunWithLimits(t *testing.T, ctx context.Context, input string, limits evaluator.Limits) object.Object {
+    p := parser.New(lexer.New(input))
+    program := p.ParseProgram()
+    if len(p.Errors()) != 0 {
+        t.Fatalf("parser errors for %q: %v", input, p.Errors())
+    }
+
+    bytecode, err := compiler.Compile(program)
+    if err != nil {
+        t.Fatalf("compiler error for %q: %s", input, err)
+    }
+
+    return RunContext(ctx, bytecode, object.NewEnvironment(), limits)
+}
+
+func TestCorpus(t *testing.T) {
+    enginetest.Run(t, func(input string) object.Object {
+        return testRun(t, input)
+    })
+}
+
+// bytecode loaded back from its binary form has to run like freshly compiled
+func TestCorpusDecoded(t *testing.T) {
+    enginetest.Run(t, func(input string) object.Object {
+        p := parser.New(lexer.New(input))
+        program := p.ParseProgram()
+        if len(p.Errors()) != 0 {
+            t.Fatalf("parser errors for %q: %v", input, p.Errors())
+        }
+
+        bytecode, err := compiler.Compile(program)
+        if err != nil {
+            t.Fatalf("compiler error for %q: %s", input, err)
+        }
+
+        var buf bytes.Buffer
+        if err := bytecode.Encode(&buf); err != nil {
+            t.Fatalf("encoding %q failed: %s", input, err)
+        }
+        decoded, err := compiler.Decode(&buf)
+        if err != nil {
+            t.Fatalf("decoding %q failed: %s", input, err)
+        }
+
+        return Run(decoded, object.NewEnvironment())
+    })
+}
+
+func TestLimits(t *testing.T) {
+    countdown := `let countdown = fn(n) { if (n == 0) { "done" } else { countdown(n - 1) } }; countdown(50)`
+    grow := `let grow = fn(arr, n) { if (n == 0) { len(arr) } else { grow(push(arr, n), n - 1) } }; grow([], 1000)`
+    deep := `let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; `
+
+    tests := []struct {
+        input string
+        limits evaluator.Limits
+        expected *object.Error
+    }{
+        {countdown, evaluator.Limits{MaxSteps: 100}, &object.Error{Kind: object.LimitError, Message: "step budget of 100 exceeded"}},
+        // limits cannot be caught, nor hidden by a finally block returning
+        {`try { ` + countdown + ` } catch (e) { "caught" }`, evaluator.Limits{MaxSteps: 100}, &object.Error{Kind: object.LimitError, Message: "step budget of 100 exceeded"}},
+        {`let f = fn() { try { ` + countdown + ` } finally { return "finally" } }; f()`, evaluator.Limits{MaxSteps: 100}, &object.Error{Kind: object.LimitError, Message: "step budget of 100 exceeded"}},
+        {grow, evaluator.Limits{MaxMemory: 100000}, &object.Error{Kind: object.LimitError, Message: "memory limit of 100000 bytes exceeded"}},
+        {`let f = fn() { try { ` + grow + ` } finally { return 1 } }; f()`, evaluator.Limits{MaxMemory: 100000}, &object.Error{Kind: object.LimitError, Message: "memory limit of 100000 bytes exceeded"}},
+        {deep + `f(100)`, evaluator.Limits{MaxDepth: 100}, &object.Error{Kind: object.RecursionError, Message: "maximum recursion depth exceeded"}},
+    }
+
+    for _, tt := range tests {
+        testObjectEquals(t, tt.input, testRunWithLimits(t, context.Background(), tt.input, tt.limits), tt.expected)
+    }
+
+    within := []struct {
+        input string
+        limits evaluator.Limits
+        expected object.Object
+    }{
+        {countdown, evaluator.Limits{MaxSteps: 100000}, &object.String{Value: "done"}},
+        {grow, evaluator.Limits{}, &object.Integer{Value: 1000}},
+        {deep + `f(99)`, evaluator.Limits{MaxDepth: 100}, &object.Integer{Value: 99}},
+        // tail calls don't nest
+        {countdown, evaluator.Limits{MaxDepth: 10}, &object.String{Value: "done"}},
+    }
+
+    for _, tt := range within {
+        testObjectEquals(t, tt.input, testRunWithLimits(t, context.Background(), tt.input, tt.limits), tt.expected)
+    }
+}
+
+func TestContextCancellation(t *testing.T) {
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+
+    expected := &object.Error{Kind: object.LimitError, Message: "evaluation stopped: context canceled"}
+    testObjectEquals(t, "1 + 1", testRunWithLimits(t, ctx, "1 + 1", evaluator.Limits{}), expected)
+
+    ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+    defer cancel()
+
+    // never finishes in time on its own
+    fib := `let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; fib(40)`
+    expected = &object.Error{Kind: object.LimitError, Message: "evaluation stopped: context deadline exceeded"}
+    testObjectEquals(t, fib, testRunWithLimits(t, ctx, fib, evaluator.Limits{}), expected)
+}
+
+// globals and builtins live in the environment given to Run, so programs run
+// one after another see each other's definitions, as in the evaluator
+func TestSharedGlobals(t *testing.T) {
+    env := object.NewEnvironment()
+    env.Set("double", &object.BuiltIn{Fn: func(args ...object.Object) object.Object {
+        return &object.Integer{Value: args[0].(*object.Integer).Value * 2}
+    }})
+
+    for _, input := range []string{`let base = 20`, `let add = fn(x) { base + x }`} {
+        bytecode, err := compiler.Compile(parser.New(lexer.New(input)).ParseProgram())
+        if err != nil {
+            t.Fatalf("compiler error: %s", err)
+        }
+        Run(bytecode, env)
+    }
+
+    bytecode, _ := compiler.Compile(parser.New(lexer.New(`double(add(1))`)).ParseProgram())
+    testObjectEquals(t, "double(add(1))", Run(bytecode, env), &object.Integer{Value: 42})
+
+    if add, ok := env.Get("add"); !ok || add.Type() != object.FUNCTION_OBJ {
+        t.Errorf("add is not a function. got=%v", add)
+    }
+}
+
+func TestErrorLines(t *testing.T) {
+    tests := []struct {
+        input string
+        line int
+        message string
+    }{
+        {"1 + true", 1, "line 1: TypeError: type mismatch: INTEGER + BOOLEAN"},
+        {"let a = 1;\nlet b = a + [];\nb", 2, "line 2: TypeError: type mismatch: INTEGER + ARRAY"},
+        // the line the error was raised on, not the call it went through
+        {"let f = fn() {\n  throw \"up\"\n};\n\nf()", 2, "line 2: UserError: up"},
+        // catch clauses get a hash, throwing it raises a new error
+        {"try {\n  1[\"k\"]\n} catch (e) {\n  throw e\n}", 4, ""},
+    }
+
+    for _, tt := range tests {
+        program := parser.New(lexer.New(tt.input)).ParseProgram()
+        bytecode, err := compiler.Compile(program)
+        if err != nil {
+            t.Fatalf("compiler error for %q: %s", tt.input, err)
+        }
+
+        // the source map survives encoding
+        var buf bytes.Buffer
+        if err := bytecode.Encode(&buf); err != nil {
+            t.Fatalf("encoding %q failed: %s", tt.input, err)
+        }
+        decoded, err := compiler.Decode(&buf)
+        if err != nil {
+            t.Fatalf("decoding %q failed: %s", tt.input, err)
+        }
+
+        for _, b := range []*compiler.Bytecode{bytecode, decoded} {
+            errObj, ok := Run(b, object.NewEnvironment()).(*object.Error)
+            if !ok {
+                t.Fatalf("%q did not fail", tt.input)
+            }
+            if errObj.Line != tt.line {
+                t.Errorf("wrong line for %q. expected=%d, got=%d", tt.input, tt.line, errObj.Line)
+            }
+            if tt.message != "" && errObj.Error() != tt.message {
+                t.Errorf("wrong message for %q. expected=%q, got=%q", tt.input, tt.message, errObj.Error())
+            }
+        }
+    }
+}
+
+func testObjectEquals(t *testing.T, input string, obj object.Object, expected object.Object) {
+    if obj == nil || !obj.Equals(expected) || obj.Type() != expected.Type() {
+        t.Errorf("%s: expected %s %s. got %T (%+v)", input, expected.Type(), expected.Inspect(), obj, obj)
+    }
+}
+
+const benchmarkProgram = `
+let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } };
+let sum = fn(n, acc) { if (n == 0) { acc } else { let next = acc + n; sum(n - 1, next) } };
+let closures = fn(a, b) { let c = a + b; let f = fn(x) { x + a + b + c }; f(1) + f(2) };
+[fib(15), sum(2000, 0), closures(1, 2)]
+`
+
+func BenchmarkRun(b *testing.B) {
+    program := parser.New(lexer.New(benchmarkProgram)).ParseProgram()
+    bytecode, err := compiler.Compile(program)
+    if err != nil {
+        b.Fatal(err)
+    }
+
+    b.ReportAllocs()
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        Run(bytecode, object.NewEnvironment())
+    }
+}